@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+const (
+	// Polling interval of RFC 8628 section 3.5 used if the server sets none.
+	defaultPollInterval = 5 * time.Second
+	// Grant type of device codes at the token endpoint.
+	grantDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// DeviceAuthorization starts the RFC 8628 device flow of the public client.
+// Show the user code and the verification URI to the user, then call PollDeviceToken.
+func (c *HTTPClient) DeviceAuthorization(ctx context.Context, clientID string, scopes ...string) (*types.DeviceAuthorization, error) {
+	form := url.Values{"client_id": {clientID}}
+	if len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+
+	da := new(types.DeviceAuthorization)
+	if err := c.postForm(ctx, "/oauth2/device_authorization", form, da); err != nil {
+		return nil, err
+	}
+
+	return da, nil
+}
+
+// PollDeviceToken polls the token endpoint until the user approves the device.
+// It returns an *types.OAuthError if the user denies access or the code expires.
+func (c *HTTPClient) PollDeviceToken(ctx context.Context, clientID string, da *types.DeviceAuthorization) (*types.TokenPair, error) {
+	interval := time.Duration(da.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	form := url.Values{
+		"grant_type":  {grantDeviceCode},
+		"device_code": {da.DeviceCode},
+		"client_id":   {clientID},
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		pair := new(types.TokenPair)
+		err := c.postForm(ctx, "/oauth2/token", form, pair)
+		var oauthErr *types.OAuthError
+		switch {
+		case err == nil:
+			return pair, nil
+		case !errors.As(err, &oauthErr):
+			return nil, err
+		case oauthErr.Code == types.OAuthSlowDown:
+			interval += defaultPollInterval
+		case oauthErr.Code != types.OAuthAuthorizationPending:
+			return nil, err
+		}
+		timer.Reset(interval)
+	}
+}
+
+// Posts the form to the OAuth 2.0 endpoint and decodes the response into v.
+// Error responses are returned as *types.OAuthError.
+func (c *HTTPClient) postForm(ctx context.Context, path string, form url.Values, v any) error {
+	url := fmt.Sprintf("%s://%s%s", c.scheme, c.host, path)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		oauthErr := new(types.OAuthError)
+		if err := json.NewDecoder(resp.Body).Decode(oauthErr); err != nil || oauthErr.Code == "" {
+			return fmt.Errorf("server responded with non OK status: %d", resp.StatusCode)
+		}
+		return oauthErr
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
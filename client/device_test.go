@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestPollDeviceToken(t *testing.T) {
+	tests := map[string]struct {
+		responses []string
+		wantErr   string
+	}{
+		"approved after pending": {
+			responses: []string{types.OAuthAuthorizationPending, ""},
+		},
+		"denied": {
+			responses: []string{types.OAuthAccessDenied},
+			wantErr:   types.OAuthAccessDenied,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			polls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/oauth2/device_authorization":
+					_ = json.NewEncoder(w).Encode(types.DeviceAuthorization{DeviceCode: "device-code", UserCode: "BCDF-GHJK", Interval: 1})
+				case "/oauth2/token":
+					if r.PostFormValue("device_code") != "device-code" || r.PostFormValue("grant_type") != oauth.GrantDeviceCode {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					code := tt.responses[polls]
+					polls++
+					if code != "" {
+						w.WriteHeader(http.StatusBadRequest)
+						_ = json.NewEncoder(w).Encode(oauth.NewError(code, ""))
+						return
+					}
+					_ = json.NewEncoder(w).Encode(types.TokenPair{AccessToken: "access-token", TokenType: "Bearer"})
+				}
+			}))
+			defer srv.Close()
+
+			c := NewHTPPClient(strings.TrimPrefix(srv.URL, "http://"))
+			ctx := context.Background()
+			da, err := c.DeviceAuthorization(ctx, "cli", "openid")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pair, err := c.PollDeviceToken(ctx, "cli", da)
+			var oauthErr *types.OAuthError
+			if tt.wantErr != "" {
+				if !errors.As(err, &oauthErr) || oauthErr.Code != tt.wantErr {
+					t.Errorf("error is not the same: want=%s, got=%v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || pair.AccessToken != "access-token" {
+				t.Errorf("token should be returned: %+v, %v", pair, err)
+			}
+			if polls != len(tt.responses) {
+				t.Errorf("polls are not the same: want=%d, got=%d", len(tt.responses), polls)
+			}
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package client
 
 import (
+	"context"
+	"strings"
+
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 
+	"github.com/danblok/auth/pkg/types"
 	"github.com/danblok/auth/proto"
 )
 
@@ -12,6 +17,7 @@ import (
 func NewGRPCClient(addr string) (proto.TokenServiceClient, error) {
 	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(requestIDInterceptor),
 	}
 	conn, err := grpc.Dial(addr, opts...)
 	if err != nil {
@@ -25,6 +31,7 @@ func NewGRPCClient(addr string) (proto.TokenServiceClient, error) {
 func NewGRPCClientTLS(addr string, creds credentials.TransportCredentials) (proto.TokenServiceClient, error) {
 	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(requestIDInterceptor),
 	}
 	conn, err := grpc.Dial(addr, opts...)
 	if err != nil {
@@ -33,3 +40,12 @@ func NewGRPCClientTLS(addr string, creds credentials.TransportCredentials) (prot
 
 	return proto.NewTokenServiceClient(conn), nil
 }
+
+// Sends the request id of the context in the metadata of calls.
+func requestIDInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if id := types.RequestIDFrom(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(types.RequestIDHeader), id)
+	}
+
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/danblok/auth/pkg/types"
+	"github.com/danblok/auth/proto"
+)
+
+func TestGRPCRequestID(t *testing.T) {
+	var got []string
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		got = md.Get("x-request-id")
+		return handler(ctx, req)
+	}))
+	proto.RegisterTokenServiceServer(srv, proto.UnimplementedTokenServiceServer{})
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(srv.Stop)
+
+	c, err := NewGRPCClient(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = c.Validate(types.WithRequestID(context.Background(), "req-42"), &proto.ValidateRequest{Token: "token"})
+	if len(got) != 1 || got[0] != "req-42" {
+		t.Errorf("request ids are not the same: want=%q, got=%v", "req-42", got)
+	}
+}
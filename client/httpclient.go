@@ -19,14 +19,19 @@ import (
 type HTTPClient struct {
 	client *http.Client
 	host   string
+	scheme string
+	// Bearer token of the admin API, which issues tokens.
+	adminToken string
 }
 
 // NewHTPPClient constructs a new HTTPClient with given host of the Token service server.
 func NewHTPPClient(host string) *HTTPClient {
 	return &HTTPClient{
-		host: host,
+		host:   host,
+		scheme: "http",
 		client: &http.Client{
-			Timeout: 3 * time.Second,
+			Timeout:   3 * time.Second,
+			Transport: requestIDTransport{next: http.DefaultTransport},
 		},
 	}
 }
@@ -45,26 +50,64 @@ func NewHTPPClientTLS(host string, cert []byte) (*HTTPClient, error) {
 		TLSClientConfig: tlsConfig,
 	}
 	return &HTTPClient{
-		host: host,
+		host:   host,
+		scheme: "https",
 		client: &http.Client{
 			Timeout:   3 * time.Second,
-			Transport: transport,
+			Transport: requestIDTransport{next: transport},
 		},
 	}, nil
 }
 
-// Token fetches a new token and returns it.
-func (c *HTTPClient) Token(ctx context.Context, payload []byte) (*types.TokenResponse, error) {
-	url := fmt.Sprintf("https://%s/token", c.host)
-	body, err := json.Marshal(api.Body{Payload: string(payload)})
+// Sends the request id of the context of requests.
+type requestIDTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip sets the request id header unless the request has one.
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := types.RequestIDFrom(req.Context())
+	if id == "" || req.Header.Get(types.RequestIDHeader) != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set(types.RequestIDHeader, id)
+	return t.next.RoundTrip(req)
+}
+
+// WithAdminToken returns a copy of the client that authenticates
+// requests of the admin API, e.g. Token, with the token.
+func (c *HTTPClient) WithAdminToken(token string) *HTTPClient {
+	cc := *c
+	cc.adminToken = token
+	return &cc
+}
+
+// Token fetches a new token and returns it, it requires the admin token.
+// Options override the lifetime, audience and subject of the token
+// and add custom claims.
+func (c *HTTPClient) Token(ctx context.Context, payload []byte, opts ...types.TokenOption) (*types.TokenResponse, error) {
+	url := fmt.Sprintf("%s://%s/token", c.scheme, c.host)
+	o := types.NewTokenOptions(opts...)
+	body, err := json.Marshal(api.Body{
+		Payload:  string(payload),
+		TTL:      int64(o.TTL / time.Second),
+		Audience: o.Audience,
+		Subject:  o.Subject,
+		Claims:   o.Claims,
+	})
 	if err != nil {
 		return nil, err
 	}
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
-	req.Header.Add("content-type", "application/json")
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Add("content-type", "application/json")
+	if c.adminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.adminToken)
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -91,7 +134,7 @@ func (c *HTTPClient) Token(ctx context.Context, payload []byte) (*types.TokenRes
 
 // Validate sends the given token to the server to validate it and returns validation result.
 func (c *HTTPClient) Validate(ctx context.Context, token []byte) (*types.TokenValidationResponse, error) {
-	url := fmt.Sprintf("https://%s/validate?token=%s", c.host, string(token))
+	url := fmt.Sprintf("%s://%s/validate?token=%s", c.scheme, c.host, string(token))
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -119,3 +162,72 @@ func (c *HTTPClient) Validate(ctx context.Context, token []byte) (*types.TokenVa
 
 	return valid, nil
 }
+
+// Introspect sends the given token to the server and returns its verified claims
+// or the reason why it is not valid.
+func (c *HTTPClient) Introspect(ctx context.Context, token []byte) (*types.Introspection, error) {
+	url := fmt.Sprintf("%s://%s/introspect?token=%s", c.scheme, c.host, string(token))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var httpErr api.HTTPErrResponse
+		if err := json.NewDecoder(resp.Body).Decode(&httpErr); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("server responded with non OK status: %v", httpErr.Error)
+	}
+
+	in := new(types.Introspection)
+	if err := json.NewDecoder(resp.Body).Decode(in); err != nil {
+		return nil, err
+	}
+
+	return in, nil
+}
+
+// Authorize asks the server whether the token grants the permission.
+// Denied permissions aren't errors, the decision has the reason.
+func (c *HTTPClient) Authorize(ctx context.Context, token []byte, permission string) (*types.Decision, error) {
+	url := fmt.Sprintf("%s://%s/authorize", c.scheme, c.host)
+	body, err := json.Marshal(api.AuthorizeBody{Token: string(token), Permission: permission})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("content-type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var httpErr api.HTTPErrResponse
+		if err := json.NewDecoder(resp.Body).Decode(&httpErr); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("server responded with non OK status: %v", httpErr.Error)
+	}
+
+	d := new(types.Decision)
+	if err := json.NewDecoder(resp.Body).Decode(d); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
@@ -2,11 +2,16 @@ package client
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/danblok/auth/internal/api"
+	"github.com/danblok/auth/internal/rbac"
 	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
 )
 
 func TestToken(t *testing.T) {
@@ -16,7 +21,7 @@ func TestToken(t *testing.T) {
 	svc := service.NewJWTService([]byte("secret"))
 
 	go func() {
-		s := api.NewHTTPServer(svc, ":42069")
+		s := api.NewHTTPServer(svc, ":42069", api.WithAdmin("admin-token"))
 		_ = s.Run()
 	}()
 
@@ -24,7 +29,11 @@ func TestToken(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	c := NewHTPPClient("localhost:42069")
-	got, err := c.Token(ctx, payload)
+	if _, err := c.Token(ctx, payload); err == nil {
+		t.Error("token shouldn't be issued without the admin token")
+	}
+
+	got, err := c.WithAdminToken("admin-token").Token(ctx, payload)
 	if got == nil {
 		t.Error("got shouldn't be nil")
 	}
@@ -88,3 +97,89 @@ func TestValidate(t *testing.T) {
 		t.Error("error shouldn't be nil")
 	}
 }
+
+func TestIntrospect(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret"))
+	tkn, _ := svc.Token(ctx, []byte("some payload"), types.WithSubject("user-1"))
+
+	go func() {
+		s := api.NewHTTPServer(svc, ":42069")
+		_ = s.Run()
+	}()
+
+	// 100 ms should be fine for the server to startup
+	time.Sleep(100 * time.Millisecond)
+
+	c := NewHTPPClient("localhost:42069")
+	got, err := c.Introspect(ctx, tkn)
+	if err != nil {
+		t.Fatalf("error should be nil: %v", err)
+	}
+	if !got.Valid || got.Claims["sub"] != "user-1" || got.ExpiresAt == 0 || got.KeyID == "" {
+		t.Errorf("token should be valid with claims: %+v", got)
+	}
+
+	got, err = c.Introspect(ctx, []byte("some-random-text"))
+	if err != nil {
+		t.Fatalf("error should be nil: %v", err)
+	}
+	if got.Valid || got.Reason != types.ReasonMalformed {
+		t.Errorf("token should be malformed: %+v", got)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	svc := rbac.NewRBACService(service.NewJWTService([]byte("secret")), rbac.Roles{})
+	tkn, _ := svc.Token(ctx, nil, types.WithSubject("user-1"), types.WithServerClaims(map[string]any{"scope": "invoices:read"}))
+
+	go func() {
+		s := api.NewHTTPServer(svc, ":42070", api.WithAuthorizer(svc))
+		_ = s.Run()
+	}()
+
+	// 100 ms should be fine for the server to startup
+	time.Sleep(100 * time.Millisecond)
+
+	c := NewHTPPClient("localhost:42070")
+	got, err := c.Authorize(ctx, tkn, "invoices:read")
+	if err != nil {
+		t.Fatalf("error should be nil: %v", err)
+	}
+	if !got.Allowed || got.Subject != "user-1" {
+		t.Errorf("permission should be allowed: %+v", got)
+	}
+
+	got, err = c.Authorize(ctx, tkn, "invoices:write")
+	if err != nil {
+		t.Fatalf("error should be nil: %v", err)
+	}
+	if got.Allowed || got.Reason == "" {
+		t.Errorf("permission should be denied with a reason: %+v", got)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Request-ID")
+		_, _ = w.Write([]byte(`{"valid": true}`))
+	}))
+	defer srv.Close()
+
+	c := NewHTPPClient(strings.TrimPrefix(srv.URL, "http://"))
+	if _, err := c.Validate(types.WithRequestID(context.Background(), "req-42"), []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+	if got != "req-42" {
+		t.Errorf("request ids are not the same: want=%q, got=%q", "req-42", got)
+	}
+
+	if _, err := c.Validate(context.Background(), []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+	if got != "" {
+		t.Errorf("request id shouldn't be sent without one in the context: %q", got)
+	}
+}
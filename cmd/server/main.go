@@ -1,23 +1,71 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	bolt "go.etcd.io/bbolt"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/danblok/auth/internal/api"
+	"github.com/danblok/auth/internal/apikeys"
 	"github.com/danblok/auth/internal/logging"
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/internal/rbac"
+	"github.com/danblok/auth/internal/refresh"
+	"github.com/danblok/auth/internal/registry"
+	"github.com/danblok/auth/internal/revocation"
 	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/internal/users"
+	"github.com/danblok/auth/internal/webauthn"
+	"github.com/danblok/auth/pkg/types"
 )
 
 var (
 	httpAddr       = flag.String("http", ":3000", "Listen addr of the http server")
 	grpcAddr       = flag.String("grpc", ":4000", "Listen addr of the grpc server")
-	jwtKeyPath     = flag.String("jwtkey", "/run/secrets/jwt_key", "Key path of a signing jwt key")
+	jwtKeyPath     = flag.String("jwtkey", "/run/secrets/jwt_key", "Key path of a signing jwt key (HMAC secret or PEM private key)")
+	jwtAlg         = flag.String("jwtalg", "", "Signing algorithm of a PEM jwt key, chosen from the key type if empty")
+	jwtKeyDir      = flag.String("jwtkeydir", "", "Directory of PEM jwt keys reloaded on SIGHUP, overrides -jwtkey")
+	jwtOverlap     = flag.Duration("jwtoverlap", 24*time.Hour, "How long a replaced signing key keeps verifying tokens")
+	issuer         = flag.String("issuer", "", "Issuer (iss) of tokens, required on validation if set")
+	audience       = flag.String("audience", "", "Comma-separated allowed audiences, the first one is the default aud of tokens")
+	tokenTTL       = flag.Duration("ttl", 24*time.Hour, "Default lifetime of tokens")
+	maxTokenTTL    = flag.Duration("maxttl", 24*time.Hour, "Maximum lifetime of tokens requested by clients")
+	maxClaimsSize  = flag.Int("maxclaims", 4096, "Maximum size in bytes of JSON encoded custom claims of tokens")
+	leeway         = flag.Duration("leeway", 0, "Allowed clock skew when validating tokens")
+	accessTTL      = flag.Duration("accessttl", 15*time.Minute, "Lifetime of access tokens issued with refresh tokens")
+	refreshTTL     = flag.Duration("refreshttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+	clientsPath    = flag.String("clients", "", "Path of a JSON file of OAuth 2.0 clients that enables /oauth2 endpoints")
+	oauthLogin     = flag.Bool("oauthlogin", false, "Enables /oauth2/authorize and the device flow with a login page of the users, requires -clients")
+	rolesPath      = flag.String("roles", "", "Path of a JSON file of roles and their permissions that embeds roles in tokens and enables /authorize")
+	authCookie     = flag.String("authcookie", "", "Cookie holding tokens of /auth requests without a bearer token")
+	authRulesPath  = flag.String("authrules", "", "Path of a JSON file of path rules requiring permissions of /auth requests")
+	tokenReview    = flag.Bool("tokenreview", false, "Enables the Kubernetes TokenReview webhook on /tokenreview")
+	reviewClaims   = flag.String("tokenreviewclaims", "username=sub,uid=sub,groups=roles", "Comma-separated claims of TokenReview users, as username=<claim>,uid=<claim>,groups=<claim>")
+	reviewAudience = flag.String("tokenreviewaudiences", "", "Comma-separated audiences of the Kubernetes API server, required of tokens of TokenReviews without audiences")
+	reviewUserPfx  = flag.String("tokenreviewuserprefix", "", "Prefix of usernames of TokenReview users, e.g. auth:")
+	reviewGroupPfx = flag.String("tokenreviewgroupprefix", "", "Prefix of groups of TokenReview users, e.g. auth:")
+	registryName   = flag.String("registryservice", "", "Name of the Docker registry service that enables /registry/token")
+	registryKey    = flag.String("registrykey", "", "Path of the PEM private key signing registry tokens")
+	registryCert   = flag.String("registrycert", "", "Path of the PEM certificate chain of the registry key, put in the x5c header of tokens")
+	registryACL    = flag.String("registryacl", "", "Path of a JSON file of repository rules granting actions in registry tokens")
+	profilesPath   = flag.String("profiles", "", "Path of a JSON file of OpenID Connect profiles keyed by subject that enables /userinfo")
+	userinfo       = flag.Bool("userinfo", false, "Enables /userinfo with the profiles of users if -profiles is not set")
+	userDBPath     = flag.String("userdb", "", "Path of the SQLite user database, users are kept in memory if empty")
+	webauthnRPID   = flag.String("webauthnrp", "", "WebAuthn relying party ID (domain) that enables passkey login")
+	webauthnOrigin = flag.String("webauthnorigins", "", "Comma-separated origins of WebAuthn ceremonies, https://<rp id> if empty")
+	dbPath         = flag.String("db", "", "Path of the embedded database, in-memory stores are used if empty")
+	adminTokenPath = flag.String("admintoken", "", "Path of a bearer token that enables the admin API")
 	serverCertPath = flag.String("srvcert", "/run/secrets/server_cert", "Server certificate path")
 	serverKeyPath  = flag.String("srvkey", "/run/secrets/server_key", "Server private key path")
 )
@@ -26,14 +74,175 @@ func main() {
 	flag.Parse()
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	key, err := os.ReadFile(*jwtKeyPath)
+	keys, err := loadKeyring()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	svc := service.NewJWTService([]byte(key))
+	jwtOpts := []service.JWTOption{
+		service.WithIssuer(*issuer),
+		service.WithTTL(*tokenTTL),
+		service.WithMaxTTL(*maxTokenTTL),
+		service.WithMaxClaimsSize(*maxClaimsSize),
+		service.WithLeeway(*leeway),
+	}
+	if *audience != "" {
+		jwtOpts = append(jwtOpts, service.WithAudience(strings.Split(*audience, ",")...))
+	}
+
+	var db *bolt.DB
+	if *dbPath != "" {
+		db, err = bolt.Open(*dbPath, 0o600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+	}
+
+	revocations, err := newRevocationStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var clientStore oauth.ClientStore
+	if *clientsPath != "" {
+		clients, err := oauth.LoadClients(*clientsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clientStore = oauth.NewMemoryClientStore(clients...)
+	}
+
+	userStore, err := newUserStore(*userDBPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	userSvc, err := users.NewUserService(userStore, users.DefaultParams)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var svc types.TokenService = service.NewJWTServiceWithKeyring(keys, jwtOpts...)
+	revoker := revocation.NewRevocationService(svc, revocations, *maxTokenTTL)
+	go revoker.RunGC(context.Background(), time.Minute)
+	svc = revoker
+
+	// Roles of users take precedence over those of clients.
+	resolvers := []types.RoleResolver{userSvc}
+	if clientStore != nil {
+		resolvers = append(resolvers, oauth.ClientRoles(clientStore))
+	}
+	var authorizer types.Authorizer
+	if *rolesPath != "" {
+		roles, err := rbac.LoadRoles(*rolesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rbacSvc := rbac.NewRBACService(svc, roles, resolvers...)
+		svc, authorizer = rbacSvc, rbacSvc
+	}
 	svc = logging.NewLoggingService(svc)
 
+	refreshes, err := newRefreshStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	refresher := refresh.NewRefreshService(svc, refreshes, *accessTTL, *refreshTTL)
+	go refresher.RunGC(context.Background(), time.Minute)
+
+	grants, err := newGrantStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go oauth.RunGrantGC(context.Background(), grants, time.Minute)
+
+	apiKeyStore, err := newAPIKeyStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := []api.Option{
+		api.WithKeys(keys),
+		api.WithKeyRotator(keys, *jwtOverlap),
+		api.WithRevoker(revoker),
+		api.WithRefresher(refresher),
+		api.WithIssuer(*issuer),
+		api.WithGrantStore(grants),
+		api.WithAPIKeys(apikeys.NewAPIKeyService(apiKeyStore)),
+	}
+
+	if clientStore != nil {
+		opts = append(opts, api.WithOAuthClients(clientStore))
+	}
+	if *oauthLogin {
+		if clientStore == nil {
+			log.Fatal("-oauthlogin requires -clients")
+		}
+		opts = append(opts, api.WithLogin(oauth.NewPasswordLogin(userSvc)))
+	}
+	opts = append(opts, api.WithUsers(userSvc))
+	if authorizer != nil {
+		opts = append(opts, api.WithAuthorizer(authorizer))
+	}
+
+	var rules rbac.PathRules
+	if *authRulesPath != "" {
+		if rules, err = rbac.LoadPathRules(*authRulesPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	opts = append(opts, api.WithForwardAuth(*authCookie, rules))
+
+	if *tokenReview {
+		claims, err := parseReviewClaims(*reviewClaims)
+		if err != nil {
+			log.Fatal(err)
+		}
+		claims.UsernamePrefix, claims.GroupsPrefix = *reviewUserPfx, *reviewGroupPfx
+		if *reviewAudience != "" {
+			claims.Audiences = strings.Split(*reviewAudience, ",")
+		}
+		if err := claims.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, api.WithTokenReview(claims))
+	}
+
+	if *registryName != "" {
+		issuer, err := newRegistryIssuer()
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, api.WithRegistry(issuer), api.WithRoleResolvers(resolvers...))
+	}
+
+	if *webauthnRPID != "" {
+		origins := []string{"https://" + *webauthnRPID}
+		if *webauthnOrigin != "" {
+			origins = strings.Split(*webauthnOrigin, ",")
+		}
+		opts = append(opts, api.WithWebAuthn(&webauthn.RelyingParty{ID: *webauthnRPID, Name: *webauthnRPID, Origins: origins}))
+	}
+
+	// Profiles of the file take precedence over those of the users.
+	if *profilesPath != "" {
+		profiles, err := oauth.LoadProfiles(*profilesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, api.WithProfiles(oauth.NewMemoryProfileStore(profiles)))
+	} else if *userinfo {
+		opts = append(opts, api.WithProfiles(userSvc))
+	}
+
+	if *adminTokenPath != "" {
+		token, err := os.ReadFile(*adminTokenPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, api.WithAdmin(strings.TrimSpace(string(token))))
+	}
+
 	eg := new(errgroup.Group)
 
 	eg.Go(func() error {
@@ -42,7 +251,7 @@ func main() {
 			return err
 		}
 		log.Printf("started GRPC server on [::]%s", *grpcAddr)
-		return api.NewGRPCServer(svc).ServeTLS(*grpcAddr, cert)
+		return api.NewGRPCServer(svc, opts...).ServeTLS(*grpcAddr, cert)
 	})
 
 	eg.Go(func() error {
@@ -50,14 +259,25 @@ func main() {
 		if err != nil {
 			return err
 		}
-		httpServer, err := api.NewHTTPServerTLS(svc, *httpAddr, cert)
+		httpServer, err := api.NewHTTPServerTLS(svc, *httpAddr, cert, opts...)
 		if err != nil {
 			return fmt.Errorf("couldn't create a new HTTP server: %v", err)
 		}
 		log.Printf("started HTTP server on [::]%s\n", *httpAddr)
 		log.Printf(`available routes:
-	receive token: POST [::]%s/token {"payload": "mypayload"}
-	validate token: GET [::]%s/validate?token=<your_token>`, *httpAddr, *httpAddr)
+	receive token: POST [::]%s/token (Authorization: Bearer <admin_token>) {"payload": "mypayload", "claims": {"role": "admin"}, "ttl": 3600, "audience": ["api"], "subject": "user"}
+	validate token: GET [::]%s/validate?token=<your_token>
+	introspect token: GET [::]%s/introspect?token=<your_token>
+	forward auth: GET [::]%s/auth (Authorization: Bearer <your_token>, X-Forwarded-Uri: /invoices/42)
+	receive token pair: POST [::]%s/token/pair (Authorization: Bearer <admin_token>) {"payload": "mypayload"}
+	refresh token pair: POST [::]%s/refresh {"refresh_token": "<your_refresh_token>"}
+	login: POST [::]%s/login {"username": "user", "password": "secret"}
+	second login step: POST [::]%s/login/mfa {"mfa_token": "<your_mfa_token>", "code": "123456"}
+	exchange api key: POST [::]%s/apikey/token {"api_key": "<your_api_key>"}
+	check permission: POST [::]%s/authorize {"token": "<your_token>", "permission": "invoices:read"}
+	registry token: GET [::]%s/registry/token?service=<registry>&scope=repository:<name>:pull,push
+	verification keys: GET [::]%s/.well-known/jwks.json
+	server metadata: GET [::]%s/.well-known/openid-configuration`, *httpAddr, *httpAddr, *httpAddr, *httpAddr, *httpAddr, *httpAddr, *httpAddr, *httpAddr, *httpAddr, *httpAddr, *httpAddr, *httpAddr, *httpAddr)
 		return httpServer.Run()
 	})
 
@@ -65,3 +285,158 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// Parses claims of TokenReview users, e.g. username=sub,groups=roles.
+func parseReviewClaims(s string) (api.TokenReviewClaims, error) {
+	var claims api.TokenReviewClaims
+	for _, kv := range strings.Split(s, ",") {
+		field, claim, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		switch field {
+		case "username":
+			claims.Username = claim
+		case "uid":
+			claims.UID = claim
+		case "groups":
+			claims.Groups = claim
+		case "":
+		default:
+			return claims, fmt.Errorf("unknown tokenreview field %q", field)
+		}
+	}
+
+	return claims, nil
+}
+
+// Creates the issuer of registry tokens signed by the registry key
+// which the certificate chain, trusted by the registry, certifies.
+func newRegistryIssuer() (*registry.Issuer, error) {
+	data, err := os.ReadFile(*registryKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := service.ParsePrivateKeyPEM(data, "")
+	if err != nil {
+		return nil, err
+	}
+	certs, err := os.ReadFile(*registryCert)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.AttachCertificatesPEM(certs); err != nil {
+		return nil, err
+	}
+
+	var acl registry.ACL
+	if *registryACL != "" {
+		if acl, err = registry.LoadACL(*registryACL); err != nil {
+			return nil, err
+		}
+	}
+
+	svc := service.NewJWTServiceWithKey(key,
+		service.WithIssuer(*issuer),
+		service.WithAudience(*registryName),
+		service.WithTTL(registry.TokenTTL),
+	)
+
+	return registry.NewIssuer(logging.NewLoggingService(svc), *registryName, acl), nil
+}
+
+// Creates a revocation store in the database or in memory.
+func newRevocationStore(db *bolt.DB) (types.RevocationStore, error) {
+	if db == nil {
+		return revocation.NewMemoryStore(), nil
+	}
+
+	return revocation.NewBoltStore(db)
+}
+
+// Creates a store of pending OAuth 2.0 grants in the database or in memory.
+func newGrantStore(db *bolt.DB) (oauth.GrantStore, error) {
+	if db == nil {
+		return oauth.NewMemoryGrantStore(), nil
+	}
+
+	return oauth.NewBoltGrantStore(db)
+}
+
+// Creates an API key store in the database or in memory.
+func newAPIKeyStore(db *bolt.DB) (types.APIKeyStore, error) {
+	if db == nil {
+		return apikeys.NewMemoryStore(), nil
+	}
+
+	return apikeys.NewBoltStore(db)
+}
+
+// Creates a user store in the SQLite database or in memory.
+func newUserStore(path string) (types.UserStore, error) {
+	if path == "" {
+		return users.NewMemoryStore(), nil
+	}
+
+	db, err := users.OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return users.NewSQLiteStore(context.Background(), db)
+}
+
+// Creates a refresh token store in the database or in memory.
+func newRefreshStore(db *bolt.DB) (types.RefreshStore, error) {
+	if db == nil {
+		return refresh.NewMemoryStore(), nil
+	}
+
+	return refresh.NewBoltStore(db)
+}
+
+// Loads the keyring from the key directory and reloads it on SIGHUP,
+// or loads the single key from -jwtkey.
+func loadKeyring() (*service.Keyring, error) {
+	if *jwtKeyDir == "" {
+		key, err := loadJWTKey(*jwtKeyPath, *jwtAlg)
+		if err != nil {
+			return nil, err
+		}
+		return service.NewKeyring(key), nil
+	}
+
+	keys, err := service.LoadKeyDir(*jwtKeyDir, *jwtAlg)
+	if err != nil {
+		return nil, err
+	}
+	ring := service.NewKeyring()
+	ring.Sync(keys, *jwtOverlap)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			keys, err := service.LoadKeyDir(*jwtKeyDir, *jwtAlg)
+			if err != nil {
+				log.Printf("couldn't reload keys: %v", err)
+				continue
+			}
+			ring.Sync(keys, *jwtOverlap)
+			log.Printf("reloaded %d keys from %s", len(keys), *jwtKeyDir)
+		}
+	}()
+
+	return ring, nil
+}
+
+// Loads a PEM private key or falls back to an HMAC secret.
+func loadJWTKey(path, alg string) (*service.Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		return service.ParsePrivateKeyPEM(data, alg)
+	}
+
+	return service.NewHMACKey(data), nil
+}
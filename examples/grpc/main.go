@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/danblok/auth/client"
 	"github.com/danblok/auth/internal/api"
@@ -23,6 +24,7 @@ var (
 	caCertPath     = flag.String("cacert", "data/ca.crt", "CA certificate path")
 	serverCertPath = flag.String("srvcert", "data/server.crt", "Server certificate path")
 	serverKeyPath  = flag.String("srvkey", "data/server.key", "Server private key path")
+	adminToken     = flag.String("admintoken", "example-admin-token", "Admin token that issues tokens")
 )
 
 func main() {
@@ -43,7 +45,7 @@ func main() {
 			log.Fatalf("couldn't load x509 key pair: %v", err)
 		}
 		log.Printf("started GRPC server on [::]%s", *grpcAddr)
-		if err := api.NewGRPCServer(svc).ServeTLS(*grpcAddr, cert); err != nil {
+		if err := api.NewGRPCServer(svc, api.WithAdmin(*adminToken)).ServeTLS(*grpcAddr, cert); err != nil {
 			log.Fatalf("couldn't run GRPC server: %v", err)
 		}
 	}()
@@ -60,7 +62,8 @@ func main() {
 
 	for {
 		time.Sleep(2 * time.Second)
-		tokenResp, err := client.Token(ctx, &proto.TokenRequest{Payload: "some payload"})
+		admin := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*adminToken)
+		tokenResp, err := client.Token(admin, &proto.TokenRequest{Payload: "some payload"})
 		if err != nil {
 			log.Fatalf("GRPC client.Token: %v", err)
 		}
@@ -20,6 +20,7 @@ var (
 	caCertPath     = flag.String("cacert", "data/ca.crt", "CA certificate path")
 	serverCertPath = flag.String("srvcert", "data/server.crt", "Server certificate path")
 	serverKeyPath  = flag.String("srvkey", "data/server.key", "Server private key path")
+	adminToken     = flag.String("admintoken", "example-admin-token", "Admin token that issues tokens")
 )
 
 func main() {
@@ -37,7 +38,7 @@ func main() {
 		if err != nil {
 			log.Fatalf("couldn't load x509 key pair: %v", err)
 		}
-		httpServer, err := api.NewHTTPServerTLS(svc, *httpAddr, cert)
+		httpServer, err := api.NewHTTPServerTLS(svc, *httpAddr, cert, api.WithAdmin(*adminToken))
 		if err != nil {
 			log.Fatalf("couldn't create a new HTTP server: %v", err)
 		}
@@ -58,6 +59,7 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	client = client.WithAdminToken(*adminToken)
 
 	for {
 		// Every 2 seconds fetch a new token a validate it.
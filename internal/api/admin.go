@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// RevokeBody represents the body of a request to revoke
+// a token, a token by its jti or all tokens of a subject.
+type RevokeBody struct {
+	Token   string `json:"token,omitempty"`
+	ID      string `json:"jti,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	// Before is a unix time, tokens of the subject
+	// issued before it are revoked. Defaults to now.
+	Before int64 `json:"before,omitempty"`
+}
+
+// Wraps the handler to require the admin bearer token.
+func (s *HTTPServer) adminOnly(fn HTTPHandlerFunc) HTTPHandlerFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if !validAdminToken(r.Header.Values("Authorization"), s.opts.adminToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			return writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: "invalid admin token"})
+		}
+
+		return fn(ctx, w, r)
+	}
+}
+
+// Handles token revocation.
+func (s *HTTPServer) handleRevoke(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var b RevokeBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if err := revoke(ctx, s.opts.revoker, s.opts.refresher, b); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Revokes what the request asks for, shared by both transports.
+// Revoking a subject revokes its refresh tokens too, if any.
+func revoke(ctx context.Context, r types.Revoker, rf types.Refresher, b RevokeBody) error {
+	switch {
+	case b.Token != "":
+		return r.RevokeToken(ctx, []byte(b.Token))
+	case b.ID != "":
+		return r.RevokeID(ctx, b.ID)
+	case b.Subject != "":
+		before := time.Now()
+		if b.Before != 0 {
+			before = time.Unix(b.Before, 0)
+		}
+		if rf != nil {
+			if err := rf.RevokeSubject(ctx, b.Subject, before); err != nil {
+				return err
+			}
+		}
+		return r.RevokeSubject(ctx, b.Subject, before)
+	default:
+		return errors.New("token, jti or subject not provided")
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Maximum lifetime of tokens exchanged for API keys.
+const apiKeyTokenTTL = 15 * time.Minute
+
+// APIKeyTokenBody represents the body of an API key exchange.
+type APIKeyTokenBody struct {
+	APIKey   string   `json:"api_key"`
+	TTL      int64    `json:"ttl,omitempty"`
+	Audience []string `json:"audience,omitempty"`
+	// Scope narrows the scopes of the key, space-separated.
+	Scope string `json:"scope,omitempty"`
+}
+
+// CreateAPIKeyResponse is the created key with its secret, shown only once.
+type CreateAPIKeyResponse struct {
+	Key    string        `json:"key"`
+	APIKey *types.APIKey `json:"api_key"`
+}
+
+// Handles exchanging an API key for a short-lived token.
+func (s *HTTPServer) handleAPIKeyToken(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var b APIKeyTokenBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	token, err := exchangeAPIKey(ctx, s.svc, s.opts.apiKeys, b)
+	if errors.Is(err, types.ErrInvalidCredentials) {
+		return writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: "api key not valid"})
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusCreated, types.TokenResponse{Token: string(token)})
+}
+
+// Issues a token of the key subject with its scopes through the
+// TokenService, shared by both transports. The token lives at most
+// 15 minutes and never outlives the key.
+func exchangeAPIKey(ctx context.Context, svc types.TokenService, keys types.APIKeyManager, b APIKeyTokenBody) ([]byte, error) {
+	if b.APIKey == "" {
+		return nil, errors.New("api_key required")
+	}
+
+	k, err := keys.AuthenticateAPIKey(ctx, b.APIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := k.Scopes
+	if b.Scope != "" {
+		scopes = strings.Fields(b.Scope)
+		for _, scope := range scopes {
+			if !slices.Contains(k.Scopes, scope) {
+				return nil, fmt.Errorf("scope %q is not granted to the key", scope)
+			}
+		}
+	}
+
+	ttl := apiKeyTokenTTL
+	if b.TTL > 0 {
+		ttl = min(ttl, time.Duration(b.TTL)*time.Second)
+	}
+	ttl = min(ttl, time.Until(k.ExpiresAt).Truncate(time.Second))
+	if ttl < time.Second {
+		return nil, types.ErrInvalidCredentials
+	}
+
+	claims := map[string]any{"api_key": k.ID}
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+	opts := append(tokenOptions(0, b.Audience, k.Subject, nil), types.WithServerClaims(claims), types.WithTTL(ttl))
+
+	return svc.Token(ctx, nil, opts...)
+}
+
+// Handles API key creation.
+func (s *HTTPServer) handleCreateAPIKey(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var nk types.NewAPIKey
+	if err := json.NewDecoder(r.Body).Decode(&nk); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	key, k, err := s.opts.apiKeys.CreateAPIKey(ctx, nk)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusCreated, CreateAPIKeyResponse{Key: key, APIKey: k})
+}
+
+// Handles listing of API keys.
+func (s *HTTPServer) handleListAPIKeys(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	keys, err := s.opts.apiKeys.APIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		keys = []*types.APIKey{}
+	}
+
+	return writeJSON(w, http.StatusOK, keys)
+}
+
+// Handles API key revocation.
+func (s *HTTPServer) handleRevokeAPIKey(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	err := s.opts.apiKeys.RevokeAPIKey(ctx, r.PathValue("id"))
+	if errors.Is(err, types.ErrNotFound) {
+		return writeJSON(w, http.StatusNotFound, HTTPErrResponse{Error: "api key not found"})
+	}
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danblok/auth/internal/apikeys"
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	keys := apikeys.NewAPIKeyService(apikeys.NewMemoryStore())
+	h := NewHTTPServer(svc, "localhost:3000", WithAPIKeys(keys), WithAdmin("admin-token")).routes()
+
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("POST", "/admin/apikeys", `{"label": "partner"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+	w := do("POST", "/admin/apikeys", `{"label": "partner", "subject": "partner-1", "scopes": ["invoices:read", "invoices:write"], "ttl": 86400}`, "admin-token")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusCreated, w.Code)
+	}
+	var created CreateAPIKeyResponse
+	_ = json.NewDecoder(w.Body).Decode(&created)
+	if !strings.HasPrefix(created.Key, apikeys.Prefix) || created.APIKey.Subject != "partner-1" {
+		t.Fatalf("unexpected key: %+v", created)
+	}
+
+	// Cases depend on each other, so they run in order.
+	tests := []struct {
+		name      string
+		body      APIKeyTokenBody
+		revoke    bool
+		wantCode  int
+		wantScope string
+	}{
+		{
+			name:      "all scopes",
+			body:      APIKeyTokenBody{APIKey: created.Key, Audience: []string{"billing"}},
+			wantCode:  http.StatusCreated,
+			wantScope: "invoices:read invoices:write",
+		},
+		{
+			name:      "narrowed scopes",
+			body:      APIKeyTokenBody{APIKey: created.Key, Scope: "invoices:read"},
+			wantCode:  http.StatusCreated,
+			wantScope: "invoices:read",
+		},
+		{
+			name:     "scope of another key",
+			body:     APIKeyTokenBody{APIKey: created.Key, Scope: "users:write"},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "wrong key",
+			body:     APIKeyTokenBody{APIKey: created.Key + "x"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "revoked key",
+			body:     APIKeyTokenBody{APIKey: created.Key},
+			revoke:   true,
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.revoke {
+				if w := do("DELETE", "/admin/apikeys/"+created.APIKey.ID, "", "admin-token"); w.Code != http.StatusNoContent {
+					t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusNoContent, w.Code)
+				}
+			}
+
+			w := do("POST", "/apikey/token", mustJSON(t, tt.body), "")
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d: %s", tt.wantCode, w.Code, w.Body)
+			}
+			if tt.wantCode != http.StatusCreated {
+				return
+			}
+
+			var resp types.TokenResponse
+			_ = json.NewDecoder(w.Body).Decode(&resp)
+			in, _ := svc.Introspect(ctx, []byte(resp.Token))
+			if !in.Valid || in.Claims["sub"] != "partner-1" || in.Claims["scope"] != tt.wantScope || in.Claims["api_key"] != created.APIKey.ID {
+				t.Errorf("unexpected claims: %+v", in.Claims)
+			}
+			if in.ExpiresAt-in.IssuedAt != int64(apiKeyTokenTTL.Seconds()) {
+				t.Errorf("lifetimes are not the same: want=%d, got=%d", int64(apiKeyTokenTTL.Seconds()), in.ExpiresAt-in.IssuedAt)
+			}
+		})
+	}
+
+	w = do("GET", "/admin/apikeys", "", "admin-token")
+	var list []types.APIKey
+	_ = json.NewDecoder(w.Body).Decode(&list)
+	if len(list) != 1 || list[0].RevokedAt.IsZero() || list[0].LastUsedAt.IsZero() {
+		t.Errorf("unexpected keys: %+v", list)
+	}
+	if strings.Contains(w.Body.String(), created.Key) {
+		t.Error("secret key shouldn't be listed")
+	}
+	if w := do("DELETE", "/admin/apikeys/missing", "", "admin-token"); w.Code != http.StatusNotFound {
+		t.Errorf("status code is not the same: want=%d, got=%d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Lifetime of authorization codes, RFC 6749 section 4.1.2 recommends 10 minutes at most.
+const codeTTL = time.Minute
+
+// Handles the authorization endpoint of RFC 6749 section 4.1.1.
+// Errors before the redirect URI is known aren't redirected.
+func (s *HTTPServer) handleAuthorize(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return writeOAuthError(w, oauth.NewError(oauth.ErrInvalidRequest, err.Error()))
+	}
+
+	id := r.Form.Get("client_id")
+	if id == "" {
+		return writeOAuthError(w, oauth.NewError(oauth.ErrInvalidRequest, "client_id not provided"))
+	}
+	client, err := s.opts.clients.Client(ctx, id)
+	if errors.Is(err, types.ErrNotFound) {
+		return writeOAuthError(w, oauth.NewError(oauth.ErrInvalidRequest, "unknown client"))
+	}
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+	redirectURI, err := client.ResolveRedirectURI(r.Form.Get("redirect_uri"))
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	req := &oauth.AuthorizationRequest{
+		Client:      client,
+		RedirectURI: redirectURI,
+		State:       r.Form.Get("state"),
+		Nonce:       r.Form.Get("nonce"),
+	}
+	consent, err := s.authorize(w, r, req)
+	if err != nil {
+		return s.redirectAuthorization(w, r, req, authorizationError(err))
+	}
+	if consent == nil {
+		return nil
+	}
+
+	code, grantID, err := oauth.NewCode()
+	if err != nil {
+		return s.redirectAuthorization(w, r, req, authorizationError(err))
+	}
+	err = s.opts.grants.Create(ctx, &oauth.Grant{
+		ID:            grantID,
+		Type:          oauth.GrantAuthorizationCode,
+		ClientID:      client.ID,
+		RedirectURI:   r.Form.Get("redirect_uri"),
+		CodeChallenge: r.Form.Get("code_challenge"),
+		Scopes:        consent.Scopes,
+		Nonce:         req.Nonce,
+		Subject:       consent.Subject,
+		AuthTime:      consent.AuthTime,
+		ExpiresAt:     time.Now().Add(codeTTL),
+	})
+	if err != nil {
+		return s.redirectAuthorization(w, r, req, authorizationError(err))
+	}
+
+	return s.redirectAuthorization(w, r, req, url.Values{"code": {code}})
+}
+
+// Validates the rest of the request and asks the login handler for consent.
+func (s *HTTPServer) authorize(w http.ResponseWriter, r *http.Request, req *oauth.AuthorizationRequest) (*oauth.Consent, error) {
+	if rt := r.Form.Get("response_type"); rt != "code" {
+		return nil, oauth.NewError(oauth.ErrUnsupportedResponseType, "only the code response type is supported")
+	}
+	if !req.Client.AllowsGrant(oauth.GrantAuthorizationCode) {
+		return nil, oauth.NewError(oauth.ErrUnauthorizedClient, "grant type is not allowed for the client")
+	}
+	if r.Form.Get("code_challenge_method") != oauth.CodeChallengeS256 || !oauth.ValidCodeChallenge(r.Form.Get("code_challenge")) {
+		return nil, oauth.NewError(oauth.ErrInvalidRequest, "S256 code_challenge required")
+	}
+
+	scopes, err := req.Client.ResolveScopes(r.Form.Get("scope"))
+	if err != nil {
+		return nil, err
+	}
+	req.Scopes = scopes
+
+	consent, err := s.opts.login.Login(w, r, req)
+	if err != nil || consent == nil {
+		return nil, err
+	}
+	if err := checkConsent(consent, req.Scopes); err != nil {
+		return nil, err
+	}
+
+	return consent, nil
+}
+
+// Checks the consent returned by the login handler against the
+// requested scopes and fills in the defaults.
+func checkConsent(consent *oauth.Consent, requested []string) error {
+	if consent.Subject == "" {
+		return errors.New("login handler returned consent without a subject")
+	}
+	if len(consent.Scopes) == 0 {
+		consent.Scopes = requested
+	}
+	for _, scope := range consent.Scopes {
+		if !slices.Contains(requested, scope) {
+			return errors.New("login handler granted scope " + scope + " that wasn't requested")
+		}
+	}
+	if consent.AuthTime.IsZero() {
+		consent.AuthTime = time.Now()
+	}
+
+	return nil
+}
+
+// Redirects the authorization response to the client with the state and
+// the RFC 9207 issuer identifier.
+func (s *HTTPServer) redirectAuthorization(w http.ResponseWriter, r *http.Request, req *oauth.AuthorizationRequest, params url.Values) error {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	q.Set("iss", s.issuer(r))
+	u.RawQuery = q.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u.String(), http.StatusFound)
+	return nil
+}
+
+// Converts the error to redirect parameters, hiding internal errors.
+func authorizationError(err error) url.Values {
+	var oauthErr *oauth.Error
+	if !errors.As(err, &oauthErr) {
+		log.Printf("oauth: %v", err)
+		oauthErr = oauth.NewError(oauth.ErrServerError, "")
+	}
+
+	params := url.Values{"error": {oauthErr.Code}}
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+
+	return params
+}
+
+// Redeems an authorization code, RFC 6749 section 4.1.3 with RFC 7636 PKCE.
+func (s *HTTPServer) authorizationCodeGrant(ctx context.Context, r *http.Request) (*types.TokenPair, error) {
+	client, err := s.identifyClient(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(oauth.GrantAuthorizationCode) {
+		return nil, oauth.NewError(oauth.ErrUnauthorizedClient, "grant type is not allowed for the client")
+	}
+
+	code := r.PostForm.Get("code")
+	if code == "" {
+		return nil, oauth.NewError(oauth.ErrInvalidRequest, "code not provided")
+	}
+	grant, err := s.opts.grants.Take(ctx, oauth.HashSecret(code))
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, oauth.NewError(oauth.ErrInvalidGrant, "code is not valid")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if grant.Type != oauth.GrantAuthorizationCode || grant.ClientID != client.ID || grant.Expired(time.Now()) {
+		return nil, oauth.NewError(oauth.ErrInvalidGrant, "code is not valid")
+	}
+	if r.PostForm.Get("redirect_uri") != grant.RedirectURI {
+		return nil, oauth.NewError(oauth.ErrInvalidGrant, "redirect_uri doesn't match the authorization request")
+	}
+	if !oauth.VerifyCodeChallenge(grant.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		return nil, oauth.NewError(oauth.ErrInvalidGrant, "code_verifier doesn't match the code_challenge")
+	}
+
+	return s.issueGrantTokens(ctx, client, grant)
+}
+
+// Issues the access token of the approved grant, and the
+// ID token if the openid scope is granted.
+func (s *HTTPServer) issueGrantTokens(ctx context.Context, client *oauth.Client, grant *oauth.Grant) (*types.TokenPair, error) {
+	pair, err := s.issueOAuthToken(ctx, client, grant.Subject, grant.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(grant.Scopes, "openid") {
+		idToken, err := issueIDToken(ctx, s.svc, []byte(pair.AccessToken), grant.Subject, client.ID, grant.Nonce, grant.AuthTime.Unix())
+		if err != nil {
+			return nil, err
+		}
+		pair.IDToken = string(idToken)
+	}
+
+	return pair, nil
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	svc := service.NewJWTService([]byte("secret-key"))
+	clients := oauth.NewMemoryClientStore(
+		oauth.Client{
+			ID:           "app",
+			Scopes:       []string{"openid", "profile"},
+			GrantTypes:   []string{oauth.GrantAuthorizationCode},
+			RedirectURIs: []string{"com.example.app:/callback", "https://app.example.com/cb"},
+		},
+		oauth.Client{
+			ID:           "m2m",
+			SecretHash:   oauth.HashSecret("s3cr3t"),
+			RedirectURIs: []string{"https://m2m.example.com/cb"},
+		},
+	)
+	login := oauth.LoginHandlerFunc(func(w http.ResponseWriter, r *http.Request, req *oauth.AuthorizationRequest) (*oauth.Consent, error) {
+		switch r.Form.Get("user") {
+		case "":
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("<form>login</form>"))
+			return nil, nil
+		case "deny":
+			return nil, oauth.NewError(oauth.ErrAccessDenied, "")
+		}
+		return &oauth.Consent{Subject: r.Form.Get("user")}, nil
+	})
+	srv := NewHTTPServer(svc, "localhost:3000", WithOAuthClients(clients), WithLogin(login), WithIssuer("https://auth.example.com"))
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	authorizeQuery := func(overrides url.Values) url.Values {
+		q := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {"app"},
+			"redirect_uri":          {"com.example.app:/callback"},
+			"scope":                 {"openid"},
+			"state":                 {"xyz"},
+			"nonce":                 {"n-1"},
+			"code_challenge":        {oauth.CodeChallenge(verifier)},
+			"code_challenge_method": {"S256"},
+			"user":                  {"user-1"},
+		}
+		for k, v := range overrides {
+			q[k] = v
+		}
+		return q
+	}
+	authorize := func(q url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/oauth2/authorize?"+q.Encode(), nil)
+		w := httptest.NewRecorder()
+		makeHTTPHandler(srv.handleAuthorize)(w, r)
+		return w
+	}
+
+	authorizeTests := map[string]struct {
+		query        url.Values
+		wantCode     int
+		wantRedirect string
+		wantError    string
+	}{
+		"approved": {
+			query:        authorizeQuery(nil),
+			wantCode:     http.StatusFound,
+			wantRedirect: "com.example.app:/callback",
+		},
+		"login page": {
+			query:    authorizeQuery(url.Values{"user": {""}}),
+			wantCode: http.StatusOK,
+		},
+		"denied": {
+			query:        authorizeQuery(url.Values{"user": {"deny"}}),
+			wantCode:     http.StatusFound,
+			wantRedirect: "com.example.app:/callback",
+			wantError:    oauth.ErrAccessDenied,
+		},
+		"redirect uri not registered": {
+			query:    authorizeQuery(url.Values{"redirect_uri": {"https://app.example.com/cb/"}}),
+			wantCode: http.StatusBadRequest,
+		},
+		"redirect uri ambiguous": {
+			query:    authorizeQuery(url.Values{"redirect_uri": {""}}),
+			wantCode: http.StatusBadRequest,
+		},
+		"unknown client": {
+			query:    authorizeQuery(url.Values{"client_id": {"other"}}),
+			wantCode: http.StatusBadRequest,
+		},
+		"no pkce": {
+			query:        authorizeQuery(url.Values{"code_challenge": {""}}),
+			wantCode:     http.StatusFound,
+			wantRedirect: "com.example.app:/callback",
+			wantError:    oauth.ErrInvalidRequest,
+		},
+		"plain pkce": {
+			query:        authorizeQuery(url.Values{"code_challenge": {verifier}, "code_challenge_method": {"plain"}}),
+			wantCode:     http.StatusFound,
+			wantRedirect: "com.example.app:/callback",
+			wantError:    oauth.ErrInvalidRequest,
+		},
+		"grant not allowed": {
+			query:        authorizeQuery(url.Values{"client_id": {"m2m"}, "redirect_uri": {""}}),
+			wantCode:     http.StatusFound,
+			wantRedirect: "https://m2m.example.com/cb",
+			wantError:    oauth.ErrUnauthorizedClient,
+		},
+		"token response type": {
+			query:        authorizeQuery(url.Values{"response_type": {"token"}}),
+			wantCode:     http.StatusFound,
+			wantRedirect: "com.example.app:/callback",
+			wantError:    oauth.ErrUnsupportedResponseType,
+		},
+	}
+
+	for name, tt := range authorizeTests {
+		t.Run(name, func(t *testing.T) {
+			w := authorize(tt.query)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d", tt.wantCode, w.Code)
+			}
+			if tt.wantRedirect == "" {
+				return
+			}
+
+			loc, _ := url.Parse(w.Header().Get("Location"))
+			q := loc.Query()
+			loc.RawQuery = ""
+			if loc.String() != tt.wantRedirect || q.Get("state") != "xyz" || q.Get("iss") != "https://auth.example.com" {
+				t.Errorf("unexpected redirect: %s", w.Header().Get("Location"))
+			}
+			if q.Get("error") != tt.wantError {
+				t.Errorf("error is not the same: want=%q, got=%q", tt.wantError, q.Get("error"))
+			}
+			if tt.wantError == "" && q.Get("code") == "" {
+				t.Error("code should be returned")
+			}
+		})
+	}
+
+	newCode := func() string {
+		loc, _ := url.Parse(authorize(authorizeQuery(nil)).Header().Get("Location"))
+		return loc.Query().Get("code")
+	}
+	redeem := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/oauth2/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		makeHTTPHandler(srv.handleOAuthToken)(w, r)
+		return w
+	}
+	tokenForm := func(code string, overrides url.Values) url.Values {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"client_id":     {"app"},
+			"code":          {code},
+			"redirect_uri":  {"com.example.app:/callback"},
+			"code_verifier": {verifier},
+		}
+		for k, v := range overrides {
+			form[k] = v
+		}
+		return form
+	}
+
+	code := newCode()
+	w := redeem(tokenForm(code, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("code should be redeemed: %s", w.Body)
+	}
+	var pair types.TokenPair
+	_ = json.NewDecoder(w.Result().Body).Decode(&pair)
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(pair.IDToken, claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "user-1" || claims["nonce"] != "n-1" || claims["at_hash"] == nil {
+		t.Errorf("id token should be issued for the openid scope: %v", claims)
+	}
+	if in, _ := svc.Introspect(context.Background(), []byte(pair.IDToken)); in.Valid {
+		t.Error("id token shouldn't be accepted as an access token")
+	}
+	if w := redeem(tokenForm(code, nil)); w.Code != http.StatusBadRequest {
+		t.Error("code shouldn't be redeemed twice")
+	}
+
+	redeemTests := map[string]url.Values{
+		"wrong verifier":     {"code_verifier": {strings.Repeat("a", 43)}},
+		"no verifier":        {"code_verifier": {""}},
+		"other redirect uri": {"redirect_uri": {"https://app.example.com/cb"}},
+		"other client":       {"client_id": {"m2m"}, "client_secret": {"s3cr3t"}},
+	}
+	for name, form := range redeemTests {
+		t.Run(name, func(t *testing.T) {
+			w := redeem(tokenForm(newCode(), form))
+			if w.Code == http.StatusOK {
+				t.Errorf("code shouldn't be redeemed: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestAuthorizePasswordLogin(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	userSvc := newUserService(t)
+	jane, _ := userSvc.CreateUser(ctx, "jane", "s3cr3t", nil)
+	clients := oauth.NewMemoryClientStore(oauth.Client{
+		ID:           "app",
+		Scopes:       []string{"openid"},
+		GrantTypes:   []string{oauth.GrantAuthorizationCode},
+		RedirectURIs: []string{"com.example.app:/callback"},
+	})
+	h := NewHTTPServer(svc, "localhost:3000", WithOAuthClients(clients), WithUsers(userSvc), WithLogin(oauth.NewPasswordLogin(userSvc))).routes()
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"app"},
+		"scope":                 {"openid"},
+		"state":                 {"xyz"},
+		"code_challenge":        {oauth.CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")},
+		"code_challenge_method": {"S256"},
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/oauth2/authorize?"+query.Encode(), nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="code_challenge"`) {
+		t.Fatalf("login page should pass back the request: %d %s", w.Code, w.Body)
+	}
+
+	tests := map[string]struct {
+		login     url.Values
+		wantCode  int
+		wantError string
+	}{
+		"signed in": {
+			login:    url.Values{"username": {"jane"}, "password": {"s3cr3t"}},
+			wantCode: http.StatusFound,
+		},
+		"wrong password": {
+			login:    url.Values{"username": {"jane"}, "password": {"wrong"}},
+			wantCode: http.StatusUnauthorized,
+		},
+		"denied": {
+			login:     url.Values{"username": {""}, "deny": {"1"}},
+			wantCode:  http.StatusFound,
+			wantError: oauth.ErrAccessDenied,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			form := url.Values{}
+			for k, v := range query {
+				form[k] = v
+			}
+			for k, v := range tt.login {
+				form[k] = v
+			}
+			r := httptest.NewRequest("POST", "/oauth2/authorize", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d: %s", tt.wantCode, w.Code, w.Body)
+			}
+			if tt.wantCode != http.StatusFound {
+				return
+			}
+
+			loc, _ := url.Parse(w.Header().Get("Location"))
+			if got := loc.Query().Get("error"); got != tt.wantError {
+				t.Fatalf("error is not the same: want=%q, got=%q", tt.wantError, got)
+			}
+			if tt.wantError != "" {
+				return
+			}
+			r = httptest.NewRequest("POST", "/oauth2/token", strings.NewReader(url.Values{
+				"grant_type":    {"authorization_code"},
+				"client_id":     {"app"},
+				"code":          {loc.Query().Get("code")},
+				"code_verifier": {"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"},
+			}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			var pair types.TokenPair
+			_ = json.NewDecoder(w.Body).Decode(&pair)
+			if in, _ := svc.Introspect(ctx, []byte(pair.AccessToken)); !in.Valid || in.Claims["sub"] != jane.ID {
+				t.Errorf("token should be issued to the user: %s", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/pkg/types"
+)
+
+const (
+	// Lifetime of device codes.
+	deviceCodeTTL = 10 * time.Minute
+	// Minimum polling interval of device clients, RFC 8628 section 3.5
+	// adds 5 seconds on every slow_down.
+	devicePollInterval = 5 * time.Second
+	// Verifications per client IP and minute, RFC 8628 section 5.1
+	// asks to rate-limit guessing user codes.
+	maxDeviceVerifications = 20
+)
+
+// Page where users enter the code shown on their device.
+var verificationPage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Device login</title></head>
+<body>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if not .Done}}<form method="post">
+<label>Code shown on your device <input name="user_code" value="{{.UserCode}}" autocomplete="off" autofocus></label>
+<button type="submit">Continue</button>
+</form>{{end}}
+</body>
+</html>
+`))
+
+// Data of the verification page.
+type verificationData struct {
+	UserCode string
+	Message  string
+	Done     bool
+}
+
+// Handles RFC 8628 device authorization requests.
+func (s *HTTPServer) handleDeviceAuthorization(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return writeOAuthError(w, oauth.NewError(oauth.ErrInvalidRequest, err.Error()))
+	}
+	client, err := s.identifyClient(ctx, r)
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+	if !client.AllowsGrant(oauth.GrantDeviceCode) {
+		return writeOAuthError(w, oauth.NewError(oauth.ErrUnauthorizedClient, "grant type is not allowed for the client"))
+	}
+	scopes, err := client.ResolveScopes(r.PostForm.Get("scope"))
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	deviceCode, grantID, err := oauth.NewCode()
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+	userCode, userCodeHash, err := oauth.NewUserCode()
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+	err = s.opts.grants.Create(ctx, &oauth.Grant{
+		ID:        grantID,
+		Type:      oauth.GrantDeviceCode,
+		ClientID:  client.ID,
+		Scopes:    scopes,
+		UserCode:  userCodeHash,
+		Status:    oauth.GrantPending,
+		Interval:  devicePollInterval,
+		ExpiresAt: time.Now().Add(deviceCodeTTL),
+	})
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	verificationURI := s.issuer(r) + "/oauth2/device"
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, http.StatusOK, types.DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		ExpiresIn:               int64(deviceCodeTTL / time.Second),
+		Interval:                int64(devicePollInterval / time.Second),
+	})
+}
+
+// Shows the verification page, prefilled from verification_uri_complete.
+func (s *HTTPServer) handleDeviceVerificationPage(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return writeVerificationPage(w, http.StatusOK, verificationData{UserCode: r.URL.Query().Get("user_code")})
+}
+
+// Approves or denies the device grant of the entered user code
+// after the login handler authenticates the user.
+func (s *HTTPServer) handleDeviceVerification(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	userCode := r.PostForm.Get("user_code")
+	if s.opts.deviceRate.limit(w, r) {
+		return writeVerificationPage(w, http.StatusTooManyRequests, verificationData{UserCode: userCode, Message: "Too many attempts, try again later."})
+	}
+
+	grant, err := s.opts.grants.GrantByUserCode(ctx, oauth.HashUserCode(userCode))
+	if errors.Is(err, types.ErrNotFound) || (err == nil && (grant.Status != oauth.GrantPending || grant.Expired(time.Now()))) {
+		return writeVerificationPage(w, http.StatusBadRequest, verificationData{UserCode: userCode, Message: "The code is not valid or has expired."})
+	}
+	if err != nil {
+		return err
+	}
+	client, err := s.opts.clients.Client(ctx, grant.ClientID)
+	if err != nil {
+		return err
+	}
+
+	consent, err := s.opts.login.Login(w, r, &oauth.AuthorizationRequest{Client: client, Scopes: grant.Scopes})
+	var oauthErr *oauth.Error
+	switch {
+	case errors.As(err, &oauthErr) && oauthErr.Code == oauth.ErrAccessDenied:
+		grant.Status = oauth.GrantDenied
+		if err := s.opts.grants.Update(ctx, grant); err != nil {
+			return err
+		}
+		return writeVerificationPage(w, http.StatusOK, verificationData{Message: "Access was denied, you can close this page.", Done: true})
+	case err != nil:
+		return err
+	case consent == nil:
+		return nil
+	}
+
+	if err := checkConsent(consent, grant.Scopes); err != nil {
+		return err
+	}
+	grant.Subject = consent.Subject
+	grant.Scopes = consent.Scopes
+	grant.AuthTime = consent.AuthTime
+	grant.Status = oauth.GrantApproved
+	if err := s.opts.grants.Update(ctx, grant); err != nil {
+		return err
+	}
+
+	return writeVerificationPage(w, http.StatusOK, verificationData{Message: "Your device is logged in, you can close this page.", Done: true})
+}
+
+// Renders the verification page.
+func writeVerificationPage(w http.ResponseWriter, code int, data verificationData) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	return verificationPage.Execute(w, data)
+}
+
+// Exchanges an approved device code, RFC 8628 section 3.4.
+func (s *HTTPServer) deviceCodeGrant(ctx context.Context, r *http.Request) (*types.TokenPair, error) {
+	client, err := s.identifyClient(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(oauth.GrantDeviceCode) {
+		return nil, oauth.NewError(oauth.ErrUnauthorizedClient, "grant type is not allowed for the client")
+	}
+
+	deviceCode := r.PostForm.Get("device_code")
+	if deviceCode == "" {
+		return nil, oauth.NewError(oauth.ErrInvalidRequest, "device_code not provided")
+	}
+	id := oauth.HashSecret(deviceCode)
+	grant, err := s.opts.grants.Grant(ctx, id)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, oauth.NewError(oauth.ErrInvalidGrant, "device_code is not valid")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if grant.Type != oauth.GrantDeviceCode || grant.ClientID != client.ID {
+		return nil, oauth.NewError(oauth.ErrInvalidGrant, "device_code is not valid")
+	}
+
+	now := time.Now()
+	if grant.Expired(now) {
+		return nil, oauth.NewError(oauth.ErrExpiredToken, "device_code has expired")
+	}
+
+	switch grant.Status {
+	case oauth.GrantDenied:
+		if _, err := s.opts.grants.Take(ctx, id); err != nil && !errors.Is(err, types.ErrNotFound) {
+			return nil, err
+		}
+		return nil, oauth.NewError(oauth.ErrAccessDenied, "")
+	case oauth.GrantPending:
+		pollErr := oauth.NewError(oauth.ErrAuthorizationPending, "")
+		if now.Sub(grant.PolledAt) < grant.Interval {
+			grant.Interval += devicePollInterval
+			pollErr = oauth.NewError(oauth.ErrSlowDown, "")
+		}
+		grant.PolledAt = now
+		// The user may have decided meanwhile, the next poll gets the decision.
+		if err := s.opts.grants.UpdatePending(ctx, grant); err != nil && !errors.Is(err, types.ErrNotFound) {
+			return nil, err
+		}
+		return nil, pollErr
+	}
+
+	// A concurrent poll may have redeemed it already.
+	grant, err = s.opts.grants.Take(ctx, id)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, oauth.NewError(oauth.ErrInvalidGrant, "device_code is not valid")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueGrantTokens(ctx, client, grant)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	clients := oauth.NewMemoryClientStore(oauth.Client{
+		ID:         "cli",
+		Scopes:     []string{"openid", "repo"},
+		GrantTypes: []string{oauth.GrantDeviceCode},
+	})
+	login := oauth.LoginHandlerFunc(func(w http.ResponseWriter, r *http.Request, req *oauth.AuthorizationRequest) (*oauth.Consent, error) {
+		if r.PostForm.Get("deny") != "" {
+			return nil, oauth.NewError(oauth.ErrAccessDenied, "")
+		}
+		return &oauth.Consent{Subject: "user-1", Scopes: []string{"repo"}}, nil
+	})
+	srv := NewHTTPServer(svc, "localhost:3000", WithOAuthClients(clients), WithLogin(login))
+
+	post := func(h HTTPHandlerFunc, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "http://auth.example.com/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		makeHTTPHandler(h)(w, r)
+		return w
+	}
+	authorizeDevice := func() types.DeviceAuthorization {
+		w := post(srv.handleDeviceAuthorization, url.Values{"client_id": {"cli"}, "scope": {"repo"}})
+		var da types.DeviceAuthorization
+		_ = json.NewDecoder(w.Result().Body).Decode(&da)
+		if w.Code != http.StatusOK || da.DeviceCode == "" || da.Interval != 5 || da.VerificationURI != "http://auth.example.com/oauth2/device" {
+			t.Fatalf("unexpected device authorization: %d %+v", w.Code, da)
+		}
+		return da
+	}
+	poll := func(da types.DeviceAuthorization) (*types.TokenPair, string) {
+		w := post(srv.handleOAuthToken, url.Values{"grant_type": {oauth.GrantDeviceCode}, "client_id": {"cli"}, "device_code": {da.DeviceCode}})
+		if w.Code == http.StatusOK {
+			var pair types.TokenPair
+			_ = json.NewDecoder(w.Result().Body).Decode(&pair)
+			return &pair, ""
+		}
+		var oauthErr oauth.Error
+		_ = json.NewDecoder(w.Result().Body).Decode(&oauthErr)
+		return nil, oauthErr.Code
+	}
+	// Lets the next poll through without waiting for the interval.
+	skipInterval := func(da types.DeviceAuthorization) {
+		g, _ := srv.opts.grants.Grant(ctx, oauth.HashSecret(da.DeviceCode))
+		g.PolledAt = time.Time{}
+		_ = srv.opts.grants.Update(ctx, g)
+	}
+
+	da := authorizeDevice()
+	if _, code := poll(da); code != oauth.ErrAuthorizationPending {
+		t.Errorf("error is not the same: want=%s, got=%s", oauth.ErrAuthorizationPending, code)
+	}
+	if _, code := poll(da); code != oauth.ErrSlowDown {
+		t.Errorf("error is not the same: want=%s, got=%s", oauth.ErrSlowDown, code)
+	}
+
+	if w := post(srv.handleDeviceVerification, url.Values{"user_code": {"BCDF-GHJK"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown user code shouldn't be accepted: %d", w.Code)
+	}
+	// User codes are matched ignoring case and separators.
+	userCode := strings.ToLower(strings.ReplaceAll(da.UserCode, "-", ""))
+	if w := post(srv.handleDeviceVerification, url.Values{"user_code": {userCode}}); w.Code != http.StatusOK {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusOK, w.Code)
+	}
+
+	skipInterval(da)
+	pair, code := poll(da)
+	if pair == nil {
+		t.Fatalf("approved device should get a token: %s", code)
+	}
+	in, _ := svc.Introspect(ctx, []byte(pair.AccessToken))
+	if in.Claims["sub"] != "user-1" || in.Claims["scope"] != "repo" {
+		t.Errorf("token should be issued to the user: %+v", in.Claims)
+	}
+	if _, code := poll(da); code != oauth.ErrInvalidGrant {
+		t.Errorf("device code shouldn't be redeemed twice: %s", code)
+	}
+
+	da = authorizeDevice()
+	post(srv.handleDeviceVerification, url.Values{"user_code": {da.UserCode}, "deny": {"1"}})
+	if _, code := poll(da); code != oauth.ErrAccessDenied {
+		t.Errorf("error is not the same: want=%s, got=%s", oauth.ErrAccessDenied, code)
+	}
+	// Guessing user codes is rate-limited per client IP.
+	srv.opts.deviceRate = newRateLimiter(1, time.Minute)
+	post(srv.handleDeviceVerification, url.Values{"user_code": {"BCDF-GHJK"}})
+	w := post(srv.handleDeviceVerification, url.Values{"user_code": {"BCDF-GHJL"}})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status code is not the same: want=%d, got=%d", http.StatusTooManyRequests, w.Code)
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/danblok/auth/internal/oauth"
+)
+
+// Implemented by key providers that know the
+// signing algorithms of their keys, e.g. the keyring.
+type algorithmLister interface {
+	Algs() []string
+}
+
+// Handles RFC 8414 authorization server metadata.
+func (s *HTTPServer) handleOAuthMetadata(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return s.writeMetadata(w, s.metadata(r, false))
+}
+
+// Handles OpenID Connect discovery.
+func (s *HTTPServer) handleOpenIDConfiguration(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return s.writeMetadata(w, s.metadata(r, true))
+}
+
+// Generates the metadata document from the enabled features.
+func (s *HTTPServer) metadata(r *http.Request, openid bool) *oauth.Metadata {
+	iss := s.issuer(r)
+	md := &oauth.Metadata{
+		Issuer:                 iss,
+		ResponseTypesSupported: []string{},
+	}
+
+	if s.opts.keys != nil {
+		md.JWKSURI = iss + "/.well-known/jwks.json"
+	}
+
+	if s.opts.clients != nil {
+		md.TokenEndpoint = iss + "/oauth2/token"
+		md.TokenEndpointAuthMethodsSupported = oauth.ClientAuthMethods
+		md.IntrospectionEndpoint = iss + "/oauth2/introspect"
+		md.IntrospectionEndpointAuthMethods = oauth.ClientAuthMethods
+		md.RevocationEndpoint = iss + "/oauth2/revoke"
+		md.RevocationEndpointAuthMethodsSupported = oauth.ClientAuthMethods
+		for grant := range s.grants() {
+			md.GrantTypesSupported = append(md.GrantTypesSupported, grant)
+		}
+		slices.Sort(md.GrantTypesSupported)
+	}
+
+	// Endpoints of the grants are advertised only while they are served.
+	if _, ok := s.grants()[oauth.GrantAuthorizationCode]; ok && s.opts.clients != nil {
+		md.AuthorizationEndpoint = iss + "/oauth2/authorize"
+		md.ResponseTypesSupported = []string{"code"}
+		md.CodeChallengeMethodsSupported = []string{oauth.CodeChallengeS256}
+		// Public clients redeem codes without authentication.
+		md.TokenEndpointAuthMethodsSupported = append(slices.Clone(oauth.ClientAuthMethods), "none")
+	}
+	if _, ok := s.grants()[oauth.GrantDeviceCode]; ok && s.opts.clients != nil {
+		md.DeviceAuthorizationEndpoint = iss + "/oauth2/device_authorization"
+	}
+
+	if openid {
+		md.SubjectTypesSupported = []string{"public"}
+		if algs, ok := s.opts.keys.(algorithmLister); ok {
+			md.IDTokenSigningAlgValuesSupported = algs.Algs()
+			slices.Sort(md.IDTokenSigningAlgValuesSupported)
+		}
+		if s.opts.profiles != nil {
+			md.UserinfoEndpoint = iss + "/userinfo"
+			md.ClaimsSupported = oauth.StandardClaims()
+		}
+	}
+
+	return md
+}
+
+// Returns the configured issuer or derives it from the request.
+func (s *HTTPServer) issuer(r *http.Request) string {
+	if s.opts.issuer != "" {
+		return strings.TrimSuffix(s.opts.issuer, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
+
+// Responds with the metadata document, cacheable only if the issuer
+// is configured. Shared caches would serve a document derived from
+// the Host header of one request to all others.
+func (s *HTTPServer) writeMetadata(w http.ResponseWriter, md *oauth.Metadata) error {
+	if s.opts.issuer != "" {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, http.StatusOK, md)
+}
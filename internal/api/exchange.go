@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Exchanges the subject token for a token of another audience acting
+// on behalf of its subject, RFC 8693 section 2.
+func (s *HTTPServer) tokenExchangeGrant(ctx context.Context, r *http.Request) (*types.TokenPair, error) {
+	client, err := s.authenticateClient(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(oauth.GrantTokenExchange) {
+		return nil, oauth.NewError(oauth.ErrUnauthorizedClient, "grant type is not allowed for the client")
+	}
+	if !exchangeableTokenType(r.PostForm.Get("requested_token_type"), true) {
+		return nil, oauth.NewError(oauth.ErrInvalidRequest, "requested_token_type is not supported")
+	}
+
+	subject, err := s.exchangedToken(ctx, r, "subject_token")
+	if err != nil {
+		return nil, err
+	}
+	if subject == nil {
+		return nil, oauth.NewError(oauth.ErrInvalidRequest, "subject_token not provided")
+	}
+	sub, _ := subject.Claims["sub"].(string)
+	if sub == "" {
+		return nil, oauth.NewError(oauth.ErrInvalidGrant, "subject_token has no subject")
+	}
+	ttl := time.Until(time.Unix(subject.ExpiresAt, 0))
+	if ttl <= 0 {
+		return nil, oauth.NewError(oauth.ErrInvalidGrant, "subject_token has expired")
+	}
+
+	subjectScope, _ := subject.Claims["scope"].(string)
+	audience := slices.Concat(r.PostForm["audience"], r.PostForm["resource"])
+	scopes, err := client.ResolveExchange(audience, r.PostForm.Get("scope"), strings.Fields(subjectScope))
+	if err != nil {
+		return nil, err
+	}
+
+	// The client acts unless it presents the token of another actor.
+	act := map[string]any{"sub": client.ID}
+	actor, err := s.exchangedToken(ctx, r, "actor_token")
+	if err != nil {
+		return nil, err
+	}
+	if actor != nil {
+		actorSub, _ := actor.Claims["sub"].(string)
+		if actorSub == "" {
+			return nil, oauth.NewError(oauth.ErrInvalidGrant, "actor_token has no subject")
+		}
+		act["sub"] = actorSub
+	}
+	// Prior actors of a delegation chain are nested, RFC 8693 section 4.1.
+	if prior, ok := subject.Claims["act"].(map[string]any); ok {
+		act["act"] = prior
+	}
+
+	// The exchanged token doesn't outlive the subject token. Its scope
+	// is set even if empty, so roles can't grant more than was resolved.
+	pair, err := s.issueOAuthToken(ctx, client, sub, scopes,
+		types.WithServerClaims(map[string]any{"scope": strings.Join(scopes, " ")}),
+		types.WithAudience(audience...), types.WithActor(act), types.WithTTL(ttl))
+	if err != nil {
+		return nil, err
+	}
+	pair.IssuedTokenType = oauth.TokenTypeAccessToken
+
+	return pair, nil
+}
+
+// Validates the token of the form parameter through Validate
+// and introspects it, nil if the parameter is empty.
+func (s *HTTPServer) exchangedToken(ctx context.Context, r *http.Request, param string) (*types.Introspection, error) {
+	token := r.PostForm.Get(param)
+	if token == "" {
+		return nil, nil
+	}
+	if !exchangeableTokenType(r.PostForm.Get(param+"_type"), false) {
+		return nil, oauth.NewError(oauth.ErrInvalidRequest, param+"_type is not supported")
+	}
+
+	if err := s.svc.Validate(ctx, []byte(token)); err != nil {
+		return nil, oauth.NewError(oauth.ErrInvalidGrant, param+" is not valid")
+	}
+
+	return s.svc.Introspect(ctx, []byte(token))
+}
+
+// Reports whether tokens of the type can be exchanged or issued.
+func exchangeableTokenType(typ string, optional bool) bool {
+	return (optional && typ == "") || typ == oauth.TokenTypeAccessToken || typ == oauth.TokenTypeJWT
+}
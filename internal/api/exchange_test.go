@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/internal/rbac"
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestTokenExchange(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	clients := oauth.NewMemoryClientStore(
+		oauth.Client{
+			ID:         "service-a",
+			SecretHash: oauth.HashSecret("s3cr3t"),
+			GrantTypes: []string{oauth.GrantTokenExchange},
+			Exchange:   &oauth.ExchangePolicy{Audiences: []string{"service-b"}, Scopes: []string{"orders:read", "orders:write"}},
+		},
+		oauth.Client{
+			ID:         "service-c",
+			SecretHash: oauth.HashSecret("s3cr3t"),
+			GrantTypes: []string{oauth.GrantTokenExchange},
+		},
+	)
+	srv := NewHTTPServer(svc, "localhost:3000", WithOAuthClients(clients))
+
+	userToken, _ := svc.Token(ctx, nil, types.WithSubject("user-1"), types.WithTTL(time.Hour),
+		types.WithServerClaims(map[string]any{"scope": "orders:read orders:write profile"}))
+	delegated, _ := svc.Token(ctx, nil, types.WithSubject("user-1"), types.WithTTL(time.Hour),
+		types.WithServerClaims(map[string]any{"scope": "orders:read"}), types.WithActor(map[string]any{"sub": "gateway"}))
+	actorToken, _ := svc.Token(ctx, nil, types.WithSubject("worker"))
+	expired, _ := service.NewJWTService([]byte("secret-key"), service.WithTTL(-time.Minute)).Token(ctx, nil, types.WithSubject("user-1"))
+	// Scopes of subject tokens are set by the server, custom claims
+	// and tokens of other keys can't claim them.
+	if _, err := svc.Token(ctx, nil, types.WithSubject("user-1"), types.WithClaims(map[string]any{"scope": "orders:read orders:write"})); err == nil {
+		t.Fatal("scope shouldn't be settable by custom claims")
+	}
+	unscoped, _ := svc.Token(ctx, nil, types.WithSubject("user-1"), types.WithClaims(map[string]any{"team": "orders"}))
+	forged, _ := service.NewJWTService([]byte("other-key")).Token(ctx, nil, types.WithSubject("user-1"),
+		types.WithServerClaims(map[string]any{"scope": "orders:read orders:write"}))
+
+	exchangeForm := func(overrides url.Values) url.Values {
+		form := url.Values{
+			"grant_type":         {oauth.GrantTokenExchange},
+			"subject_token":      {string(userToken)},
+			"subject_token_type": {oauth.TokenTypeAccessToken},
+			"audience":           {"service-b"},
+		}
+		for k, v := range overrides {
+			form[k] = v
+		}
+		return form
+	}
+
+	tests := map[string]struct {
+		form      url.Values
+		client    string
+		wantCode  int
+		wantError string
+		wantScope string
+		wantAct   map[string]any
+	}{
+		"all allowed scopes": {
+			form:      exchangeForm(nil),
+			wantCode:  http.StatusOK,
+			wantScope: "orders:read orders:write",
+			wantAct:   map[string]any{"sub": "service-a"},
+		},
+		"narrowed scope": {
+			form:      exchangeForm(url.Values{"scope": {"orders:read"}}),
+			wantCode:  http.StatusOK,
+			wantScope: "orders:read",
+			wantAct:   map[string]any{"sub": "service-a"},
+		},
+		"actor token": {
+			form:      exchangeForm(url.Values{"actor_token": {string(actorToken)}, "actor_token_type": {oauth.TokenTypeJWT}}),
+			wantCode:  http.StatusOK,
+			wantScope: "orders:read orders:write",
+			wantAct:   map[string]any{"sub": "worker"},
+		},
+		"delegation chain": {
+			form:      exchangeForm(url.Values{"subject_token": {string(delegated)}}),
+			wantCode:  http.StatusOK,
+			wantScope: "orders:read",
+			wantAct:   map[string]any{"sub": "service-a", "act": map[string]any{"sub": "gateway"}},
+		},
+		"scope widened": {
+			form:      exchangeForm(url.Values{"scope": {"profile"}}),
+			wantCode:  http.StatusBadRequest,
+			wantError: oauth.ErrInvalidScope,
+		},
+		"unscoped subject token": {
+			form:      exchangeForm(url.Values{"subject_token": {string(unscoped)}, "scope": {"orders:read"}}),
+			wantCode:  http.StatusBadRequest,
+			wantError: oauth.ErrInvalidScope,
+		},
+		"forged subject token": {
+			form:      exchangeForm(url.Values{"subject_token": {string(forged)}}),
+			wantCode:  http.StatusBadRequest,
+			wantError: oauth.ErrInvalidGrant,
+		},
+		"audience not allowed": {
+			form:      exchangeForm(url.Values{"audience": {"service-d"}}),
+			wantCode:  http.StatusBadRequest,
+			wantError: oauth.ErrInvalidTarget,
+		},
+		"no policy": {
+			form:      exchangeForm(nil),
+			client:    "service-c",
+			wantCode:  http.StatusBadRequest,
+			wantError: oauth.ErrUnauthorizedClient,
+		},
+		"expired subject token": {
+			form:      exchangeForm(url.Values{"subject_token": {string(expired)}}),
+			wantCode:  http.StatusBadRequest,
+			wantError: oauth.ErrInvalidGrant,
+		},
+		"unsupported token type": {
+			form:      exchangeForm(url.Values{"subject_token_type": {"urn:ietf:params:oauth:token-type:saml2"}}),
+			wantCode:  http.StatusBadRequest,
+			wantError: oauth.ErrInvalidRequest,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := tt.client
+			if client == "" {
+				client = "service-a"
+			}
+			r := httptest.NewRequest("POST", "/oauth2/token", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth(client, "s3cr3t")
+			w := httptest.NewRecorder()
+			makeHTTPHandler(srv.handleOAuthToken)(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d: %s", tt.wantCode, w.Code, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				var got oauth.Error
+				_ = json.NewDecoder(w.Result().Body).Decode(&got)
+				if got.Code != tt.wantError {
+					t.Errorf("error is not the same: want=%s, got=%s", tt.wantError, got.Code)
+				}
+				return
+			}
+
+			var pair types.TokenPair
+			_ = json.NewDecoder(w.Result().Body).Decode(&pair)
+			if pair.IssuedTokenType != oauth.TokenTypeAccessToken || pair.Scope != tt.wantScope {
+				t.Errorf("unexpected response: %+v", pair)
+			}
+			in, _ := svc.Introspect(ctx, []byte(pair.AccessToken))
+			if in.Claims["sub"] != "user-1" || mustJSON(t, in.Claims["aud"]) != `["service-b"]` {
+				t.Errorf("token should be issued for the user to service-b: %v", in.Claims)
+			}
+			if got, _ := json.Marshal(in.Claims["act"]); string(got) != mustJSON(t, tt.wantAct) {
+				t.Errorf("act is not the same: want=%s, got=%s", mustJSON(t, tt.wantAct), got)
+			}
+			if in.ExpiresAt > time.Now().Add(time.Hour).Unix() {
+				t.Error("exchanged token shouldn't outlive the subject token")
+			}
+		})
+	}
+}
+
+func TestTokenExchangeRoles(t *testing.T) {
+	ctx := context.Background()
+	userSvc := newUserService(t)
+	u, _ := userSvc.CreateUser(ctx, "jane", "s3cr3t", nil)
+	_, _ = userSvc.UpdateUser(ctx, u.ID, types.UserUpdate{Roles: &[]string{"orders"}})
+	svc := rbac.NewRBACService(service.NewJWTService([]byte("secret-key")), rbac.Roles{"orders": {"orders:read", "orders:write"}}, userSvc)
+	clients := oauth.NewMemoryClientStore(oauth.Client{
+		ID:         "service-a",
+		SecretHash: oauth.HashSecret("s3cr3t"),
+		GrantTypes: []string{oauth.GrantTokenExchange},
+		Exchange:   &oauth.ExchangePolicy{Audiences: []string{"service-b"}, Scopes: []string{"orders:read"}},
+	})
+	srv := NewHTTPServer(svc, "localhost:3000", WithOAuthClients(clients))
+
+	tests := map[string]struct {
+		subjectScope string
+		wantScope    string
+	}{
+		"narrowed by the policy": {
+			subjectScope: "orders:read orders:write",
+			wantScope:    "orders:read",
+		},
+		"nothing left": {
+			subjectScope: "orders:write",
+			wantScope:    "",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			subject, err := svc.Token(ctx, nil, types.WithSubject(u.ID), types.WithTTL(time.Hour),
+				types.WithServerClaims(map[string]any{"scope": tt.subjectScope}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			form := url.Values{
+				"grant_type":         {oauth.GrantTokenExchange},
+				"subject_token":      {string(subject)},
+				"subject_token_type": {oauth.TokenTypeAccessToken},
+				"audience":           {"service-b"},
+			}
+			r := httptest.NewRequest("POST", "/oauth2/token", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth("service-a", "s3cr3t")
+			w := httptest.NewRecorder()
+			makeHTTPHandler(srv.handleOAuthToken)(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status code is not the same: want=%d, got=%d: %s", http.StatusOK, w.Code, w.Body)
+			}
+
+			var pair types.TokenPair
+			_ = json.NewDecoder(w.Result().Body).Decode(&pair)
+			in, _ := svc.Introspect(ctx, []byte(pair.AccessToken))
+			if pair.Scope != tt.wantScope || in.Claims["scope"] != tt.wantScope {
+				t.Errorf("scope is not the same: want=%q, got=%q, %v", tt.wantScope, pair.Scope, in.Claims["scope"])
+			}
+		})
+	}
+}
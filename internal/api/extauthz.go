@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Upstream headers of verified claims. They are always
+// overwritten or removed, so clients can't forge them.
+const (
+	headerAuthSubject = "X-Auth-Subject"
+	headerAuthScopes  = "X-Auth-Scopes"
+	// Base64url encoded JSON of all verified claims.
+	headerAuthClaims = "X-Auth-Claims"
+)
+
+// GRPCAuthzServer implements the Envoy external authorization API
+// via GRPC transport. It is registered next to GRPCTokenServer.
+type GRPCAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+	svc types.TokenService
+}
+
+// Check validates the bearer token of the request and passes its claims
+// upstream as headers, requests without a valid token are denied with 401.
+func (s *GRPCAuthzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	headers := req.GetAttributes().GetRequest().GetHttp().GetHeaders()
+	token, ok := bearerToken(headers["authorization"])
+	if !ok || token == "" {
+		return deniedCheck(`Bearer`, "token not provided"), nil
+	}
+
+	in, err := s.svc.Introspect(ctx, []byte(token))
+	if err != nil {
+		return nil, err
+	}
+	if !in.Valid {
+		return deniedCheck(`Bearer error="invalid_token"`, "token not valid: "+in.Reason), nil
+	}
+
+	upstream, err := claimHeaders(in.Claims)
+	if err != nil {
+		return nil, err
+	}
+	resp := &authv3.OkHttpResponse{}
+	for _, name := range []string{headerAuthSubject, headerAuthScopes, headerAuthClaims} {
+		v, set := upstream[name]
+		if !set {
+			resp.HeadersToRemove = append(resp.HeadersToRemove, strings.ToLower(name))
+			continue
+		}
+		resp.Headers = append(resp.Headers, envoyHeader(name, v))
+	}
+
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: resp},
+	}, nil
+}
+
+// Maps verified claims to upstream headers, claims
+// missing from the token have no header.
+func claimHeaders(claims map[string]any) (map[string]string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{headerAuthClaims: base64.RawURLEncoding.EncodeToString(data)}
+	if sub, _ := claims["sub"].(string); sub != "" {
+		headers[headerAuthSubject] = sub
+	}
+	if scope, _ := claims["scope"].(string); scope != "" {
+		headers[headerAuthScopes] = scope
+	}
+
+	return headers, nil
+}
+
+// Denies the request with 401 and a JSON error body.
+func deniedCheck(challenge, msg string) *authv3.CheckResponse {
+	body, _ := json.Marshal(HTTPErrResponse{Error: msg})
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.Unauthenticated), Message: msg},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status: &typev3.HttpStatus{Code: typev3.StatusCode_Unauthorized},
+			Headers: []*corev3.HeaderValueOption{
+				envoyHeader("Content-Type", "application/json"),
+				envoyHeader("WWW-Authenticate", challenge),
+			},
+			Body: string(body),
+		}},
+	}
+}
+
+// Creates a header that replaces any header of the same name.
+func envoyHeader(name, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: strings.ToLower(name), Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/grpc/codes"
+
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Creates a CheckRequest of Envoy for an HTTP request with the headers.
+func checkRequest(headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+			Method:  "GET",
+			Host:    "api.example.com",
+			Path:    "/invoices",
+			Headers: headers,
+		}},
+	}}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	token, _ := svc.Token(ctx, nil, types.WithSubject("user-1"), types.WithServerClaims(map[string]any{"scope": "invoices:read"}))
+	noScope, _ := svc.Token(ctx, nil, types.WithSubject("user-2"))
+	other, _ := service.NewJWTService([]byte("other-key")).Token(ctx, nil, types.WithSubject("user-1"))
+
+	client := authv3.NewAuthorizationClient(dialGRPCServer(t, NewGRPCServer(svc)))
+
+	tests := map[string]struct {
+		headers     map[string]string
+		wantCode    codes.Code
+		wantHeaders map[string]string
+		wantRemoved []string
+	}{
+		"valid token": {
+			headers:     map[string]string{"authorization": "Bearer " + string(token)},
+			wantCode:    codes.OK,
+			wantHeaders: map[string]string{"x-auth-subject": "user-1", "x-auth-scopes": "invoices:read"},
+		},
+		"token without scope": {
+			headers:     map[string]string{"authorization": "Bearer " + string(noScope), "x-auth-scopes": "forged"},
+			wantCode:    codes.OK,
+			wantHeaders: map[string]string{"x-auth-subject": "user-2"},
+			wantRemoved: []string{"x-auth-scopes"},
+		},
+		"no token": {
+			headers:  map[string]string{},
+			wantCode: codes.Unauthenticated,
+		},
+		"token of another key": {
+			headers:  map[string]string{"authorization": "Bearer " + string(other)},
+			wantCode: codes.Unauthenticated,
+		},
+		"malformed token": {
+			headers:  map[string]string{"authorization": "Bearer some-random-text"},
+			wantCode: codes.Unauthenticated,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, err := client.Check(ctx, checkRequest(tt.headers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codes.Code(resp.Status.Code) != tt.wantCode {
+				t.Fatalf("status codes are not the same: want=%v, got=%v", tt.wantCode, codes.Code(resp.Status.Code))
+			}
+
+			if tt.wantCode != codes.OK {
+				denied := resp.GetDeniedResponse()
+				if denied.GetStatus().GetCode() != typev3.StatusCode_Unauthorized {
+					t.Errorf("status code is not the same: want=%v, got=%v", typev3.StatusCode_Unauthorized, denied.GetStatus().GetCode())
+				}
+				var body HTTPErrResponse
+				if err := json.Unmarshal([]byte(denied.GetBody()), &body); err != nil || body.Error == "" {
+					t.Errorf("body should have the error: %q", denied.GetBody())
+				}
+				return
+			}
+
+			ok := resp.GetOkResponse()
+			got := make(map[string]string, len(ok.GetHeaders()))
+			for _, h := range ok.GetHeaders() {
+				got[h.Header.Key] = h.Header.Value
+			}
+			for k, v := range tt.wantHeaders {
+				if got[k] != v {
+					t.Errorf("header %s is not the same: want=%q, got=%q", k, v, got[k])
+				}
+			}
+			for _, k := range tt.wantRemoved {
+				if _, set := got[k]; set || !slices.Contains(ok.GetHeadersToRemove(), k) {
+					t.Errorf("header %s should be removed: %+v", k, ok)
+				}
+			}
+
+			data, _ := base64.RawURLEncoding.DecodeString(got["x-auth-claims"])
+			var claims map[string]any
+			if err := json.Unmarshal(data, &claims); err != nil || claims["sub"] != tt.wantHeaders["x-auth-subject"] {
+				t.Errorf("claims header should have the verified claims: %s", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/danblok/auth/internal/rbac"
+)
+
+// Handles subrequests of edge proxies, e.g. nginx auth_request or Traefik
+// ForwardAuth. Requests with a valid token get 200 with identity headers,
+// the proxy passes them upstream. Others get 401, or 403 if a rule of the
+// forwarded path requires a permission the token doesn't grant. With
+// rules, requests without a valid forwarded URI get 400.
+func (s *HTTPServer) handleForwardAuth(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	token, ok := bearerToken(r.Header.Get("Authorization"))
+	if !ok && s.opts.authCookie != "" {
+		if c, err := r.Cookie(s.opts.authCookie); err == nil {
+			token = c.Value
+		}
+	}
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: "token not provided"})
+	}
+
+	in, err := s.svc.Introspect(ctx, []byte(token))
+	if err != nil {
+		return err
+	}
+	if !in.Valid {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: "token not valid: " + in.Reason})
+	}
+
+	method := r.Header.Get("X-Forwarded-Method")
+	if method == "" {
+		method = http.MethodGet
+	}
+	var path string
+	if u, err := url.ParseRequestURI(r.Header.Get("X-Forwarded-Uri")); err == nil {
+		path = u.Path
+	} else if len(s.opts.pathRules) > 0 {
+		return writeJSON(w, http.StatusBadRequest, HTTPErrResponse{Error: "X-Forwarded-Uri not valid"})
+	}
+	if rule, ok := s.opts.pathRules.Match(method, path); ok && rule.Permission != "" {
+		scope, _ := in.Claims["scope"].(string)
+		if _, ok := rbac.Grant(strings.Fields(scope), rule.Permission); !ok {
+			return writeJSON(w, http.StatusForbidden, HTTPErrResponse{Error: fmt.Sprintf("scope doesn't grant %s", rule.Permission)})
+		}
+	}
+
+	headers, err := claimHeaders(in.Claims)
+	if err != nil {
+		return err
+	}
+	for name, v := range headers {
+		w.Header().Set(name, v)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
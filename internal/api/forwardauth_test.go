@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danblok/auth/internal/rbac"
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestHandleForwardAuth(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	token, _ := svc.Token(ctx, nil, types.WithSubject("user-1"), types.WithServerClaims(map[string]any{"scope": "invoices:read"}))
+	other, _ := service.NewJWTService([]byte("other-key")).Token(ctx, nil, types.WithSubject("user-1"))
+	rules := rbac.PathRules{
+		{Path: "/invoices/", Permission: "invoices:read"},
+		{Path: "/invoices/", Methods: []string{"POST"}, Permission: "invoices:write"},
+	}
+	h := NewHTTPServer(svc, "localhost:3000", WithForwardAuth("session", rules)).routes()
+
+	tests := map[string]struct {
+		bearer      string
+		scheme      string
+		cookie      string
+		method      string
+		uri         string
+		wantCode    int
+		wantSubject string
+	}{
+		"bearer token": {
+			bearer:      string(token),
+			uri:         "/invoices/42?expand=lines",
+			wantCode:    http.StatusOK,
+			wantSubject: "user-1",
+		},
+		"cookie": {
+			cookie:      string(token),
+			uri:         "/invoices/42",
+			wantCode:    http.StatusOK,
+			wantSubject: "user-1",
+		},
+		"lowercase scheme": {
+			bearer:      string(token),
+			scheme:      "bearer",
+			uri:         "/invoices/42",
+			wantCode:    http.StatusOK,
+			wantSubject: "user-1",
+		},
+		"no uri": {
+			bearer:   string(token),
+			wantCode: http.StatusBadRequest,
+		},
+		"malformed uri": {
+			bearer:   string(token),
+			uri:      "invoices/42",
+			wantCode: http.StatusBadRequest,
+		},
+		"path without rule": {
+			bearer:      string(token),
+			uri:         "/profile",
+			wantCode:    http.StatusOK,
+			wantSubject: "user-1",
+		},
+		"permission not granted": {
+			bearer:   string(token),
+			method:   "POST",
+			uri:      "/invoices/",
+			wantCode: http.StatusForbidden,
+		},
+		"no token": {
+			uri:      "/invoices/42",
+			wantCode: http.StatusUnauthorized,
+		},
+		"token of another key": {
+			bearer:   string(other),
+			uri:      "/invoices/42",
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/auth", nil)
+			if tt.bearer != "" {
+				scheme := "Bearer"
+				if tt.scheme != "" {
+					scheme = tt.scheme
+				}
+				r.Header.Set("Authorization", scheme+" "+tt.bearer)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			if tt.method != "" {
+				r.Header.Set("X-Forwarded-Method", tt.method)
+			}
+			r.Header.Set("X-Forwarded-Uri", tt.uri)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d: %s", tt.wantCode, w.Code, w.Body)
+			}
+
+			if got := w.Header().Get("X-Auth-Subject"); got != tt.wantSubject {
+				t.Errorf("subjects are not the same: want=%q, got=%q", tt.wantSubject, got)
+			}
+			if tt.wantCode == http.StatusOK && w.Header().Get("X-Auth-Scopes") != "invoices:read" {
+				t.Errorf("unexpected scopes: %q", w.Header().Get("X-Auth-Scopes"))
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/danblok/auth/pkg/types"
+	"github.com/danblok/auth/proto"
+)
+
+// GRPCAdminServer implements administrative API via GRPC transport.
+// It is registered next to GRPCTokenServer when the admin API is enabled.
+type GRPCAdminServer struct {
+	proto.UnimplementedAdminServiceServer
+	opts options
+}
+
+// Checks the admin bearer token of calls to the admin methods.
+func adminAuthInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !adminMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		if !validAdminToken(md.Get("authorization"), token) {
+			return nil, status.Error(codes.Unauthenticated, "invalid admin token")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// Reports whether the method requires the admin token. Besides the admin
+// service, tokens of any subject and claims are issued to admins only.
+func adminMethod(method string) bool {
+	return strings.HasPrefix(method, "/"+proto.AdminService_ServiceDesc.ServiceName+"/") ||
+		method == proto.TokenService_Token_FullMethodName ||
+		method == proto.TokenService_TokenPair_FullMethodName
+}
+
+// Compares bearer credentials with the admin token in constant time.
+func validAdminToken(values []string, token string) bool {
+	for _, v := range values {
+		bearer, ok := bearerToken(v)
+		if ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RotateKey promotes a new signing key.
+func (s *GRPCAdminServer) RotateKey(ctx context.Context, req *proto.RotateKeyRequest) (*proto.RotateKeyResponse, error) {
+	if s.opts.rotator == nil {
+		return nil, status.Error(codes.Unimplemented, "key rotation is not enabled")
+	}
+
+	overlap := time.Duration(req.OverlapSeconds) * time.Second
+	if overlap <= 0 {
+		overlap = s.opts.overlap
+	}
+	kid, err := s.opts.rotator.RotateKey(ctx, []byte(req.PrivateKey), overlap)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &proto.RotateKeyResponse{Kid: kid}, nil
+}
+
+// Revoke revokes a token, a token by its jti or all tokens of a subject.
+func (s *GRPCAdminServer) Revoke(ctx context.Context, req *proto.RevokeRequest) (*proto.RevokeResponse, error) {
+	if s.opts.revoker == nil {
+		return nil, status.Error(codes.Unimplemented, "revocation is not enabled")
+	}
+
+	err := revoke(ctx, s.opts.revoker, s.opts.refresher, RevokeBody{
+		Token:   req.Token,
+		ID:      req.Jti,
+		Subject: req.Subject,
+		Before:  req.Before,
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &proto.RevokeResponse{}, nil
+}
+
+// CreateUser creates a user with the password.
+func (s *GRPCAdminServer) CreateUser(ctx context.Context, req *proto.CreateUserRequest) (*proto.User, error) {
+	if s.opts.users == nil {
+		return nil, status.Error(codes.Unimplemented, "users are not enabled")
+	}
+
+	u, err := s.opts.users.CreateUser(ctx, req.Username, req.Password, req.Profile.AsMap())
+	if err != nil {
+		return nil, userStatus(err)
+	}
+
+	return userMessage(u)
+}
+
+// GetUser returns the user.
+func (s *GRPCAdminServer) GetUser(ctx context.Context, req *proto.GetUserRequest) (*proto.User, error) {
+	if s.opts.users == nil {
+		return nil, status.Error(codes.Unimplemented, "users are not enabled")
+	}
+
+	u, err := s.opts.users.User(ctx, req.Id)
+	if err != nil {
+		return nil, userStatus(err)
+	}
+
+	return userMessage(u)
+}
+
+// ListUsers returns all users.
+func (s *GRPCAdminServer) ListUsers(ctx context.Context, req *proto.ListUsersRequest) (*proto.ListUsersResponse, error) {
+	if s.opts.users == nil {
+		return nil, status.Error(codes.Unimplemented, "users are not enabled")
+	}
+
+	users, err := s.opts.users.Users(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &proto.ListUsersResponse{Users: make([]*proto.User, 0, len(users))}
+	for _, u := range users {
+		msg, err := userMessage(u)
+		if err != nil {
+			return nil, err
+		}
+		resp.Users = append(resp.Users, msg)
+	}
+
+	return resp, nil
+}
+
+// UpdateUser changes the password, profile, status or roles of the user.
+func (s *GRPCAdminServer) UpdateUser(ctx context.Context, req *proto.UpdateUserRequest) (*proto.User, error) {
+	if s.opts.users == nil {
+		return nil, status.Error(codes.Unimplemented, "users are not enabled")
+	}
+
+	upd := types.UserUpdate{
+		Password: req.Password,
+		Profile:  req.Profile.AsMap(),
+		Disabled: req.Disabled,
+		ResetMFA: req.ResetMfa,
+	}
+	if req.Roles != nil {
+		roles := req.Roles.Roles
+		upd.Roles = &roles
+	}
+	u, err := s.opts.users.UpdateUser(ctx, req.Id, upd)
+	if err != nil {
+		return nil, userStatus(err)
+	}
+
+	return userMessage(u)
+}
+
+// DeleteUser deletes the user.
+func (s *GRPCAdminServer) DeleteUser(ctx context.Context, req *proto.DeleteUserRequest) (*proto.DeleteUserResponse, error) {
+	if s.opts.users == nil {
+		return nil, status.Error(codes.Unimplemented, "users are not enabled")
+	}
+
+	if err := s.opts.users.DeleteUser(ctx, req.Id); err != nil {
+		return nil, userStatus(err)
+	}
+
+	return &proto.DeleteUserResponse{}, nil
+}
+
+// CreateAPIKey creates an API key and returns its secret once.
+func (s *GRPCAdminServer) CreateAPIKey(ctx context.Context, req *proto.CreateAPIKeyRequest) (*proto.CreateAPIKeyResponse, error) {
+	if s.opts.apiKeys == nil {
+		return nil, status.Error(codes.Unimplemented, "api keys are not enabled")
+	}
+
+	key, k, err := s.opts.apiKeys.CreateAPIKey(ctx, types.NewAPIKey{
+		Label:   req.Label,
+		Subject: req.Subject,
+		Scopes:  req.Scopes,
+		TTL:     req.TtlSeconds,
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &proto.CreateAPIKeyResponse{Key: key, ApiKey: apiKeyMessage(k)}, nil
+}
+
+// ListAPIKeys lists API keys, including revoked and expired ones.
+func (s *GRPCAdminServer) ListAPIKeys(ctx context.Context, _ *proto.ListAPIKeysRequest) (*proto.ListAPIKeysResponse, error) {
+	if s.opts.apiKeys == nil {
+		return nil, status.Error(codes.Unimplemented, "api keys are not enabled")
+	}
+
+	keys, err := s.opts.apiKeys.APIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &proto.ListAPIKeysResponse{ApiKeys: make([]*proto.APIKey, 0, len(keys))}
+	for _, k := range keys {
+		resp.ApiKeys = append(resp.ApiKeys, apiKeyMessage(k))
+	}
+
+	return resp, nil
+}
+
+// RevokeAPIKey revokes the API key.
+func (s *GRPCAdminServer) RevokeAPIKey(ctx context.Context, req *proto.RevokeAPIKeyRequest) (*proto.RevokeAPIKeyResponse, error) {
+	if s.opts.apiKeys == nil {
+		return nil, status.Error(codes.Unimplemented, "api keys are not enabled")
+	}
+
+	err := s.opts.apiKeys.RevokeAPIKey(ctx, req.Id)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "api key not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.RevokeAPIKeyResponse{}, nil
+}
+
+// Converts the API key to its message.
+func apiKeyMessage(k *types.APIKey) *proto.APIKey {
+	return &proto.APIKey{
+		Id:         k.ID,
+		Label:      k.Label,
+		Subject:    k.Subject,
+		Scopes:     k.Scopes,
+		ExpiresAt:  unixOrZero(k.ExpiresAt),
+		LastUsedAt: unixOrZero(k.LastUsedAt),
+		RevokedAt:  unixOrZero(k.RevokedAt),
+		CreatedAt:  unixOrZero(k.CreatedAt),
+	}
+}
+
+// Converts the time to unix seconds, zero times to zero.
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// Converts the user to its message.
+func userMessage(u *types.User) (*proto.User, error) {
+	profile, err := structpb.NewStruct(u.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.User{
+		Id:        u.ID,
+		Username:  u.Username,
+		Profile:   profile,
+		Disabled:  u.Disabled,
+		CreatedAt: u.CreatedAt.Unix(),
+		UpdatedAt: u.UpdatedAt.Unix(),
+		Roles:     u.Roles,
+	}, nil
+}
+
+// Maps errors of user operations to status codes.
+func userStatus(err error) error {
+	switch {
+	case errors.Is(err, types.ErrNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, types.ErrAlreadyExists):
+		return status.Error(codes.AlreadyExists, "username is taken")
+	}
+
+	return status.Error(codes.InvalidArgument, err.Error())
+}
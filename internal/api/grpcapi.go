@@ -3,11 +3,15 @@ package api
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"net"
 
-	"github.com/google/uuid"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/danblok/auth/pkg/types"
 	"github.com/danblok/auth/proto"
@@ -16,13 +20,15 @@ import (
 // GRPCTokenServer implements TokenService via GRPC transport.
 type GRPCTokenServer struct {
 	proto.UnimplementedTokenServiceServer
-	svc types.TokenService
+	svc  types.TokenService
+	opts options
 }
 
 // NewGRPCServer creates new GRPC server.
-func NewGRPCServer(svc types.TokenService) *GRPCTokenServer {
+func NewGRPCServer(svc types.TokenService, opts ...Option) *GRPCTokenServer {
 	return &GRPCTokenServer{
-		svc: svc,
+		svc:  svc,
+		opts: newOptions(opts),
 	}
 }
 
@@ -34,10 +40,7 @@ func (s *GRPCTokenServer) Serve(addr string) error {
 	}
 	defer ln.Close()
 
-	grpcServer := grpc.NewServer()
-	proto.RegisterTokenServiceServer(grpcServer, s)
-
-	return grpcServer.Serve(ln)
+	return s.newServer().Serve(ln)
 }
 
 // ServeTLS runs GRPC server with TLS.
@@ -52,28 +55,92 @@ func (s *GRPCTokenServer) ServeTLS(addr string, cert tls.Certificate) error {
 	}
 	defer ln.Close()
 
+	return s.newServer(opts...).Serve(ln)
+}
+
+// Creates a GRPC server with registered services.
+func (s *GRPCTokenServer) newServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(requestIDInterceptor))
+	if s.opts.adminToken != "" {
+		opts = append(opts, grpc.ChainUnaryInterceptor(adminAuthInterceptor(s.opts.adminToken)))
+	}
+
 	grpcServer := grpc.NewServer(opts...)
 	proto.RegisterTokenServiceServer(grpcServer, s)
+	authv3.RegisterAuthorizationServer(grpcServer, &GRPCAuthzServer{svc: s.svc})
+	if s.opts.adminToken != "" {
+		proto.RegisterAdminServiceServer(grpcServer, &GRPCAdminServer{opts: s.opts})
+	}
 
-	return grpcServer.Serve(ln)
+	return grpcServer
 }
 
 // Token provides API on behalf of the GRPC server to receive token.
 func (s *GRPCTokenServer) Token(ctx context.Context, req *proto.TokenRequest) (*proto.TokenResponse, error) {
-	reqID := uuid.NewString()
-	ctx = context.WithValue(ctx, types.RequestID("request_id"), reqID)
-	token, err := s.svc.Token(ctx, []byte(req.Payload))
+	if s.opts.adminToken == "" {
+		return nil, status.Error(codes.Unimplemented, "issuing tokens requires the admin API")
+	}
+
+	token, err := s.svc.Token(ctx, []byte(req.Payload), tokenOptions(req.TtlSeconds, req.Audience, req.Subject, req.Claims.AsMap())...)
 	if err != nil {
 		return nil, err
 	}
 
-	return &proto.TokenResponse{Token: string(token)}, nil
+	resp := &proto.TokenResponse{Token: string(token)}
+	if oidc := req.Openid; oidc != nil {
+		idToken, err := issueIDToken(ctx, s.svc, token, req.Subject, oidc.ClientId, oidc.Nonce, oidc.AuthTime)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		resp.IdToken = string(idToken)
+	}
+
+	return resp, nil
+}
+
+// TokenPair provides API on behalf of the GRPC server to receive access and refresh tokens.
+func (s *GRPCTokenServer) TokenPair(ctx context.Context, req *proto.TokenRequest) (*proto.TokenPairResponse, error) {
+	if s.opts.adminToken == "" {
+		return nil, status.Error(codes.Unimplemented, "issuing tokens requires the admin API")
+	}
+	if s.opts.refresher == nil {
+		return nil, status.Error(codes.Unimplemented, "refresh tokens are not enabled")
+	}
+
+	pair, err := s.opts.refresher.TokenPair(ctx, []byte(req.Payload), tokenOptions(req.TtlSeconds, req.Audience, req.Subject, req.Claims.AsMap())...)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenPairResponse(pair), nil
+}
+
+// Refresh provides API on behalf of the GRPC server to exchange refresh token for a new pair.
+func (s *GRPCTokenServer) Refresh(ctx context.Context, req *proto.RefreshRequest) (*proto.TokenPairResponse, error) {
+	if s.opts.refresher == nil {
+		return nil, status.Error(codes.Unimplemented, "refresh tokens are not enabled")
+	}
+
+	pair, err := s.opts.refresher.Refresh(ctx, []byte(req.RefreshToken))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return tokenPairResponse(pair), nil
+}
+
+// Converts a token pair to its GRPC response.
+func tokenPairResponse(pair *types.TokenPair) *proto.TokenPairResponse {
+	return &proto.TokenPairResponse{
+		AccessToken:  pair.AccessToken,
+		TokenType:    pair.TokenType,
+		ExpiresIn:    pair.ExpiresIn,
+		RefreshToken: pair.RefreshToken,
+	}
 }
 
 // Validate provides API on behalf of the GRPC server to validate token.
 func (s *GRPCTokenServer) Validate(ctx context.Context, req *proto.ValidateRequest) (*proto.ValidateResponse, error) {
-	reqID := uuid.NewString()
-	ctx = context.WithValue(ctx, types.RequestID("request_id"), reqID)
 	err := s.svc.Validate(ctx, []byte(req.Token))
 	if err != nil {
 		return &proto.ValidateResponse{Valid: false}, nil
@@ -81,3 +148,142 @@ func (s *GRPCTokenServer) Validate(ctx context.Context, req *proto.ValidateReque
 
 	return &proto.ValidateResponse{Valid: true}, nil
 }
+
+// Introspect provides API on behalf of the GRPC server to receive verified claims of token.
+func (s *GRPCTokenServer) Introspect(ctx context.Context, req *proto.IntrospectRequest) (*proto.IntrospectResponse, error) {
+	in, err := s.svc.Introspect(ctx, []byte(req.Token))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := structpb.NewStruct(in.Claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.IntrospectResponse{
+		Valid:  in.Valid,
+		Reason: in.Reason,
+		Claims: claims,
+		Exp:    in.ExpiresAt,
+		Iat:    in.IssuedAt,
+		Kid:    in.KeyID,
+	}, nil
+}
+
+// Keys provides API on behalf of the GRPC server to receive verification keys.
+func (s *GRPCTokenServer) Keys(ctx context.Context, _ *proto.KeysRequest) (*proto.KeysResponse, error) {
+	if s.opts.keys == nil {
+		return nil, status.Error(codes.Unimplemented, "keys are not published")
+	}
+
+	set, err := s.opts.keys.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &proto.KeysResponse{Keys: make([]*proto.JWK, 0, len(set.Keys))}
+	for _, k := range set.Keys {
+		resp.Keys = append(resp.Keys, &proto.JWK{
+			Kty: k.KeyType,
+			Kid: k.KeyID,
+			Alg: k.Algorithm,
+			Use: k.Use,
+			N:   k.N,
+			E:   k.E,
+			Crv: k.Curve,
+			X:   k.X,
+			Y:   k.Y,
+		})
+	}
+
+	return resp, nil
+}
+
+// Login checks the credentials of the user and issues a token,
+// or an MFA challenge if the user has a second factor.
+func (s *GRPCTokenServer) Login(ctx context.Context, req *proto.LoginRequest) (*proto.LoginResponse, error) {
+	if s.opts.users == nil {
+		return nil, status.Error(codes.Unimplemented, "login is not enabled")
+	}
+
+	resp, err := login(ctx, s.svc, s.opts.users, s.opts.grants, LoginBody{
+		Username: req.Username,
+		Password: req.Password,
+		TTL:      req.TtlSeconds,
+		Audience: req.Audience,
+	})
+	if errors.Is(err, types.ErrInvalidCredentials) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &proto.LoginResponse{Token: resp.Token, MfaToken: resp.MFAToken}, nil
+}
+
+// LoginMFA checks the code of the challenged user and issues a token.
+func (s *GRPCTokenServer) LoginMFA(ctx context.Context, req *proto.LoginMFARequest) (*proto.LoginResponse, error) {
+	if s.opts.users == nil {
+		return nil, status.Error(codes.Unimplemented, "login is not enabled")
+	}
+
+	token, err := loginMFA(ctx, s.svc, s.opts.users, s.opts.grants, MFABody{
+		MFAToken: req.MfaToken,
+		Code:     req.Code,
+		TTL:      req.TtlSeconds,
+		Audience: req.Audience,
+	})
+	if errors.Is(err, types.ErrInvalidCredentials) || errors.Is(err, errInvalidChallenge) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &proto.LoginResponse{Token: string(token)}, nil
+}
+
+// ExchangeAPIKey issues a short-lived token for the API key.
+func (s *GRPCTokenServer) ExchangeAPIKey(ctx context.Context, req *proto.ExchangeAPIKeyRequest) (*proto.TokenResponse, error) {
+	if s.opts.apiKeys == nil {
+		return nil, status.Error(codes.Unimplemented, "api keys are not enabled")
+	}
+
+	token, err := exchangeAPIKey(ctx, s.svc, s.opts.apiKeys, APIKeyTokenBody{
+		APIKey:   req.ApiKey,
+		TTL:      req.TtlSeconds,
+		Audience: req.Audience,
+		Scope:    req.Scope,
+	})
+	if errors.Is(err, types.ErrInvalidCredentials) {
+		return nil, status.Error(codes.Unauthenticated, "api key not valid")
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &proto.TokenResponse{Token: string(token)}, nil
+}
+
+// Authorize answers whether the token grants the permission.
+func (s *GRPCTokenServer) Authorize(ctx context.Context, req *proto.AuthorizeRequest) (*proto.AuthorizeResponse, error) {
+	if s.opts.authorizer == nil {
+		return nil, status.Error(codes.Unimplemented, "authorization is not enabled")
+	}
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token not provided")
+	}
+
+	d, err := s.opts.authorizer.Authorize(ctx, []byte(req.Token), req.Permission)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &proto.AuthorizeResponse{
+		Allowed: d.Allowed,
+		Reason:  d.Reason,
+		Subject: d.Subject,
+	}, nil
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/danblok/auth/internal/refresh"
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/proto"
+)
+
+// Serves the GRPC server in memory and returns a connection to it.
+func dialGRPCServer(t *testing.T, s *GRPCTokenServer) *grpc.ClientConn {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	srv := s.newServer()
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestGRPCTokenRequiresAdmin(t *testing.T) {
+	svc := service.NewJWTService([]byte("secret-key"))
+	refresher := refresh.NewRefreshService(svc, refresh.NewMemoryStore(), 0, 0)
+	admin := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer admin-token")
+
+	tests := map[string]struct {
+		opts     []Option
+		ctx      context.Context
+		wantCode codes.Code
+	}{
+		"admin": {
+			opts:     []Option{WithAdmin("admin-token"), WithRefresher(refresher)},
+			ctx:      admin,
+			wantCode: codes.OK,
+		},
+		"no admin token": {
+			opts:     []Option{WithAdmin("admin-token"), WithRefresher(refresher)},
+			ctx:      context.Background(),
+			wantCode: codes.Unauthenticated,
+		},
+		"admin API disabled": {
+			opts:     []Option{WithRefresher(refresher)},
+			ctx:      admin,
+			wantCode: codes.Unimplemented,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := proto.NewTokenServiceClient(dialGRPCServer(t, NewGRPCServer(svc, tt.opts...)))
+			req := &proto.TokenRequest{Payload: "some payload", Subject: "user-1"}
+
+			_, err := client.Token(tt.ctx, req)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Token: status codes are not the same: want=%v, got=%v", tt.wantCode, status.Code(err))
+			}
+			_, err = client.TokenPair(tt.ctx, req)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("TokenPair: status codes are not the same: want=%v, got=%v", tt.wantCode, status.Code(err))
+			}
+		})
+	}
+}
+
+// KeyRotator recording the overlap of the last rotation.
+type overlapRecorder struct {
+	overlap time.Duration
+}
+
+func (r *overlapRecorder) RotateKey(_ context.Context, _ []byte, overlap time.Duration) (string, error) {
+	r.overlap = overlap
+	return "kid", nil
+}
+
+func TestGRPCRotateKeyOverlap(t *testing.T) {
+	svc := service.NewJWTService([]byte("secret-key"))
+	rotator := &overlapRecorder{}
+	conn := dialGRPCServer(t, NewGRPCServer(svc, WithAdmin("admin-token"), WithKeyRotator(rotator, 24*time.Hour)))
+	client := proto.NewAdminServiceClient(conn)
+	admin := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer admin-token")
+
+	tests := map[string]struct {
+		seconds int64
+		want    time.Duration
+	}{
+		"default": {want: 24 * time.Hour},
+		"set":     {seconds: 60, want: time.Minute},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := client.RotateKey(admin, &proto.RotateKeyRequest{OverlapSeconds: tt.seconds}); err != nil {
+				t.Fatal(err)
+			}
+			if rotator.overlap != tt.want {
+				t.Errorf("overlaps are not the same: want=%v, got=%v", tt.want, rotator.overlap)
+			}
+		})
+	}
+}
@@ -6,9 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"golang.org/x/net/http2"
 
 	"github.com/danblok/auth/pkg/types"
@@ -16,9 +16,10 @@ import (
 
 // HTTPServer implementation for TokenService.
 type HTTPServer struct {
-	svc types.TokenService
-	srv *http.Server
-	tls bool
+	svc  types.TokenService
+	srv  *http.Server
+	tls  bool
+	opts options
 }
 
 // HTTPHandlerFunc is a helper handler func.
@@ -27,13 +28,35 @@ type HTTPHandlerFunc func(context.Context, http.ResponseWriter, *http.Request) e
 // Body represents the body
 // of a request to receive a token.
 type Body struct {
-	Payload string `json:"payload"`
+	Payload  string   `json:"payload"`
+	TTL      int64    `json:"ttl,omitempty"`
+	Audience []string `json:"audience,omitempty"`
+	Subject  string   `json:"subject,omitempty"`
+	// Claims are custom claims merged into the token.
+	Claims map[string]any `json:"claims,omitempty"`
+	// OpenID requests an ID token issued alongside the token.
+	OpenID *OpenIDBody `json:"openid,omitempty"`
+}
+
+// OpenIDBody describes the ID token requested with the token.
+type OpenIDBody struct {
+	ClientID string `json:"client_id"`
+	Nonce    string `json:"nonce,omitempty"`
+	// AuthTime is the unix time the subject authenticated at.
+	AuthTime int64 `json:"auth_time,omitempty"`
+}
+
+// RefreshBody represents the body of a request
+// to exchange a refresh token for a new pair.
+type RefreshBody struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 // NewHTTPServer constructs new HTTPServer that signs and validates tokens via HTTP.
-func NewHTTPServer(svc types.TokenService, addr string) *HTTPServer {
+func NewHTTPServer(svc types.TokenService, addr string, opts ...Option) *HTTPServer {
 	return &HTTPServer{
-		svc: svc,
+		svc:  svc,
+		opts: newOptions(opts),
 		srv: &http.Server{
 			Addr:        addr,
 			ReadTimeout: 3 * time.Second,
@@ -44,7 +67,7 @@ func NewHTTPServer(svc types.TokenService, addr string) *HTTPServer {
 }
 
 // NewHTTPServerTLS constructs new HTTPServer that signs and validates tokens via HTTP securely.
-func NewHTTPServerTLS(svc types.TokenService, addr string, cert tls.Certificate) (*HTTPServer, error) {
+func NewHTTPServerTLS(svc types.TokenService, addr string, cert tls.Certificate, opts ...Option) (*HTTPServer, error) {
 	srv := &http.Server{
 		Addr:        addr,
 		ReadTimeout: 3 * time.Second,
@@ -58,18 +81,16 @@ func NewHTTPServerTLS(svc types.TokenService, addr string, cert tls.Certificate)
 	}
 
 	return &HTTPServer{
-		svc: svc,
-		tls: true,
-		srv: srv,
+		svc:  svc,
+		tls:  true,
+		srv:  srv,
+		opts: newOptions(opts),
 	}, nil
 }
 
 // Run starts the HTTPServer
 func (s *HTTPServer) Run() error {
-	mux := http.NewServeMux()
-	mux.Handle("POST /token", makeHTTPHandler(s.handleTokenReceive))
-	mux.Handle("GET /validate", makeHTTPHandler(s.handleTokenValidation))
-	s.srv.Handler = mux
+	s.srv.Handler = s.routes()
 
 	if s.tls {
 		return s.srv.ListenAndServeTLS("", "")
@@ -78,11 +99,93 @@ func (s *HTTPServer) Run() error {
 	return s.srv.ListenAndServe()
 }
 
-// Attaches request_id to the context and returns http.Handler.
-func makeHTTPHandler(fn HTTPHandlerFunc) http.HandlerFunc {
-	ctx := context.WithValue(context.Background(), types.RequestID("request_id"), uuid.NewString())
+// Registers the routes of the enabled features.
+func (s *HTTPServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /validate", makeHTTPHandler(s.handleTokenValidation))
+	mux.Handle("GET /introspect", makeHTTPHandler(s.handleTokenIntrospection))
+	mux.Handle("GET /auth", makeHTTPHandler(s.handleForwardAuth))
+	mux.Handle("GET /.well-known/oauth-authorization-server", makeHTTPHandler(s.handleOAuthMetadata))
+	mux.Handle("GET /.well-known/openid-configuration", makeHTTPHandler(s.handleOpenIDConfiguration))
+	if s.opts.keys != nil {
+		mux.Handle("GET /.well-known/jwks.json", makeHTTPHandler(s.handleJWKS))
+	}
+	if s.opts.authorizer != nil {
+		mux.Handle("POST /authorize", makeHTTPHandler(s.handleAuthorizePermission))
+	}
+	if s.opts.tokenReview != nil {
+		mux.Handle("POST /tokenreview", makeHTTPHandler(s.handleTokenReview))
+	}
+	if s.opts.registry != nil {
+		mux.Handle("GET /registry/token", makeHTTPHandler(s.handleRegistryToken))
+	}
+	if s.opts.refresher != nil {
+		mux.Handle("POST /refresh", makeHTTPHandler(s.handleRefresh))
+	}
+	if s.opts.clients != nil {
+		mux.Handle("POST /oauth2/token", makeHTTPHandler(s.handleOAuthToken))
+		mux.Handle("POST /oauth2/introspect", makeHTTPHandler(s.handleOAuthIntrospect))
+		mux.Handle("POST /oauth2/revoke", makeHTTPHandler(s.handleOAuthRevoke))
+	}
+	if s.opts.clients != nil && s.opts.login != nil {
+		mux.Handle("GET /oauth2/authorize", makeHTTPHandler(s.handleAuthorize))
+		mux.Handle("POST /oauth2/authorize", makeHTTPHandler(s.handleAuthorize))
+		mux.Handle("POST /oauth2/device_authorization", makeHTTPHandler(s.handleDeviceAuthorization))
+		mux.Handle("GET /oauth2/device", makeHTTPHandler(s.handleDeviceVerificationPage))
+		mux.Handle("POST /oauth2/device", makeHTTPHandler(s.handleDeviceVerification))
+	}
+	if s.opts.profiles != nil {
+		mux.Handle("GET /userinfo", makeHTTPHandler(s.handleUserinfo))
+	}
+	if s.opts.users != nil {
+		mux.Handle("POST /login", makeHTTPHandler(s.handleLogin))
+		mux.Handle("POST /login/mfa", makeHTTPHandler(s.handleLoginMFA))
+		mux.Handle("POST /mfa/totp/enroll", makeHTTPHandler(s.handleEnrollTOTP))
+		mux.Handle("POST /mfa/totp/confirm", makeHTTPHandler(s.handleConfirmTOTP))
+	}
+	if s.opts.users != nil && s.opts.webauthn != nil {
+		mux.Handle("POST /webauthn/register/begin", makeHTTPHandler(s.handleBeginRegistration))
+		mux.Handle("POST /webauthn/register/finish", makeHTTPHandler(s.handleFinishRegistration))
+		mux.Handle("POST /webauthn/login/begin", makeHTTPHandler(s.handleBeginLogin))
+		mux.Handle("POST /webauthn/login/finish", makeHTTPHandler(s.handleFinishLogin))
+	}
+	if s.opts.apiKeys != nil {
+		mux.Handle("POST /apikey/token", makeHTTPHandler(s.handleAPIKeyToken))
+	}
+	// Tokens of any subject and claims are issued to admins only,
+	// users get tokens by logging in.
+	if s.opts.adminToken != "" {
+		mux.Handle("POST /token", makeHTTPHandler(s.adminOnly(s.handleTokenReceive)))
+	}
+	if s.opts.adminToken != "" && s.opts.refresher != nil {
+		mux.Handle("POST /token/pair", makeHTTPHandler(s.adminOnly(s.handleTokenPair)))
+	}
+	if s.opts.adminToken != "" && s.opts.revoker != nil {
+		mux.Handle("POST /admin/revoke", makeHTTPHandler(s.adminOnly(s.handleRevoke)))
+	}
+	if s.opts.adminToken != "" && s.opts.users != nil {
+		mux.Handle("POST /admin/users", makeHTTPHandler(s.adminOnly(s.handleCreateUser)))
+		mux.Handle("GET /admin/users", makeHTTPHandler(s.adminOnly(s.handleListUsers)))
+		mux.Handle("GET /admin/users/{id}", makeHTTPHandler(s.adminOnly(s.handleGetUser)))
+		mux.Handle("PATCH /admin/users/{id}", makeHTTPHandler(s.adminOnly(s.handleUpdateUser)))
+		mux.Handle("DELETE /admin/users/{id}", makeHTTPHandler(s.adminOnly(s.handleDeleteUser)))
+	}
+	if s.opts.adminToken != "" && s.opts.apiKeys != nil {
+		mux.Handle("POST /admin/apikeys", makeHTTPHandler(s.adminOnly(s.handleCreateAPIKey)))
+		mux.Handle("GET /admin/apikeys", makeHTTPHandler(s.adminOnly(s.handleListAPIKeys)))
+		mux.Handle("DELETE /admin/apikeys/{id}", makeHTTPHandler(s.adminOnly(s.handleRevokeAPIKey)))
+	}
+
+	return mux
+}
 
+// Attaches the request id of the caller, or a new one, to the
+// context of each request, sends it back and returns http.Handler.
+func makeHTTPHandler(fn HTTPHandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r.Header.Get(types.RequestIDHeader))
+		w.Header().Set(types.RequestIDHeader, id)
+		ctx := types.WithRequestID(r.Context(), id)
 		if err := fn(ctx, w, r); err != nil {
 			_ = writeJSON(w, http.StatusBadRequest, HTTPErrResponse{Error: err.Error()})
 		}
@@ -103,6 +206,21 @@ func (s *HTTPServer) handleTokenValidation(ctx context.Context, w http.ResponseW
 	return writeJSON(w, http.StatusOK, types.TokenValidationResponse{Valid: true})
 }
 
+// Handles token introspection.
+func (s *HTTPServer) handleTokenIntrospection(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return errors.New("token not provided")
+	}
+
+	in, err := s.svc.Introspect(ctx, []byte(token))
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, in)
+}
+
 // Handles token receive.
 func (s *HTTPServer) handleTokenReceive(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	var b Body
@@ -112,16 +230,86 @@ func (s *HTTPServer) handleTokenReceive(ctx context.Context, w http.ResponseWrit
 	}
 	r.Body.Close()
 
-	if b.Payload == "" {
+	if b.Payload == "" && len(b.Claims) == 0 {
+		return errors.New("incorrect payload")
+	}
+
+	token, err := s.svc.Token(ctx, []byte(b.Payload), tokenOptions(b.TTL, b.Audience, b.Subject, b.Claims)...)
+	if err != nil {
+		return err
+	}
+
+	resp := types.TokenResponse{Token: string(token)}
+	if b.OpenID != nil {
+		idToken, err := issueIDToken(ctx, s.svc, token, b.Subject, b.OpenID.ClientID, b.OpenID.Nonce, b.OpenID.AuthTime)
+		if err != nil {
+			return err
+		}
+		resp.IDToken = string(idToken)
+	}
+
+	return writeJSON(w, http.StatusCreated, resp)
+}
+
+// Handles receiving of an access and refresh token pair.
+func (s *HTTPServer) handleTokenPair(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var b Body
+	err := json.NewDecoder(r.Body).Decode(&b)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if b.Payload == "" && len(b.Claims) == 0 {
 		return errors.New("incorrect payload")
 	}
 
-	token, err := s.svc.Token(ctx, []byte(b.Payload))
+	pair, err := s.opts.refresher.TokenPair(ctx, []byte(b.Payload), tokenOptions(b.TTL, b.Audience, b.Subject, b.Claims)...)
 	if err != nil {
 		return err
 	}
 
-	return writeJSON(w, http.StatusCreated, types.TokenResponse{Token: string(token)})
+	return writeJSON(w, http.StatusCreated, pair)
+}
+
+// Handles exchange of a refresh token for a new pair.
+func (s *HTTPServer) handleRefresh(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var b RefreshBody
+	err := json.NewDecoder(r.Body).Decode(&b)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if b.RefreshToken == "" {
+		return errors.New("refresh token not provided")
+	}
+
+	pair, err := s.opts.refresher.Refresh(ctx, []byte(b.RefreshToken))
+	if err != nil {
+		return writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: err.Error()})
+	}
+
+	return writeJSON(w, http.StatusOK, pair)
+}
+
+// Converts per-request overrides of a token request to token options.
+func tokenOptions(ttlSeconds int64, aud []string, sub string, claims map[string]any) []types.TokenOption {
+	var opts []types.TokenOption
+	if ttlSeconds > 0 {
+		opts = append(opts, types.WithTTL(time.Duration(ttlSeconds)*time.Second))
+	}
+	if len(aud) > 0 {
+		opts = append(opts, types.WithAudience(aud...))
+	}
+	if sub != "" {
+		opts = append(opts, types.WithSubject(sub))
+	}
+	if len(claims) > 0 {
+		opts = append(opts, types.WithClaims(claims))
+	}
+
+	return opts
 }
 
 // Helper func for responding with JSON.
@@ -129,3 +317,14 @@ func writeJSON(w http.ResponseWriter, code int, body any) error {
 	w.WriteHeader(code)
 	return json.NewEncoder(w).Encode(body)
 }
+
+// Cuts the token of an Authorization header value,
+// the Bearer scheme is matched case-insensitively.
+func bearerToken(v string) (string, bool) {
+	scheme, token, ok := strings.Cut(v, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	return token, true
+}
@@ -3,11 +3,19 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/danblok/auth/internal/refresh"
 	"github.com/danblok/auth/internal/service"
 	"github.com/danblok/auth/pkg/types"
 )
@@ -37,6 +45,14 @@ func TestHandleTokenReceive(t *testing.T) {
 			payload:  map[string]any{"payload": true},
 			wantCode: http.StatusBadRequest,
 		},
+		"with claims only": {
+			payload:  Body{Claims: map[string]any{"role": "admin", "tenant": map[string]any{"id": 5}}},
+			wantCode: http.StatusCreated,
+		},
+		"with reserved claim": {
+			payload:  Body{Payload: "some payload", Claims: map[string]any{"exp": 0}},
+			wantCode: http.StatusBadRequest,
+		},
 	}
 
 	svc := service.NewJWTService([]byte("secret-key"))
@@ -58,6 +74,80 @@ func TestHandleTokenReceive(t *testing.T) {
 	}
 }
 
+func TestTokenRequiresAdmin(t *testing.T) {
+	svc := service.NewJWTService([]byte("secret-key"))
+	refresher := refresh.NewRefreshService(svc, refresh.NewMemoryStore(), 0, 0)
+
+	tests := map[string]struct {
+		opts     []Option
+		token    string
+		wantCode int
+	}{
+		"admin": {
+			opts:     []Option{WithAdmin("admin-token"), WithRefresher(refresher)},
+			token:    "admin-token",
+			wantCode: http.StatusCreated,
+		},
+		"no admin token": {
+			opts:     []Option{WithAdmin("admin-token"), WithRefresher(refresher)},
+			wantCode: http.StatusUnauthorized,
+		},
+		"wrong admin token": {
+			opts:     []Option{WithAdmin("admin-token"), WithRefresher(refresher)},
+			token:    "user-token",
+			wantCode: http.StatusUnauthorized,
+		},
+		"admin API disabled": {
+			opts:     []Option{WithRefresher(refresher)},
+			token:    "admin-token",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := NewHTTPServer(svc, "localhost:3000", tt.opts...).routes()
+			for _, path := range []string{"/token", "/token/pair"} {
+				r := httptest.NewRequest("POST", path, strings.NewReader(`{"payload": "some payload", "subject": "user-1"}`))
+				if tt.token != "" {
+					r.Header.Set("Authorization", "Bearer "+tt.token)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.wantCode {
+					t.Errorf("%s: status code is not the same: want=%d, got=%d", path, tt.wantCode, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleTokenReceiveOpenID(t *testing.T) {
+	svc := service.NewJWTService([]byte("secret-key"))
+	h := NewHTTPServer(svc, "localhost:3000", WithAdmin("admin-token")).routes()
+
+	r := httptest.NewRequest("POST", "/token", strings.NewReader(`{"payload": "some payload", "subject": "user-1", "openid": {"client_id": "web", "nonce": "n-1", "auth_time": 1700000000}}`))
+	r.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status code is not the same: want=%d, got=%d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	var resp types.TokenResponse
+	_ = json.NewDecoder(w.Body).Decode(&resp)
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(resp.IDToken, claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "user-1" || claims["azp"] != "web" || claims["nonce"] != "n-1" || claims["auth_time"] != float64(1700000000) || claims["at_hash"] == nil {
+		t.Errorf("id token should be issued alongside the token: %v", claims)
+	}
+	if in, _ := svc.Introspect(context.Background(), []byte(resp.IDToken)); in.Valid {
+		t.Error("id token shouldn't be accepted as an access token")
+	}
+}
+
 func TestHandleTokenValidate(t *testing.T) {
 	svc := service.NewJWTService([]byte("secret-key"))
 	srv := NewHTTPServer(svc, "localhost:3000")
@@ -108,3 +198,45 @@ func TestHandleTokenValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleJWKS(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	key, err := service.ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := service.NewJWTServiceWithKey(key)
+	srv := NewHTTPServer(svc, "localhost:3000", WithKeys(service.NewKeySet(key, service.NewHMACKey([]byte("secret")))))
+	h := makeHTTPHandler(srv.handleJWKS)
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusOK, resp.StatusCode)
+	}
+
+	var got types.JWKSet
+	_ = json.NewDecoder(resp.Body).Decode(&got)
+	if len(got.Keys) != 1 {
+		t.Fatalf("only the public key should be published: got=%d keys", len(got.Keys))
+	}
+	if jwk := got.Keys[0]; jwk.KeyID != key.ID || jwk.Algorithm != "EdDSA" || jwk.Use != "sig" || jwk.KeyType != "OKP" {
+		t.Errorf("unexpected key: %+v", jwk)
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" || resp.Header.Get("Cache-Control") == "" {
+		t.Error("cache headers should be set")
+	}
+
+	r := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status code is not the same: want=%d, got=%d", http.StatusNotModified, w.Code)
+	}
+}
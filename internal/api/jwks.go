@@ -0,0 +1,41 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// How long clients may cache the key set.
+const jwksMaxAge = "public, max-age=300"
+
+// Handles publication of verification keys as a JWK set.
+func (s *HTTPServer) handleJWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	set, err := s.opts.keys.Keys(ctx)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(set); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("Cache-Control", jwksMaxAge)
+	w.Header().Set("ETag", etag)
+	if strings.Contains(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body.Bytes())
+	return err
+}
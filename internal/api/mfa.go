@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/pkg/types"
+)
+
+const (
+	// Grant type of MFA challenges kept in the grant store.
+	grantMFAChallenge = "mfa_challenge"
+	mfaChallengeTTL   = 5 * time.Minute
+	// Failed codes after which the challenge is dropped.
+	maxMFAAttempts = 5
+)
+
+var errInvalidChallenge = errors.New("mfa token not valid")
+
+// MFABody represents the body of the second login step.
+type MFABody struct {
+	MFAToken string   `json:"mfa_token"`
+	Code     string   `json:"code"`
+	TTL      int64    `json:"ttl,omitempty"`
+	Audience []string `json:"audience,omitempty"`
+}
+
+// CodeBody represents the body of a TOTP confirmation.
+type CodeBody struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodesResponse lists recovery codes, shown only once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Creates the challenge of the user who passed the password step.
+func newMFAChallenge(ctx context.Context, grants oauth.GrantStore, sub string) (string, error) {
+	token, id, err := oauth.NewCode()
+	if err != nil {
+		return "", err
+	}
+
+	err = grants.Create(ctx, &oauth.Grant{
+		ID:        id,
+		Type:      grantMFAChallenge,
+		Subject:   sub,
+		AuthTime:  time.Now(),
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Handles the second login step.
+func (s *HTTPServer) handleLoginMFA(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var b MFABody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	token, err := loginMFA(ctx, s.svc, s.opts.users, s.opts.grants, b)
+	if errors.Is(err, types.ErrInvalidCredentials) || errors.Is(err, errInvalidChallenge) {
+		return writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: err.Error()})
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, types.LoginResponse{Token: string(token)})
+}
+
+// Checks the code of the challenged user and issues a token,
+// shared by both transports. The challenge is redeemed once.
+func loginMFA(ctx context.Context, svc types.TokenService, users types.UserManager, grants oauth.GrantStore, b MFABody) ([]byte, error) {
+	if b.MFAToken == "" || b.Code == "" {
+		return nil, errors.New("mfa_token and code required")
+	}
+
+	g, err := grants.Grant(ctx, oauth.HashSecret(b.MFAToken))
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, errInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if g.Type != grantMFAChallenge || g.Expired(time.Now()) {
+		return nil, errInvalidChallenge
+	}
+
+	if err := users.VerifyTOTP(ctx, g.Subject, b.Code); err != nil {
+		if !errors.Is(err, types.ErrInvalidCredentials) {
+			return nil, err
+		}
+		if err := grants.Fail(ctx, g.ID, maxMFAAttempts); err != nil && !errors.Is(err, types.ErrNotFound) {
+			return nil, err
+		}
+		return nil, types.ErrInvalidCredentials
+	}
+
+	if _, err := grants.Take(ctx, g.ID); errors.Is(err, types.ErrNotFound) {
+		return nil, errInvalidChallenge
+	} else if err != nil {
+		return nil, err
+	}
+
+	return issueUserToken(ctx, svc, g.Subject, b.TTL, b.Audience, "pwd", "otp")
+}
+
+// Handles enrollment of an authenticator of the bearer token user.
+func (s *HTTPServer) handleEnrollTOTP(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	sub, ok, err := s.authenticateUser(ctx, w, r)
+	if !ok {
+		return err
+	}
+
+	issuer := s.issuer(r)
+	if u, err := url.Parse(issuer); err == nil && u.Host != "" {
+		issuer = u.Host
+	}
+
+	enrollment, err := s.opts.users.EnrollTOTP(ctx, sub, issuer)
+	if err != nil {
+		return writeUserError(w, err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, enrollment)
+}
+
+// Handles confirmation of the enrolled authenticator.
+func (s *HTTPServer) handleConfirmTOTP(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	sub, ok, err := s.authenticateUser(ctx, w, r)
+	if !ok {
+		return err
+	}
+
+	var b CodeBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	codes, err := s.opts.users.ConfirmTOTP(ctx, sub, b.Code)
+	if errors.Is(err, types.ErrInvalidCredentials) {
+		return writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: "code not valid"})
+	}
+	if err != nil {
+		return writeUserError(w, err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Authenticates the user by a bearer token issued by a password or passkey
+// login. Once the user has a second factor, only tokens that passed it or
+// a passkey are accepted, so a password alone can't replace it. It
+// responds itself unless ok.
+func (s *HTTPServer) authenticateUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (sub string, ok bool, err error) {
+	token, found := bearerToken(r.Header.Get("Authorization"))
+	if !found || token == "" {
+		return "", false, writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: "bearer token not provided"})
+	}
+
+	in, err := s.svc.Introspect(ctx, []byte(token))
+	if err != nil {
+		return "", false, err
+	}
+	sub, _ = in.Claims["sub"].(string)
+	amr := claimStrings(in.Claims["amr"])
+	if !in.Valid || sub == "" || !slices.Contains(amr, "pwd") && !slices.Contains(amr, "hwk") {
+		return "", false, writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: "token not valid"})
+	}
+
+	u, err := s.opts.users.User(ctx, sub)
+	if err != nil {
+		return "", false, writeUserError(w, err)
+	}
+	if u.MFA() && !slices.Contains(amr, "otp") && !slices.Contains(amr, "hwk") {
+		return "", false, writeJSON(w, http.StatusForbidden, HTTPErrResponse{Error: "second factor required"})
+	}
+
+	return sub, true, nil
+}
+
+// Converts a JSON array claim, or a single string, to strings.
+func claimStrings(v any) []string {
+	if s, ok := v.(string); ok {
+		return []string{s}
+	}
+	values, _ := v.([]any)
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
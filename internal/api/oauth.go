@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Handles a grant of the token endpoint.
+type grantFunc func(context.Context, *http.Request) (*types.TokenPair, error)
+
+// Grants supported by the token endpoint with the enabled features.
+// Discovery documents are generated from the same set.
+func (s *HTTPServer) grants() map[string]grantFunc {
+	grants := map[string]grantFunc{
+		oauth.GrantClientCredentials: s.clientCredentialsGrant,
+		oauth.GrantTokenExchange:     s.tokenExchangeGrant,
+	}
+	if s.opts.login != nil {
+		grants[oauth.GrantAuthorizationCode] = s.authorizationCodeGrant
+		grants[oauth.GrantDeviceCode] = s.deviceCodeGrant
+	}
+
+	return grants
+}
+
+// Handles the OAuth 2.0 token endpoint of RFC 6749.
+func (s *HTTPServer) handleOAuthToken(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return writeOAuthError(w, oauth.NewError(oauth.ErrInvalidRequest, err.Error()))
+	}
+
+	var (
+		resp *types.TokenPair
+		err  error
+	)
+	grant := r.PostForm.Get("grant_type")
+	if handle, ok := s.grants()[grant]; ok {
+		resp, err = handle(ctx, r)
+	} else if grant == "" {
+		err = oauth.NewError(oauth.ErrInvalidRequest, "grant_type not provided")
+	} else {
+		err = oauth.NewError(oauth.ErrUnsupportedGrantType, grant)
+	}
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, http.StatusOK, resp)
+}
+
+// Handles RFC 7662 token introspection for authenticated clients.
+func (s *HTTPServer) handleOAuthIntrospect(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return writeOAuthError(w, oauth.NewError(oauth.ErrInvalidRequest, err.Error()))
+	}
+	if _, err := s.authenticateClient(ctx, r); err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		return writeOAuthError(w, oauth.NewError(oauth.ErrInvalidRequest, "token not provided"))
+	}
+
+	in, err := s.svc.Introspect(ctx, []byte(token))
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, http.StatusOK, oauth.NewIntrospection(in))
+}
+
+// Handles RFC 7009 revocation of access and refresh tokens.
+func (s *HTTPServer) handleOAuthRevoke(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return writeOAuthError(w, oauth.NewError(oauth.ErrInvalidRequest, err.Error()))
+	}
+	client, err := s.authenticateClient(ctx, r)
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		return writeOAuthError(w, oauth.NewError(oauth.ErrInvalidRequest, "token not provided"))
+	}
+
+	// Refresh tokens are opaque, access tokens are JWTs.
+	hint := r.PostForm.Get("token_type_hint")
+	if hint == "refresh_token" || (hint == "" && !strings.Contains(token, ".")) {
+		err = s.revokeRefreshToken(ctx, client, token)
+	} else {
+		err = s.revokeAccessToken(ctx, client, token)
+	}
+	if err != nil {
+		return writeOAuthError(w, err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// Revokes the refresh token family if it is bound to the client.
+func (s *HTTPServer) revokeRefreshToken(ctx context.Context, client *oauth.Client, token string) error {
+	if s.opts.refresher == nil {
+		return oauth.NewError(oauth.ErrUnsupportedTokenType, "refresh tokens are not issued")
+	}
+
+	err := s.opts.refresher.Revoke(ctx, []byte(token), client.ID)
+	if errors.Is(err, types.ErrOtherClient) {
+		return oauth.NewError(oauth.ErrUnauthorizedClient, "token was issued to another client")
+	}
+
+	return err
+}
+
+// Revokes the access token if it was issued to the client, tokens
+// without a client aren't revocable by clients, RFC 7009 section 2.1.
+// Invalid tokens need no revocation, which is not an error.
+func (s *HTTPServer) revokeAccessToken(ctx context.Context, client *oauth.Client, token string) error {
+	if s.opts.revoker == nil {
+		return oauth.NewError(oauth.ErrUnsupportedTokenType, "access tokens can't be revoked")
+	}
+
+	in, err := s.svc.Introspect(ctx, []byte(token))
+	if err != nil {
+		return err
+	}
+	if !in.Valid {
+		return nil
+	}
+	if owner, _ := in.Claims["client_id"].(string); owner != client.ID {
+		return oauth.NewError(oauth.ErrUnauthorizedClient, "token was issued to another client")
+	}
+
+	return s.opts.revoker.RevokeToken(ctx, []byte(token))
+}
+
+// Issues a token to the client itself, RFC 6749 section 4.4.
+func (s *HTTPServer) clientCredentialsGrant(ctx context.Context, r *http.Request) (*types.TokenPair, error) {
+	client, err := s.authenticateClient(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrant(oauth.GrantClientCredentials) {
+		return nil, oauth.NewError(oauth.ErrUnauthorizedClient, "grant type is not allowed for the client")
+	}
+
+	scopes, err := client.ResolveScopes(r.PostForm.Get("scope"))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueOAuthToken(ctx, client, client.ID, scopes)
+}
+
+// Authenticates the client by client_secret_basic or client_secret_post.
+func (s *HTTPServer) authenticateClient(ctx context.Context, r *http.Request) (*oauth.Client, error) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		if r.PostForm.Has("client_secret") {
+			return nil, oauth.NewError(oauth.ErrInvalidRequest, "multiple client authentication methods")
+		}
+		// Credentials are form-encoded before being put in the header, RFC 6749 section 2.3.1.
+		var errID, errSecret error
+		id, errID = url.QueryUnescape(id)
+		secret, errSecret = url.QueryUnescape(secret)
+		if errID != nil || errSecret != nil {
+			return nil, oauth.NewError(oauth.ErrInvalidClient, "malformed client credentials")
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id == "" {
+		return nil, oauth.NewError(oauth.ErrInvalidClient, "client authentication required")
+	}
+
+	client, err := s.findClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Authenticate(secret) {
+		return nil, oauth.NewError(oauth.ErrInvalidClient, "client authentication failed")
+	}
+
+	return client, nil
+}
+
+// Authenticates a confidential client, or identifies a public
+// client by client_id, RFC 6749 section 3.2.1.
+func (s *HTTPServer) identifyClient(ctx context.Context, r *http.Request) (*oauth.Client, error) {
+	if _, _, basic := r.BasicAuth(); basic || r.PostForm.Has("client_secret") {
+		return s.authenticateClient(ctx, r)
+	}
+
+	id := r.PostForm.Get("client_id")
+	if id == "" {
+		return nil, oauth.NewError(oauth.ErrInvalidClient, "client authentication required")
+	}
+	client, err := s.findClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Public() {
+		return nil, oauth.NewError(oauth.ErrInvalidClient, "client authentication required")
+	}
+
+	return client, nil
+}
+
+// Finds the registered client, unknown clients are invalid_client.
+func (s *HTTPServer) findClient(ctx context.Context, id string) (*oauth.Client, error) {
+	client, err := s.opts.clients.Client(ctx, id)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, oauth.NewError(oauth.ErrInvalidClient, "client authentication failed")
+	}
+
+	return client, err
+}
+
+// Issues an access token through the TokenService,
+// the options override the defaults of the client.
+func (s *HTTPServer) issueOAuthToken(ctx context.Context, client *oauth.Client, sub string, scopes []string, extra ...types.TokenOption) (*types.TokenPair, error) {
+	claims := map[string]any{"client_id": client.ID}
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+
+	opts := []types.TokenOption{types.WithSubject(sub), types.WithServerClaims(claims)}
+	if len(client.Audience) > 0 {
+		opts = append(opts, types.WithAudience(client.Audience...))
+	}
+	opts = append(opts, extra...)
+
+	token, err := s.svc.Token(ctx, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := s.svc.Introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Roles may narrow the scope of the token.
+	scope, _ := in.Claims["scope"].(string)
+
+	return &types.TokenPair{
+		AccessToken: string(token),
+		TokenType:   "Bearer",
+		ExpiresIn:   in.ExpiresAt - in.IssuedAt,
+		Scope:       scope,
+	}, nil
+}
+
+// Responds with an RFC 6749 error, hiding internal errors.
+func writeOAuthError(w http.ResponseWriter, err error) error {
+	var oauthErr *oauth.Error
+	if !errors.As(err, &oauthErr) {
+		log.Printf("oauth: %v", err)
+		oauthErr = oauth.NewError(oauth.ErrServerError, "")
+	}
+
+	if oauthErr.Code == oauth.ErrInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, oauth.Status(oauthErr), oauthErr)
+}
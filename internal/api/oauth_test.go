@@ -0,0 +1,375 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/internal/refresh"
+	"github.com/danblok/auth/internal/revocation"
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestHandleOAuthTokenClientCredentials(t *testing.T) {
+	svc := service.NewJWTService([]byte("secret-key"))
+	clients := oauth.NewMemoryClientStore(oauth.Client{
+		ID:         "billing",
+		SecretHash: oauth.HashSecret("s3cr3t"),
+		Scopes:     []string{"invoices:read", "invoices:write"},
+	})
+	srv := NewHTTPServer(svc, "localhost:3000", WithOAuthClients(clients))
+
+	tests := map[string]struct {
+		form      url.Values
+		basic     []string
+		wantCode  int
+		wantError string
+		wantScope string
+	}{
+		"client_secret_basic": {
+			form:      url.Values{"grant_type": {"client_credentials"}, "scope": {"invoices:read"}},
+			basic:     []string{"billing", "s3cr3t"},
+			wantCode:  http.StatusOK,
+			wantScope: "invoices:read",
+		},
+		"client_secret_post": {
+			form:      url.Values{"grant_type": {"client_credentials"}, "client_id": {"billing"}, "client_secret": {"s3cr3t"}},
+			wantCode:  http.StatusOK,
+			wantScope: "invoices:read invoices:write",
+		},
+		"wrong secret": {
+			form:      url.Values{"grant_type": {"client_credentials"}},
+			basic:     []string{"billing", "wrong"},
+			wantCode:  http.StatusUnauthorized,
+			wantError: oauth.ErrInvalidClient,
+		},
+		"unknown client": {
+			form:      url.Values{"grant_type": {"client_credentials"}, "client_id": {"other"}, "client_secret": {"s3cr3t"}},
+			wantCode:  http.StatusUnauthorized,
+			wantError: oauth.ErrInvalidClient,
+		},
+		"scope not allowed": {
+			form:      url.Values{"grant_type": {"client_credentials"}, "scope": {"admin"}},
+			basic:     []string{"billing", "s3cr3t"},
+			wantCode:  http.StatusBadRequest,
+			wantError: oauth.ErrInvalidScope,
+		},
+		"unsupported grant": {
+			form:      url.Values{"grant_type": {"password"}},
+			basic:     []string{"billing", "s3cr3t"},
+			wantCode:  http.StatusBadRequest,
+			wantError: oauth.ErrUnsupportedGrantType,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/oauth2/token", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.basic != nil {
+				r.SetBasicAuth(tt.basic[0], tt.basic[1])
+			}
+			w := httptest.NewRecorder()
+			h := makeHTTPHandler(srv.handleOAuthToken)
+			h(w, r)
+
+			resp := w.Result()
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status code is not the same: want=%d, got=%d", tt.wantCode, resp.StatusCode)
+			}
+
+			if tt.wantError != "" {
+				var got oauth.Error
+				_ = json.NewDecoder(resp.Body).Decode(&got)
+				if got.Code != tt.wantError {
+					t.Errorf("error is not the same: want=%s, got=%s", tt.wantError, got.Code)
+				}
+				return
+			}
+
+			var got types.TokenPair
+			_ = json.NewDecoder(resp.Body).Decode(&got)
+			if got.TokenType != "Bearer" || got.ExpiresIn <= 0 || got.Scope != tt.wantScope {
+				t.Errorf("unexpected token response: %+v", got)
+			}
+			in, _ := svc.Introspect(r.Context(), []byte(got.AccessToken))
+			if !in.Valid || in.Claims["sub"] != "billing" || in.Claims["scope"] != tt.wantScope {
+				t.Errorf("unexpected token claims: %+v", in)
+			}
+		})
+	}
+}
+
+func TestHandleOAuthIntrospectAndRevoke(t *testing.T) {
+	ctx := context.Background()
+	revoker := revocation.NewRevocationService(service.NewJWTService([]byte("secret-key")), revocation.NewMemoryStore(), time.Hour)
+	clients := oauth.NewMemoryClientStore(
+		oauth.Client{ID: "gateway", SecretHash: oauth.HashSecret("s3cr3t")},
+		oauth.Client{ID: "billing", SecretHash: oauth.HashSecret("s3cr3t"), Scopes: []string{"invoices:read"}},
+	)
+	refresher := refresh.NewRefreshService(revoker, refresh.NewMemoryStore(), 0, 0)
+	srv := NewHTTPServer(revoker, "localhost:3000", WithOAuthClients(clients), WithRevoker(revoker), WithRefresher(refresher))
+
+	post := func(h HTTPHandlerFunc, client string, form url.Values) *http.Response {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if client != "" {
+			r.SetBasicAuth(client, "s3cr3t")
+		}
+		w := httptest.NewRecorder()
+		makeHTTPHandler(h)(w, r)
+		return w.Result()
+	}
+	introspect := func(token string) oauth.Introspection {
+		resp := post(srv.handleOAuthIntrospect, "gateway", url.Values{"token": {token}})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusOK, resp.StatusCode)
+		}
+		var got oauth.Introspection
+		_ = json.NewDecoder(resp.Body).Decode(&got)
+		return got
+	}
+
+	resp := post(srv.handleOAuthToken, "billing", url.Values{"grant_type": {"client_credentials"}})
+	var pair types.TokenPair
+	_ = json.NewDecoder(resp.Body).Decode(&pair)
+
+	got := introspect(pair.AccessToken)
+	if !got.Active || got.ClientID != "billing" || got.Sub != "billing" || got.Scope != "invoices:read" || got.Exp == 0 {
+		t.Errorf("unexpected introspection: %+v", got)
+	}
+	if got := introspect("some-random-text"); got.Active || got.Sub != "" {
+		t.Errorf("invalid token should be inactive: %+v", got)
+	}
+
+	if resp := post(srv.handleOAuthIntrospect, "", url.Values{"token": {pair.AccessToken}}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated introspection should fail: got=%d", resp.StatusCode)
+	}
+
+	if resp := post(srv.handleOAuthRevoke, "gateway", url.Values{"token": {pair.AccessToken}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("other client shouldn't revoke the token: got=%d", resp.StatusCode)
+	}
+	userToken, _ := revoker.Token(ctx, nil, types.WithSubject("user-1"))
+	if resp := post(srv.handleOAuthRevoke, "billing", url.Values{"token": {string(userToken)}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("client shouldn't revoke a token issued without a client: got=%d", resp.StatusCode)
+	}
+	if err := revoker.Validate(ctx, userToken); err != nil {
+		t.Errorf("token issued without a client should stay valid: %v", err)
+	}
+	if resp := post(srv.handleOAuthRevoke, "billing", url.Values{"token": {pair.AccessToken}, "token_type_hint": {"access_token"}}); resp.StatusCode != http.StatusOK {
+		t.Errorf("status code is not the same: want=%d, got=%d", http.StatusOK, resp.StatusCode)
+	}
+	if got := introspect(pair.AccessToken); got.Active {
+		t.Errorf("revoked token should be inactive: %+v", got)
+	}
+	if err := revoker.Validate(ctx, []byte(pair.AccessToken)); err == nil {
+		t.Error("revoked token shouldn't be valid")
+	}
+
+	if resp := post(srv.handleOAuthRevoke, "billing", url.Values{"token": {"some-random-text"}, "token_type_hint": {"access_token"}}); resp.StatusCode != http.StatusOK {
+		t.Errorf("revoking an invalid token should succeed: got=%d", resp.StatusCode)
+	}
+
+	bound, _ := refresher.TokenPair(ctx, nil, types.WithSubject("billing"), types.WithServerClaims(map[string]any{"client_id": "billing"}))
+	if resp := post(srv.handleOAuthRevoke, "gateway", url.Values{"token": {bound.RefreshToken}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("other client shouldn't revoke the refresh token: got=%d", resp.StatusCode)
+	}
+	if resp := post(srv.handleOAuthRevoke, "billing", url.Values{"token": {bound.RefreshToken}}); resp.StatusCode != http.StatusOK {
+		t.Errorf("status code is not the same: want=%d, got=%d", http.StatusOK, resp.StatusCode)
+	}
+	if _, err := refresher.Refresh(ctx, []byte(bound.RefreshToken)); err == nil {
+		t.Error("revoked refresh token shouldn't be accepted")
+	}
+
+	session, _ := refresher.TokenPair(ctx, nil, types.WithSubject("user-1"))
+	if err := revoke(ctx, revoker, refresher, RevokeBody{Subject: "user-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := refresher.Refresh(ctx, []byte(session.RefreshToken)); err == nil {
+		t.Error("refresh token of a revoked subject shouldn't be accepted")
+	}
+}
+
+func TestHandleOAuthMetadata(t *testing.T) {
+	key, _ := service.GenerateKey("ES256")
+	ring := service.NewKeyring(key)
+	svc := service.NewJWTServiceWithKeyring(ring)
+	clients := oauth.NewMemoryClientStore()
+	login := oauth.LoginHandlerFunc(func(http.ResponseWriter, *http.Request, *oauth.AuthorizationRequest) (*oauth.Consent, error) {
+		return nil, nil
+	})
+
+	tests := map[string]struct {
+		opts         []Option
+		openid       bool
+		wantIssuer   string
+		wantToken    string
+		wantGrants   []string
+		wantJWKS     string
+		wantIDTokAlg []string
+		wantDevice   string
+		wantCache    string
+	}{
+		"bare server": {
+			wantIssuer: "http://auth.example.com",
+			wantCache:  "no-store",
+		},
+		"oauth enabled": {
+			opts:       []Option{WithOAuthClients(clients), WithKeys(ring), WithIssuer("https://issuer.example.com/")},
+			wantIssuer: "https://issuer.example.com",
+			wantToken:  "https://issuer.example.com/oauth2/token",
+			wantGrants: []string{"client_credentials", oauth.GrantTokenExchange},
+			wantJWKS:   "https://issuer.example.com/.well-known/jwks.json",
+			wantCache:  "public, max-age=3600",
+		},
+		"login enabled": {
+			opts:       []Option{WithOAuthClients(clients), WithLogin(login), WithIssuer("https://issuer.example.com")},
+			wantIssuer: "https://issuer.example.com",
+			wantToken:  "https://issuer.example.com/oauth2/token",
+			wantGrants: []string{oauth.GrantAuthorizationCode, "client_credentials", oauth.GrantDeviceCode, oauth.GrantTokenExchange},
+			wantDevice: "https://issuer.example.com/oauth2/device_authorization",
+			wantCache:  "public, max-age=3600",
+		},
+		"openid configuration": {
+			opts:         []Option{WithKeys(ring)},
+			openid:       true,
+			wantIssuer:   "http://auth.example.com",
+			wantJWKS:     "http://auth.example.com/.well-known/jwks.json",
+			wantIDTokAlg: []string{"ES256"},
+			wantCache:    "no-store",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := NewHTTPServer(svc, "localhost:3000", tt.opts...)
+			h := srv.handleOAuthMetadata
+			if tt.openid {
+				h = srv.handleOpenIDConfiguration
+			}
+
+			r := httptest.NewRequest("GET", "http://auth.example.com/.well-known/oauth-authorization-server", nil)
+			w := httptest.NewRecorder()
+			makeHTTPHandler(h)(w, r)
+
+			var got oauth.Metadata
+			_ = json.NewDecoder(w.Result().Body).Decode(&got)
+			if got.Issuer != tt.wantIssuer || got.TokenEndpoint != tt.wantToken || got.JWKSURI != tt.wantJWKS {
+				t.Errorf("unexpected endpoints: %+v", got)
+			}
+			if !slices.Equal(got.GrantTypesSupported, tt.wantGrants) {
+				t.Errorf("grant types are not the same: want=%v, got=%v", tt.wantGrants, got.GrantTypesSupported)
+			}
+			if !slices.Equal(got.IDTokenSigningAlgValuesSupported, tt.wantIDTokAlg) {
+				t.Errorf("algorithms are not the same: want=%v, got=%v", tt.wantIDTokAlg, got.IDTokenSigningAlgValuesSupported)
+			}
+			if got.DeviceAuthorizationEndpoint != tt.wantDevice || (len(got.CodeChallengeMethodsSupported) > 0) != (tt.wantDevice != "") {
+				t.Errorf("endpoints of login grants should be advertised only with login: %+v", got)
+			}
+			if cc := w.Header().Get("Cache-Control"); cc != tt.wantCache {
+				t.Errorf("cache controls are not the same: want=%q, got=%q", tt.wantCache, cc)
+			}
+		})
+	}
+}
+
+func TestHandleUserinfo(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	profiles := oauth.NewMemoryProfileStore(map[string]map[string]any{
+		"user-1": {"name": "Jane Doe", "email": "jane@example.com", "phone_number": "+1 555"},
+	})
+	srv := NewHTTPServer(svc, "localhost:3000", WithProfiles(profiles))
+
+	all, _ := svc.Token(ctx, nil, types.WithSubject("user-1"))
+	profile, _ := svc.Token(ctx, nil, types.WithSubject("user-1"), types.WithServerClaims(map[string]any{"scope": "openid profile email phone"}))
+	email, _ := svc.Token(ctx, nil, types.WithSubject("user-1"), types.WithServerClaims(map[string]any{"scope": "openid email"}))
+	unknown, _ := svc.Token(ctx, nil, types.WithSubject("user-2"))
+	idToken, _ := issueIDToken(ctx, svc, all, "user-1", "web", "", 0)
+
+	tests := map[string]struct {
+		token     string
+		wantCode  int
+		wantClaim []string
+	}{
+		"no scope": {
+			token:     string(all),
+			wantCode:  http.StatusOK,
+			wantClaim: []string{"sub"},
+		},
+		"all scopes": {
+			token:     string(profile),
+			wantCode:  http.StatusOK,
+			wantClaim: []string{"email", "name", "phone_number", "sub"},
+		},
+		"email scope": {
+			token:     string(email),
+			wantCode:  http.StatusOK,
+			wantClaim: []string{"email", "sub"},
+		},
+		"no token": {
+			wantCode: http.StatusUnauthorized,
+		},
+		"invalid token": {
+			token:    "some-random-text",
+			wantCode: http.StatusUnauthorized,
+		},
+		"id token": {
+			token:    string(idToken),
+			wantCode: http.StatusUnauthorized,
+		},
+		"unknown subject": {
+			token:    string(unknown),
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/userinfo", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			makeHTTPHandler(srv.handleUserinfo)(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				if w.Header().Get("WWW-Authenticate") == "" {
+					t.Error("WWW-Authenticate should be set")
+				}
+				return
+			}
+
+			var got map[string]any
+			_ = json.NewDecoder(w.Result().Body).Decode(&got)
+			var names []string
+			for k := range got {
+				names = append(names, k)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.wantClaim) {
+				t.Errorf("claims are not the same: want=%v, got=%v", tt.wantClaim, names)
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Issues the ID token of the subject for the client alongside the access token.
+func issueIDToken(ctx context.Context, svc types.TokenService, accessToken []byte, sub, clientID, nonce string, authTime int64) ([]byte, error) {
+	if sub == "" {
+		return nil, errors.New("id token requires a subject")
+	}
+
+	idt := types.IDTokenOptions{
+		ClientID:    clientID,
+		Nonce:       nonce,
+		AccessToken: string(accessToken),
+	}
+	if authTime > 0 {
+		idt.AuthTime = time.Unix(authTime, 0)
+	}
+
+	return svc.Token(ctx, nil, types.WithSubject(sub), types.AsIDToken(idt))
+}
+
+// Handles OpenID Connect userinfo requests with a bearer access token.
+func (s *HTTPServer) handleUserinfo(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	token, ok := bearerToken(r.Header.Get("Authorization"))
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo"`)
+		return writeJSON(w, http.StatusUnauthorized, oauth.NewError(oauth.ErrInvalidRequest, "bearer token not provided"))
+	}
+
+	in, err := s.svc.Introspect(ctx, []byte(token))
+	if err != nil {
+		return err
+	}
+	sub, _ := in.Claims["sub"].(string)
+	if !in.Valid || sub == "" {
+		return writeInvalidToken(w, "token not valid")
+	}
+
+	profile, err := s.opts.profiles.Profile(ctx, sub)
+	if errors.Is(err, types.ErrNotFound) {
+		return writeInvalidToken(w, "subject has no profile")
+	}
+	if err != nil {
+		return err
+	}
+
+	scope, _ := in.Claims["scope"].(string)
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, oauth.UserinfoClaims(sub, profile, strings.Fields(scope)))
+}
+
+// Responds with an RFC 6750 invalid_token error.
+func writeInvalidToken(w http.ResponseWriter, desc string) error {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="userinfo", error=%q, error_description=%q`, oauth.ErrInvalidToken, desc))
+	return writeJSON(w, http.StatusUnauthorized, oauth.NewError(oauth.ErrInvalidToken, desc))
+}
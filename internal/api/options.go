@@ -0,0 +1,196 @@
+package api
+
+import (
+	"time"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/internal/rbac"
+	"github.com/danblok/auth/internal/registry"
+	"github.com/danblok/auth/internal/webauthn"
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Option enables optional features
+// of the HTTP and GRPC servers.
+type Option func(*options)
+
+// Optional features shared by the servers.
+type options struct {
+	keys        types.KeyProvider
+	rotator     types.KeyRotator
+	overlap     time.Duration
+	revoker     types.Revoker
+	refresher   types.Refresher
+	clients     oauth.ClientStore
+	profiles    types.ProfileStore
+	login       oauth.LoginHandler
+	grants      oauth.GrantStore
+	users       types.UserManager
+	webauthn    *webauthn.RelyingParty
+	logins      *rateLimiter
+	deviceRate  *rateLimiter
+	apiKeys     types.APIKeyManager
+	authorizer  types.Authorizer
+	resolvers   []types.RoleResolver
+	authCookie  string
+	pathRules   rbac.PathRules
+	tokenReview *TokenReviewClaims
+	registry    *registry.Issuer
+	issuer      string
+	adminToken  string
+}
+
+// WithKeys publishes verification keys of the given provider
+// on /.well-known/jwks.json and via the Keys RPC.
+func WithKeys(kp types.KeyProvider) Option {
+	return func(o *options) {
+		o.keys = kp
+	}
+}
+
+// WithAdmin enables the admin API protected by the bearer token.
+func WithAdmin(token string) Option {
+	return func(o *options) {
+		o.adminToken = token
+	}
+}
+
+// WithKeyRotator allows rotating signing keys via the admin API.
+// Previous keys verify for the overlap unless a rotation sets one.
+func WithKeyRotator(r types.KeyRotator, overlap time.Duration) Option {
+	return func(o *options) {
+		o.rotator = r
+		o.overlap = overlap
+	}
+}
+
+// WithRevoker allows revoking tokens via the admin API.
+func WithRevoker(r types.Revoker) Option {
+	return func(o *options) {
+		o.revoker = r
+	}
+}
+
+// WithRefresher enables issuing token pairs and refreshing them.
+func WithRefresher(r types.Refresher) Option {
+	return func(o *options) {
+		o.refresher = r
+	}
+}
+
+// WithOAuthClients enables the OAuth 2.0 token endpoint for the registered clients.
+func WithOAuthClients(clients oauth.ClientStore) Option {
+	return func(o *options) {
+		o.clients = clients
+	}
+}
+
+// WithProfiles enables the OpenID Connect userinfo endpoint.
+func WithProfiles(profiles types.ProfileStore) Option {
+	return func(o *options) {
+		o.profiles = profiles
+	}
+}
+
+// WithLogin enables the authorization endpoint and the authorization_code
+// grant, the handler authenticates resource owners and obtains consent.
+func WithLogin(h oauth.LoginHandler) Option {
+	return func(o *options) {
+		o.login = h
+	}
+}
+
+// WithGrantStore keeps pending grants in the store, in memory by default.
+func WithGrantStore(store oauth.GrantStore) Option {
+	return func(o *options) {
+		o.grants = store
+	}
+}
+
+// WithRoleResolvers resolves roles of authenticated callers,
+// the first resolver that knows the subject is used.
+func WithRoleResolvers(resolvers ...types.RoleResolver) Option {
+	return func(o *options) {
+		o.resolvers = resolvers
+	}
+}
+
+// WithUsers enables password login of the users, and
+// their management via the admin API.
+func WithUsers(users types.UserManager) Option {
+	return func(o *options) {
+		o.users = users
+	}
+}
+
+// WithWebAuthn enables passkey registration and login of the users
+// for the relying party, it requires WithUsers.
+func WithWebAuthn(rp *webauthn.RelyingParty) Option {
+	return func(o *options) {
+		o.webauthn = rp
+	}
+}
+
+// WithAPIKeys enables exchanging API keys for tokens,
+// and their management via the admin API.
+func WithAPIKeys(keys types.APIKeyManager) Option {
+	return func(o *options) {
+		o.apiKeys = keys
+	}
+}
+
+// WithAuthorizer enables checking permissions granted by tokens.
+func WithAuthorizer(a types.Authorizer) Option {
+	return func(o *options) {
+		o.authorizer = a
+	}
+}
+
+// WithForwardAuth configures the forward-auth endpoint of edge proxies.
+// The cookie holds tokens of requests without a bearer token, the rules
+// require permissions of the forwarded paths.
+func WithForwardAuth(cookie string, rules rbac.PathRules) Option {
+	return func(o *options) {
+		o.authCookie = cookie
+		o.pathRules = rules
+	}
+}
+
+// WithTokenReview enables the Kubernetes TokenReview webhook
+// mapping claims of tokens to users as named.
+func WithTokenReview(claims TokenReviewClaims) Option {
+	return func(o *options) {
+		o.tokenReview = &claims
+	}
+}
+
+// WithRegistry enables the token endpoint of Docker registries
+// issuing tokens of the issuer.
+func WithRegistry(issuer *registry.Issuer) Option {
+	return func(o *options) {
+		o.registry = issuer
+	}
+}
+
+// WithIssuer sets the issuer URL published in discovery documents.
+// It is derived from requests if not set.
+func WithIssuer(iss string) Option {
+	return func(o *options) {
+		o.issuer = iss
+	}
+}
+
+// Applies options.
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.grants == nil {
+		o.grants = oauth.NewMemoryGrantStore()
+	}
+	o.logins = newRateLimiter(maxLoginCeremonies, ceremonyTTL)
+	o.deviceRate = newRateLimiter(maxDeviceVerifications, time.Minute)
+
+	return o
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// AuthorizeBody represents the body of a permission check.
+type AuthorizeBody struct {
+	Token      string `json:"token"`
+	Permission string `json:"permission"`
+}
+
+// Handles checks whether the token grants the permission.
+// Denied permissions are answered with 200 and a reason too.
+func (s *HTTPServer) handleAuthorizePermission(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var b AuthorizeBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if b.Token == "" {
+		return errors.New("token not provided")
+	}
+
+	d, err := s.opts.authorizer.Authorize(ctx, []byte(b.Token), b.Permission)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, d)
+}
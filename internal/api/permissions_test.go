@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danblok/auth/internal/rbac"
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestAuthorizePermission(t *testing.T) {
+	ctx := context.Background()
+	userSvc := newUserService(t)
+	u, _ := userSvc.CreateUser(ctx, "jane", "s3cr3t", nil)
+	svc := rbac.NewRBACService(service.NewJWTService([]byte("secret-key")), rbac.Roles{"billing": {"invoices:read", "invoices:write"}}, userSvc)
+	h := NewHTTPServer(svc, "localhost:3000", WithUsers(userSvc), WithAuthorizer(svc), WithAdmin("admin-token")).routes()
+
+	r := httptest.NewRequest("PATCH", "/admin/users/"+u.ID, strings.NewReader(`{"roles": ["billing"]}`))
+	r.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/login", strings.NewReader(`{"username": "jane", "password": "s3cr3t"}`)))
+	var login types.LoginResponse
+	_ = json.NewDecoder(w.Body).Decode(&login)
+	in, _ := svc.Introspect(ctx, []byte(login.Token))
+	if roles, _ := in.Claims["roles"].([]any); len(roles) != 1 || roles[0] != "billing" || in.Claims["scope"] != "invoices:read invoices:write" {
+		t.Fatalf("token should have the roles and their permissions: %+v", in.Claims)
+	}
+
+	tests := map[string]struct {
+		body        AuthorizeBody
+		wantCode    int
+		wantAllowed bool
+	}{
+		"granted permission": {
+			body:        AuthorizeBody{Token: login.Token, Permission: "invoices:write"},
+			wantCode:    http.StatusOK,
+			wantAllowed: true,
+		},
+		"missing permission": {
+			body:     AuthorizeBody{Token: login.Token, Permission: "users:write"},
+			wantCode: http.StatusOK,
+		},
+		"invalid token": {
+			body:     AuthorizeBody{Token: "invalid", Permission: "invoices:read"},
+			wantCode: http.StatusOK,
+		},
+		"no permission": {
+			body:     AuthorizeBody{Token: login.Token},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("POST", "/authorize", strings.NewReader(mustJSON(t, tt.body))))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var d types.Decision
+			_ = json.NewDecoder(w.Body).Decode(&d)
+			if d.Allowed != tt.wantAllowed || d.Reason == "" {
+				t.Errorf("unexpected decision: %+v", d)
+			}
+		})
+	}
+
+	// Authorization claims are set by the server only.
+	for _, claim := range []string{"scope", "roles", "client_id", "groups", "access", "amr"} {
+		r := httptest.NewRequest("POST", "/token", strings.NewReader(`{"subject": "jane", "claims": {"`+claim+`": "*"}}`))
+		r.Header.Set("Authorization", "Bearer admin-token")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status code is not the same: want=%d, got=%d", claim, http.StatusBadRequest, w.Code)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter caps the requests of each client in a fixed window.
+type rateLimiter struct {
+	mu      sync.Mutex
+	max     int
+	window  time.Duration
+	clients map[string]*rateWindow
+	purged  time.Time
+}
+
+// Requests of a client in its current window.
+type rateWindow struct {
+	start time.Time
+	n     int
+}
+
+func newRateLimiter(max int, window time.Duration) *rateLimiter {
+	return &rateLimiter{max: max, window: window, clients: make(map[string]*rateWindow)}
+}
+
+// Counts a request of the client at now, or returns false with the
+// time left in its window if the cap is reached. Windows that ended
+// are dropped once per window, so idle clients aren't kept.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.purged) >= l.window {
+		for c, w := range l.clients {
+			if now.Sub(w.start) >= l.window {
+				delete(l.clients, c)
+			}
+		}
+		l.purged = now
+	}
+
+	w, ok := l.clients[client]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.clients[client] = w
+	}
+	if w.n >= l.max {
+		return false, l.window - now.Sub(w.start)
+	}
+	w.n++
+
+	return true, 0
+}
+
+// Responds with 429 if the client of the request reached the cap.
+func (l *rateLimiter) limit(w http.ResponseWriter, r *http.Request) bool {
+	ok, retry := l.allow(clientIP(r), time.Now())
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+	}
+
+	return !ok
+}
+
+// Returns the IP address of the client of the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
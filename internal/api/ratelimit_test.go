@@ -0,0 +1,29 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	now := time.Now()
+
+	for range 2 {
+		if ok, _ := l.allow("10.0.0.1", now); !ok {
+			t.Fatal("requests under the cap should be allowed")
+		}
+	}
+	if ok, retry := l.allow("10.0.0.1", now.Add(10*time.Second)); ok || retry != 50*time.Second {
+		t.Errorf("request over the cap shouldn't be allowed: %v %v", ok, retry)
+	}
+	if ok, _ := l.allow("10.0.0.2", now); !ok {
+		t.Error("other clients shouldn't be limited")
+	}
+	if ok, _ := l.allow("10.0.0.1", now.Add(time.Minute)); !ok {
+		t.Error("request of the next window should be allowed")
+	}
+	if _, ok := l.clients["10.0.0.2"]; ok {
+		t.Error("ended windows should be dropped")
+	}
+}
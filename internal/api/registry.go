@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/danblok/auth/internal/apikeys"
+	"github.com/danblok/auth/internal/rbac"
+	"github.com/danblok/auth/internal/registry"
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Handles token requests of Docker registry clients, e.g.
+// GET /registry/token?service=registry.example.com&scope=repository:app:pull,push.
+// Callers authenticate by basic auth with a password or an API key, or
+// by a bearer token, anonymous callers get what public rules grant.
+func (s *HTTPServer) handleRegistryToken(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	if service := q.Get("service"); service != "" && service != s.opts.registry.Service() {
+		return fmt.Errorf("unknown service %q", service)
+	}
+
+	caller, err := s.registryCaller(ctx, r)
+	if errors.Is(err, types.ErrInvalidCredentials) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", s.opts.registry.Service()))
+		return writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: err.Error()})
+	}
+	if err != nil {
+		return err
+	}
+
+	// Clients repeat the scope parameter or separate scopes by spaces.
+	var scopes []string
+	for _, scope := range q["scope"] {
+		scopes = append(scopes, strings.Fields(scope)...)
+	}
+
+	token, err := s.opts.registry.Token(ctx, caller, scopes)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, token)
+}
+
+// Authenticates the caller of the registry token endpoint. Users with a
+// second factor can't pass it from registry clients, they use API keys.
+// Roles are resolved by the subject, never taken from the token, and
+// the scope of tokens and API keys limits what they grant.
+func (s *HTTPServer) registryCaller(ctx context.Context, r *http.Request) (registry.Caller, error) {
+	if token, ok := bearerToken(r.Header.Get("Authorization")); ok {
+		in, err := s.svc.Introspect(ctx, []byte(token))
+		if err != nil {
+			return registry.Caller{}, err
+		}
+		sub, _ := in.Claims["sub"].(string)
+		if !in.Valid || sub == "" {
+			return registry.Caller{}, types.ErrInvalidCredentials
+		}
+		c, err := s.registrySubject(ctx, sub)
+		if scope, ok := in.Claims["scope"].(string); ok {
+			c.Scopes = strings.Fields(scope)
+		}
+		return c, err
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return registry.Caller{}, nil
+	}
+
+	if strings.HasPrefix(password, apikeys.Prefix) && s.opts.apiKeys != nil {
+		k, err := s.opts.apiKeys.AuthenticateAPIKey(ctx, password)
+		if err != nil {
+			return registry.Caller{}, err
+		}
+		c, err := s.registrySubject(ctx, k.Subject)
+		if len(k.Scopes) > 0 {
+			c.Scopes = k.Scopes
+		}
+		return c, err
+	}
+
+	if s.opts.users == nil {
+		return registry.Caller{}, types.ErrInvalidCredentials
+	}
+	u, err := s.opts.users.Authenticate(ctx, username, password)
+	if err != nil {
+		return registry.Caller{}, err
+	}
+	if u.MFA() {
+		return registry.Caller{}, fmt.Errorf("%w: second factor required, use an api key", types.ErrInvalidCredentials)
+	}
+
+	return registry.Caller{Subject: u.ID, Roles: u.Roles}, nil
+}
+
+// Resolves the roles of the authenticated subject.
+func (s *HTTPServer) registrySubject(ctx context.Context, sub string) (registry.Caller, error) {
+	roles, err := rbac.ResolveRoles(ctx, sub, s.opts.resolvers...)
+	if err != nil {
+		return registry.Caller{}, err
+	}
+
+	return registry.Caller{Subject: sub, Roles: roles}, nil
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/danblok/auth/internal/apikeys"
+	"github.com/danblok/auth/internal/registry"
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestHandleRegistryToken(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	userSvc := newUserService(t)
+	jane, _ := userSvc.CreateUser(ctx, "jane", "s3cr3t", nil)
+	roles := []string{"developer"}
+	_, _ = userSvc.UpdateUser(ctx, jane.ID, types.UserUpdate{Roles: &roles})
+	keys := apikeys.NewAPIKeyService(apikeys.NewMemoryStore())
+	key, _, _ := keys.CreateAPIKey(ctx, types.NewAPIKey{Subject: "ci"})
+	bearer, _ := svc.Token(ctx, nil, types.WithSubject("ci"))
+	janeBearer, _ := svc.Token(ctx, nil, types.WithSubject(jane.ID))
+	// Scopes of keys and tokens limit what roles grant.
+	readKey, _, _ := keys.CreateAPIKey(ctx, types.NewAPIKey{Subject: jane.ID, Scopes: []string{"registry:pull"}})
+	narrowBearer, _ := svc.Token(ctx, nil, types.WithSubject(jane.ID), types.WithServerClaims(map[string]any{"scope": "orders:read"}))
+	// Roles of tokens aren't trusted, e.g. of roles removed since.
+	staleBearer, _ := svc.Token(ctx, nil, types.WithSubject("ci"), types.WithServerClaims(map[string]any{"roles": []string{"developer"}}))
+
+	signer, _ := service.GenerateKey("ES256")
+	registrySvc := service.NewJWTServiceWithKey(signer, service.WithAudience("registry.example.com"))
+	acl := registry.ACL{
+		{Repository: "public/*", Public: true, Actions: []string{"pull"}},
+		{Repository: "team/*", Roles: []string{"developer"}, Actions: []string{"pull", "push"}},
+		{Repository: "team/*", Subjects: []string{"ci"}, Actions: []string{"pull"}},
+	}
+	h := NewHTTPServer(svc, "localhost:3000",
+		WithUsers(userSvc),
+		WithAPIKeys(keys),
+		WithRoleResolvers(userSvc),
+		WithRegistry(registry.NewIssuer(registrySvc, "registry.example.com", acl)),
+	).routes()
+
+	tests := map[string]struct {
+		query      string
+		user       string
+		password   string
+		bearer     string
+		wantCode   int
+		wantAccess []registry.Access
+	}{
+		"password": {
+			query:      "service=registry.example.com&scope=repository:team/app:pull,push",
+			user:       "jane",
+			password:   "s3cr3t",
+			wantCode:   http.StatusOK,
+			wantAccess: []registry.Access{{Type: "repository", Name: "team/app", Actions: []string{"pull", "push"}}},
+		},
+		"api key": {
+			query:      "scope=repository:team/app:pull,push&scope=repository:public/base:pull",
+			user:       "ci",
+			password:   key,
+			wantCode:   http.StatusOK,
+			wantAccess: []registry.Access{{Type: "repository", Name: "team/app", Actions: []string{"pull"}}, {Type: "repository", Name: "public/base", Actions: []string{"pull"}}},
+		},
+		"bearer token": {
+			query:      "scope=repository:team/app:push",
+			bearer:     string(bearer),
+			wantCode:   http.StatusOK,
+			wantAccess: []registry.Access{},
+		},
+		"bearer token of user": {
+			query:      "scope=repository:team/app:pull,push",
+			bearer:     string(janeBearer),
+			wantCode:   http.StatusOK,
+			wantAccess: []registry.Access{{Type: "repository", Name: "team/app", Actions: []string{"pull", "push"}}},
+		},
+		"read-only api key": {
+			query:      "scope=repository:team/app:pull,push",
+			user:       "jane",
+			password:   readKey,
+			wantCode:   http.StatusOK,
+			wantAccess: []registry.Access{{Type: "repository", Name: "team/app", Actions: []string{"pull"}}},
+		},
+		"narrowed bearer token": {
+			query:      "scope=repository:team/app:pull,push",
+			bearer:     string(narrowBearer),
+			wantCode:   http.StatusOK,
+			wantAccess: []registry.Access{},
+		},
+		"roles of bearer token": {
+			query:      "scope=repository:team/app:push",
+			bearer:     string(staleBearer),
+			wantCode:   http.StatusOK,
+			wantAccess: []registry.Access{},
+		},
+		"anonymous": {
+			query:      "scope=repository:public/base:pull,push+repository:team/app:pull",
+			wantCode:   http.StatusOK,
+			wantAccess: []registry.Access{{Type: "repository", Name: "public/base", Actions: []string{"pull"}}},
+		},
+		"wrong password": {
+			query:    "scope=repository:team/app:pull",
+			user:     "jane",
+			password: "wrong",
+			wantCode: http.StatusUnauthorized,
+		},
+		"revoked api key": {
+			query:    "scope=repository:team/app:pull",
+			user:     "ci",
+			password: apikeys.Prefix + "unknown_secret",
+			wantCode: http.StatusUnauthorized,
+		},
+		"other service": {
+			query:    "service=other.example.com&scope=repository:team/app:pull",
+			wantCode: http.StatusBadRequest,
+		},
+		"malformed scope": {
+			query:    "scope=repository:team/app",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/registry/token?"+tt.query, nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d: %s", tt.wantCode, w.Code, w.Body)
+			}
+			if tt.wantCode == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("challenge should be sent")
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var resp registry.Token
+			_ = json.NewDecoder(w.Body).Decode(&resp)
+			in, err := registrySvc.Introspect(ctx, []byte(resp.Token))
+			if err != nil || !in.Valid {
+				t.Fatalf("token should be valid: %v %v", in, err)
+			}
+			var access []registry.Access
+			data, _ := json.Marshal(in.Claims["access"])
+			_ = json.Unmarshal(data, &access)
+			if !reflect.DeepEqual(access, tt.wantAccess) {
+				t.Errorf("access is not the same: want=%v, got=%v", tt.wantAccess, access)
+			}
+		})
+	}
+}
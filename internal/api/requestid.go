@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Longest request id taken from callers.
+const maxRequestIDLength = 128
+
+// GRPC metadata key of request ids.
+var requestIDKey = strings.ToLower(types.RequestIDHeader)
+
+// Returns the request id of the caller, or a new one if it has none.
+// Ids are logged, so only short ones of visible ASCII are taken.
+func requestID(id string) string {
+	if id == "" || len(id) > maxRequestIDLength || strings.IndexFunc(id, func(r rune) bool {
+		return r <= ' ' || r > '~'
+	}) >= 0 {
+		return uuid.NewString()
+	}
+
+	return id
+}
+
+// Attaches the request id of the metadata, or a new one,
+// to the context of calls and sends it back in the header.
+func requestIDInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(requestIDKey); len(v) > 0 {
+			id = v[0]
+		}
+	}
+	id = requestID(id)
+	if err := grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id)); err != nil {
+		return nil, err
+	}
+
+	return handler(types.WithRequestID(ctx, id), req)
+}
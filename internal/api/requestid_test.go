@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+	"github.com/danblok/auth/proto"
+)
+
+// TokenService recording the request id of the last call.
+type requestIDRecorder struct {
+	types.TokenService
+	id string
+}
+
+func (r *requestIDRecorder) Validate(ctx context.Context, token []byte) error {
+	r.id = types.RequestIDFrom(ctx)
+	return r.TokenService.Validate(ctx, token)
+}
+
+func TestHTTPRequestID(t *testing.T) {
+	svc := &requestIDRecorder{TokenService: service.NewJWTService([]byte("secret-key"))}
+	h := NewHTTPServer(svc, "localhost:3000").routes()
+
+	tests := map[string]struct {
+		header   string
+		wantSame bool
+	}{
+		"honored":   {header: "req-42", wantSame: true},
+		"generated": {},
+		"too long":  {header: strings.Repeat("a", maxRequestIDLength+1)},
+		"not ascii": {header: "reqé42"},
+		"spaces":    {header: "req 42"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/validate?token=abc", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Request-ID", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			got := w.Header().Get("X-Request-ID")
+			if got == "" || got != svc.id {
+				t.Fatalf("request id should be sent back and in the context: header=%q, context=%q", got, svc.id)
+			}
+			if (got == tt.header) != tt.wantSame {
+				t.Errorf("unexpected request id: %q", got)
+			}
+		})
+	}
+
+	ids := make(map[string]bool)
+	for range 3 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/validate?token=abc", nil))
+		ids[w.Header().Get("X-Request-ID")] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("each request should get its own id: %v", ids)
+	}
+}
+
+func TestGRPCRequestID(t *testing.T) {
+	svc := &requestIDRecorder{TokenService: service.NewJWTService([]byte("secret-key"))}
+	client := proto.NewTokenServiceClient(dialGRPCServer(t, NewGRPCServer(svc)))
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-42")
+	if _, err := client.Validate(ctx, &proto.ValidateRequest{Token: "abc"}, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("x-request-id"); len(got) != 1 || got[0] != "req-42" || svc.id != "req-42" {
+		t.Errorf("request id should be honored: header=%v, context=%q", got, svc.id)
+	}
+
+	if _, err := client.Validate(context.Background(), &proto.ValidateRequest{Token: "abc"}, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("x-request-id"); len(got) != 1 || got[0] == "req-42" || got[0] != svc.id {
+		t.Errorf("request id should be generated: header=%v, context=%q", got, svc.id)
+	}
+}
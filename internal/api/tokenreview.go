@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Kubernetes API version and kind of token reviews.
+const (
+	tokenReviewAPIVersion = "authentication.k8s.io/v1"
+	tokenReviewKind       = "TokenReview"
+)
+
+// Claims of users of token reviews, only the server sets them,
+// so tokens can't name other users or groups.
+var reviewableClaims = []string{"sub", "client_id", "roles", "groups"}
+
+// TokenReview is an authentication.k8s.io/v1 TokenReview object, sent
+// by the Kubernetes API server to webhook token authenticators.
+type TokenReview struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Spec       *TokenReviewSpec  `json:"spec,omitempty"`
+	Status     TokenReviewStatus `json:"status"`
+}
+
+// TokenReviewSpec holds the token to review.
+type TokenReviewSpec struct {
+	Token string `json:"token"`
+	// Audiences the token must be valid for,
+	// those of the API server if empty.
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// TokenReviewStatus is the result of a review.
+type TokenReviewStatus struct {
+	Authenticated bool                `json:"authenticated"`
+	User          TokenReviewUserInfo `json:"user"`
+	// Audiences of the spec the token is valid for.
+	Audiences []string `json:"audiences,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// TokenReviewUserInfo is the user of an authenticated token.
+type TokenReviewUserInfo struct {
+	Username string   `json:"username,omitempty"`
+	UID      string   `json:"uid,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// TokenReviewClaims names the claims of users of token reviews.
+type TokenReviewClaims struct {
+	// Username claim, sub if empty.
+	Username string
+	// UID claim, sub if empty.
+	UID string
+	// Groups claim, a string or an array of strings. Users have no groups if empty.
+	Groups string
+	// Prefixes of usernames and groups, e.g. "auth:", so they don't
+	// clash with those of Kubernetes like system:masters.
+	UsernamePrefix string
+	GroupsPrefix   string
+	// Audiences of the API server, required of tokens of reviews
+	// without audiences.
+	Audiences []string
+}
+
+// Validate checks that the claims are set by the server only
+// and that the audiences of the API server are given.
+func (c TokenReviewClaims) Validate() error {
+	for _, name := range []string{c.Username, c.UID, c.Groups} {
+		if name != "" && !slices.Contains(reviewableClaims, name) {
+			return fmt.Errorf("token review claim %q isn't set by the server, use one of %v", name, reviewableClaims)
+		}
+	}
+	if len(c.Audiences) == 0 {
+		return errors.New("token review audiences of the API server not provided")
+	}
+
+	return nil
+}
+
+// Handles token reviews of the Kubernetes API server. Tokens that
+// aren't valid are answered with an unauthenticated status.
+func (s *HTTPServer) handleTokenReview(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var review TokenReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	if review.APIVersion != tokenReviewAPIVersion || review.Kind != tokenReviewKind {
+		return fmt.Errorf("expected a %s %s", tokenReviewAPIVersion, tokenReviewKind)
+	}
+	if review.Spec == nil || review.Spec.Token == "" {
+		return errors.New("token not provided")
+	}
+
+	status, err := s.reviewToken(ctx, review.Spec)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, TokenReview{
+		APIVersion: tokenReviewAPIVersion,
+		Kind:       tokenReviewKind,
+		Status:     *status,
+	})
+}
+
+// Validates the token of the spec and maps its claims to the user.
+func (s *HTTPServer) reviewToken(ctx context.Context, spec *TokenReviewSpec) (*TokenReviewStatus, error) {
+	in, err := s.svc.Introspect(ctx, []byte(spec.Token))
+	if err != nil {
+		return nil, err
+	}
+	if !in.Valid {
+		return &TokenReviewStatus{Error: "token not valid: " + in.Reason}, nil
+	}
+
+	// Tokens of reviews without audiences are for the API server,
+	// whose audiences aren't reported back.
+	m := s.opts.tokenReview
+	wanted := spec.Audiences
+	if len(wanted) == 0 {
+		wanted = m.Audiences
+	}
+	var audiences []string
+	aud := claimStrings(in.Claims["aud"])
+	for _, a := range wanted {
+		if slices.Contains(aud, a) {
+			audiences = append(audiences, a)
+		}
+	}
+	if len(audiences) == 0 {
+		return &TokenReviewStatus{Error: "token audiences don't match"}, nil
+	}
+	if len(spec.Audiences) == 0 {
+		audiences = nil
+	}
+
+	user := TokenReviewUserInfo{
+		Username: claimString(in.Claims, m.Username),
+		UID:      claimString(in.Claims, m.UID),
+	}
+	if user.Username == "" {
+		return &TokenReviewStatus{Error: "token has no username claim"}, nil
+	}
+	user.Username = m.UsernamePrefix + user.Username
+	if m.Groups != "" {
+		for _, g := range claimStrings(in.Claims[m.Groups]) {
+			user.Groups = append(user.Groups, m.GroupsPrefix+g)
+		}
+	}
+
+	return &TokenReviewStatus{Authenticated: true, User: user, Audiences: audiences}, nil
+}
+
+// Returns the string claim, or sub if no claim is named.
+func claimString(claims map[string]any, name string) string {
+	if name == "" {
+		name = "sub"
+	}
+	v, _ := claims[name].(string)
+
+	return v
+}
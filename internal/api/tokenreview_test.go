@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestHandleTokenReview(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	claims := TokenReviewClaims{
+		Groups:         "roles",
+		UsernamePrefix: "auth:",
+		GroupsPrefix:   "auth:",
+		Audiences:      []string{"https://kubernetes.default.svc.cluster.local"},
+	}
+	h := NewHTTPServer(svc, "localhost:3000", WithTokenReview(claims)).routes()
+
+	jane := types.WithServerClaims(map[string]any{"roles": []string{"billing", "viewer"}})
+	email := types.WithClaims(map[string]any{"email": "jane@example.com"})
+	k8s := types.WithAudience("https://kubernetes.default.svc.cluster.local", "api")
+
+	// Requests and responses of the fixtures in testdata/tokenreview,
+	// $TOKEN in requests is replaced with the token of the case.
+	tests := map[string][]types.TokenOption{
+		"authenticated":      {types.WithSubject("user-1"), jane, email, k8s},
+		"no-audiences":       {types.WithSubject("user-1"), jane, email, k8s},
+		"other-audience":     {types.WithSubject("user-1"), jane, email, types.WithAudience("api")},
+		"other-api-audience": {types.WithSubject("user-1"), jane, email},
+		"malformed":          nil,
+		"no-username":        {k8s},
+	}
+
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			token := []byte("not-a-token")
+			if opts != nil {
+				token, _ = svc.Token(ctx, nil, opts...)
+			}
+			req := readFixture(t, name+".request.json")
+			req = bytes.ReplaceAll(req, []byte("$TOKEN"), token)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("POST", "/tokenreview", bytes.NewReader(req)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status code is not the same: want=%d, got=%d: %s", http.StatusOK, w.Code, w.Body)
+			}
+
+			var got, want any
+			_ = json.Unmarshal(w.Body.Bytes(), &got)
+			_ = json.Unmarshal(readFixture(t, name+".response.json"), &want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("reviews are not the same:\nwant=%v\ngot= %v", want, got)
+			}
+		})
+	}
+
+	invalid := map[string]string{
+		"other kind": `{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{}}`,
+		"no token":   `{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","spec":{}}`,
+	}
+	for name, body := range invalid {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/tokenreview", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status code is not the same: want=%d, got=%d", name, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestTokenReviewClaimsValidate(t *testing.T) {
+	audiences := []string{"https://kubernetes.default.svc.cluster.local"}
+
+	tests := map[string]struct {
+		claims  TokenReviewClaims
+		wantErr bool
+	}{
+		"server claims": {
+			claims: TokenReviewClaims{Username: "sub", UID: "sub", Groups: "roles", Audiences: audiences},
+		},
+		"defaults": {
+			claims: TokenReviewClaims{Audiences: audiences},
+		},
+		"custom username claim": {
+			claims:  TokenReviewClaims{Username: "email", Audiences: audiences},
+			wantErr: true,
+		},
+		"custom groups claim": {
+			claims:  TokenReviewClaims{Groups: "teams", Audiences: audiences},
+			wantErr: true,
+		},
+		"no audiences": {
+			claims:  TokenReviewClaims{Groups: "roles"},
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.claims.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("error is not the same: wantErr=%v, got=%v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "tokenreview", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
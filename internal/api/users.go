@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/pkg/types"
+)
+
+// LoginBody represents the body of a login request.
+type LoginBody struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	TTL      int64    `json:"ttl,omitempty"`
+	Audience []string `json:"audience,omitempty"`
+}
+
+// UserBody represents the body of a request to create a user.
+type UserBody struct {
+	Username string         `json:"username"`
+	Password string         `json:"password"`
+	Profile  map[string]any `json:"profile,omitempty"`
+}
+
+// Handles password login.
+func (s *HTTPServer) handleLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var b LoginBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	resp, err := login(ctx, s.svc, s.opts.users, s.opts.grants, b)
+	if errors.Is(err, types.ErrInvalidCredentials) {
+		return writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: err.Error()})
+	}
+	if err != nil {
+		return err
+	}
+	if resp.MFAToken != "" {
+		return writeJSON(w, http.StatusOK, resp)
+	}
+
+	return writeJSON(w, http.StatusCreated, resp)
+}
+
+// Checks the credentials and issues a token of the user, or an MFA
+// challenge if the user has a second factor, shared by both transports.
+func login(ctx context.Context, svc types.TokenService, users types.UserManager, grants oauth.GrantStore, b LoginBody) (*types.LoginResponse, error) {
+	if b.Username == "" || b.Password == "" {
+		return nil, errors.New("username and password required")
+	}
+
+	u, err := users.Authenticate(ctx, b.Username, b.Password)
+	if err != nil {
+		return nil, err
+	}
+	if u.MFA() {
+		challenge, err := newMFAChallenge(ctx, grants, u.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &types.LoginResponse{MFAToken: challenge}, nil
+	}
+
+	token, err := issueUserToken(ctx, svc, u.ID, b.TTL, b.Audience, "pwd")
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.LoginResponse{Token: string(token)}, nil
+}
+
+// Issues a token of the user with the authentication methods.
+func issueUserToken(ctx context.Context, svc types.TokenService, sub string, ttl int64, aud []string, amr ...string) ([]byte, error) {
+	opts := append(tokenOptions(ttl, aud, sub, nil),
+		types.WithServerClaims(map[string]any{"amr": amr}))
+
+	return svc.Token(ctx, nil, opts...)
+}
+
+// Handles user creation.
+func (s *HTTPServer) handleCreateUser(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var b UserBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	u, err := s.opts.users.CreateUser(ctx, b.Username, b.Password, b.Profile)
+	if err != nil {
+		return writeUserError(w, err)
+	}
+
+	return writeJSON(w, http.StatusCreated, u)
+}
+
+// Handles listing of users.
+func (s *HTTPServer) handleListUsers(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	users, err := s.opts.users.Users(ctx)
+	if err != nil {
+		return err
+	}
+	if users == nil {
+		users = []*types.User{}
+	}
+
+	return writeJSON(w, http.StatusOK, users)
+}
+
+// Handles reading a user.
+func (s *HTTPServer) handleGetUser(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	u, err := s.opts.users.User(ctx, r.PathValue("id"))
+	if err != nil {
+		return writeUserError(w, err)
+	}
+
+	return writeJSON(w, http.StatusOK, u)
+}
+
+// Handles changing password, profile, status or roles of a user.
+func (s *HTTPServer) handleUpdateUser(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var upd types.UserUpdate
+	if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	u, err := s.opts.users.UpdateUser(ctx, r.PathValue("id"), upd)
+	if err != nil {
+		return writeUserError(w, err)
+	}
+
+	return writeJSON(w, http.StatusOK, u)
+}
+
+// Handles user deletion.
+func (s *HTTPServer) handleDeleteUser(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := s.opts.users.DeleteUser(ctx, r.PathValue("id")); err != nil {
+		return writeUserError(w, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Responds with 404 and 409 for missing and duplicate users.
+func writeUserError(w http.ResponseWriter, err error) error {
+	switch {
+	case errors.Is(err, types.ErrNotFound):
+		return writeJSON(w, http.StatusNotFound, HTTPErrResponse{Error: "user not found"})
+	case errors.Is(err, types.ErrAlreadyExists):
+		return writeJSON(w, http.StatusConflict, HTTPErrResponse{Error: "username is taken"})
+	}
+
+	return err
+}
@@ -0,0 +1,404 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/internal/users"
+	"github.com/danblok/auth/internal/webauthn"
+	"github.com/danblok/auth/internal/webauthn/webauthntest"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func newUserService(t *testing.T) *users.UserService {
+	t.Helper()
+	svc, err := users.NewUserService(users.NewMemoryStore(), users.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func TestHandleLogin(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	userSvc := newUserService(t)
+	u, _ := userSvc.CreateUser(ctx, "jane", "s3cr3t", nil)
+	h := NewHTTPServer(svc, "localhost:3000", WithUsers(userSvc)).routes()
+
+	tests := map[string]struct {
+		body     string
+		wantCode int
+	}{
+		"valid credentials": {
+			body:     `{"username": "jane", "password": "s3cr3t"}`,
+			wantCode: http.StatusCreated,
+		},
+		"wrong password": {
+			body:     `{"username": "jane", "password": "wrong"}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		"unknown user": {
+			body:     `{"username": "john", "password": "s3cr3t"}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		"no password": {
+			body:     `{"username": "jane"}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("POST", "/login", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusCreated {
+				return
+			}
+
+			var resp types.TokenResponse
+			_ = json.NewDecoder(w.Body).Decode(&resp)
+			in, _ := svc.Introspect(ctx, []byte(resp.Token))
+			if !in.Valid || in.Claims["sub"] != u.ID {
+				t.Errorf("token should be issued to the user: %+v", in)
+			}
+		})
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	svc := service.NewJWTService([]byte("secret-key"))
+	h := NewHTTPServer(svc, "localhost:3000", WithUsers(newUserService(t)), WithAdmin("admin-token")).routes()
+
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("POST", "/admin/users", `{"username": "jane", "password": "s3cr3t"}`, "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+
+	w := do("POST", "/admin/users", `{"username": "jane", "password": "s3cr3t", "profile": {"name": "Jane"}}`, "admin-token")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusCreated, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "argon2id") {
+		t.Error("password hash shouldn't be returned")
+	}
+	var u types.User
+	_ = json.NewDecoder(w.Body).Decode(&u)
+
+	// Cases depend on each other, so they run in order.
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+	}{
+		{"duplicate username", "POST", "/admin/users", `{"username": "jane", "password": "other"}`, http.StatusConflict},
+		{"list", "GET", "/admin/users", "", http.StatusOK},
+		{"get", "GET", "/admin/users/" + u.ID, "", http.StatusOK},
+		{"get missing", "GET", "/admin/users/missing", "", http.StatusNotFound},
+		{"disable", "PATCH", "/admin/users/" + u.ID, `{"disabled": true}`, http.StatusOK},
+		{"delete", "DELETE", "/admin/users/" + u.ID, "", http.StatusNoContent},
+		{"delete missing", "DELETE", "/admin/users/missing", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.path, tt.body, "admin-token")
+			if w.Code != tt.wantCode {
+				t.Errorf("status code is not the same: want=%d, got=%d: %s", tt.wantCode, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	userSvc := newUserService(t)
+	u, _ := userSvc.CreateUser(ctx, "jane", "s3cr3t", nil)
+	h := NewHTTPServer(svc, "localhost:3000", WithUsers(userSvc)).routes()
+
+	do := func(path, body, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const credentials = `{"username": "jane", "password": "s3cr3t"}`
+
+	w := do("/login", credentials, "")
+	var first types.LoginResponse
+	decode(w, &first)
+
+	if w := do("/mfa/totp/enroll", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+	w = do("/mfa/totp/enroll", "", first.Token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusOK, w.Code)
+	}
+	var enrollment types.TOTPEnrollment
+	decode(w, &enrollment)
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/example.com:jane?") || len(enrollment.QRCode) == 0 {
+		t.Fatalf("unexpected enrollment: %s", enrollment.URI)
+	}
+
+	code, _ := users.TOTPCode(enrollment.Secret, time.Now())
+	w = do("/mfa/totp/confirm", mustJSON(t, CodeBody{Code: code}), first.Token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusOK, w.Code)
+	}
+	var recovery RecoveryCodesResponse
+	decode(w, &recovery)
+
+	// A password token can't replace the confirmed second factor.
+	if w := do("/mfa/totp/enroll", "", first.Token); w.Code != http.StatusForbidden {
+		t.Errorf("status code is not the same: want=%d, got=%d", http.StatusForbidden, w.Code)
+	}
+
+	challenge := func() string {
+		t.Helper()
+		w := do("/login", credentials, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusOK, w.Code)
+		}
+		var resp types.LoginResponse
+		decode(w, &resp)
+		if resp.Token != "" || resp.MFAToken == "" {
+			t.Fatalf("password step should return only a challenge: %+v", resp)
+		}
+		return resp.MFAToken
+	}
+
+	mfaToken := challenge()
+	tests := []struct {
+		name     string
+		body     MFABody
+		wantCode int
+	}{
+		{
+			name:     "wrong code",
+			body:     MFABody{MFAToken: mfaToken, Code: "000000"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "unknown challenge",
+			body:     MFABody{MFAToken: "unknown", Code: recovery.RecoveryCodes[0]},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "recovery code",
+			body:     MFABody{MFAToken: mfaToken, Code: recovery.RecoveryCodes[0]},
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "redeemed challenge",
+			body:     MFABody{MFAToken: mfaToken, Code: recovery.RecoveryCodes[1]},
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do("/login/mfa", mustJSON(t, tt.body), "")
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusCreated {
+				return
+			}
+
+			var resp types.LoginResponse
+			decode(w, &resp)
+			in, _ := svc.Introspect(ctx, []byte(resp.Token))
+			if !in.Valid || in.Claims["sub"] != u.ID || mustJSON(t, in.Claims["amr"]) != `["pwd","otp"]` {
+				t.Errorf("token should be issued to the user with both factors: %+v", in.Claims)
+			}
+		})
+	}
+
+	// Challenges are dropped after too many wrong codes.
+	mfaToken = challenge()
+	for range maxMFAAttempts {
+		do("/login/mfa", mustJSON(t, MFABody{MFAToken: mfaToken, Code: "000000"}), "")
+	}
+	if w := do("/login/mfa", mustJSON(t, MFABody{MFAToken: mfaToken, Code: recovery.RecoveryCodes[1]}), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("status code is not the same: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestPasskeys(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"))
+	userSvc := newUserService(t)
+	u, _ := userSvc.CreateUser(ctx, "jane", "s3cr3t", nil)
+	const origin = "https://login.example.com"
+	rp := &webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{origin}}
+	h := NewHTTPServer(svc, "localhost:3000", WithUsers(userSvc), WithWebAuthn(rp)).routes()
+
+	do := func(path, body, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var login types.LoginResponse
+	decode(do("/login", `{"username": "jane", "password": "s3cr3t"}`, ""), &login)
+
+	// Registration requires a user token.
+	if w := do("/webauthn/register/begin", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+	var creation webauthn.CredentialCreationOptions
+	decode(do("/webauthn/register/begin", "", login.Token), &creation)
+	if string(creation.PublicKey.User.ID) != u.ID || creation.PublicKey.RP.ID != "example.com" {
+		t.Fatalf("unexpected creation options: %+v", creation.PublicKey)
+	}
+
+	authenticator := webauthntest.NewAuthenticator()
+	reg, err := authenticator.Register(origin, creation.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := do("/webauthn/register/finish", mustJSON(t, reg), login.Token); w.Code != http.StatusCreated {
+		t.Fatalf("status code is not the same: want=%d, got=%d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+	// Challenges are answered once.
+	if w := do("/webauthn/register/finish", mustJSON(t, reg), login.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("status code is not the same: want=%d, got=%d", http.StatusUnauthorized, w.Code)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		origin   string
+		clone    bool
+		wantCode int
+	}{
+		{
+			name:     "passkey",
+			body:     `{}`,
+			origin:   origin,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "username",
+			body:     `{"username": "jane"}`,
+			origin:   origin,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "other origin",
+			body:     `{}`,
+			origin:   "https://evil.example.net",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "cloned authenticator",
+			body:     `{}`,
+			origin:   origin,
+			clone:    true,
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request webauthn.CredentialRequestOptions
+			decode(do("/webauthn/login/begin", tt.body, ""), &request)
+			if tt.body != `{}` && len(request.PublicKey.AllowCredentials) != 1 {
+				t.Fatalf("credentials of the user should be allowed: %+v", request.PublicKey)
+			}
+
+			if tt.clone {
+				// A clone signs with a counter the server has seen.
+				authenticator.Credentials()[0].SignCount = 0
+			}
+			assertion, err := authenticator.Login(tt.origin, request.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := do("/webauthn/login/finish", mustJSON(t, PasskeyAssertionBody{AssertionResponse: *assertion}), "")
+			if w.Code != tt.wantCode {
+				t.Fatalf("status code is not the same: want=%d, got=%d: %s", tt.wantCode, w.Code, w.Body)
+			}
+			if tt.wantCode != http.StatusCreated {
+				return
+			}
+
+			var resp types.LoginResponse
+			decode(w, &resp)
+			in, _ := svc.Introspect(ctx, []byte(resp.Token))
+			if !in.Valid || in.Claims["sub"] != u.ID || mustJSON(t, in.Claims["amr"]) != `["hwk","user"]` {
+				t.Errorf("token should be issued to the user with the passkey: %+v", in.Claims)
+			}
+		})
+	}
+	// Unknown users and users without credentials look like users with one.
+	_, _ = userSvc.CreateUser(ctx, "john", "s3cr3t", nil)
+	for _, username := range []string{"john", "nobody"} {
+		var first, second webauthn.CredentialRequestOptions
+		decode(do("/webauthn/login/begin", `{"username": "`+username+`"}`, ""), &first)
+		decode(do("/webauthn/login/begin", `{"username": "`+username+`"}`, ""), &second)
+		if len(first.PublicKey.AllowCredentials) != 1 || mustJSON(t, first.PublicKey.AllowCredentials) != mustJSON(t, second.PublicKey.AllowCredentials) {
+			t.Errorf("%s should get the same credential each time: %+v, %+v", username, first.PublicKey, second.PublicKey)
+		}
+	}
+
+	// Logins a client IP starts per ceremony TTL are capped.
+	srv := NewHTTPServer(svc, "localhost:3000", WithUsers(userSvc), WithWebAuthn(rp))
+	srv.opts.logins = newRateLimiter(1, ceremonyTTL)
+	h = srv.routes()
+	if w := do("/webauthn/login/begin", `{}`, ""); w.Code != http.StatusOK {
+		t.Fatalf("status code is not the same: want=%d, got=%d", http.StatusOK, w.Code)
+	}
+	w := do("/webauthn/login/begin", `{}`, "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status code is not the same: want=%d, got=%d", http.StatusTooManyRequests, w.Code)
+	}
+	r := httptest.NewRequest("POST", "/webauthn/login/begin", strings.NewReader(`{}`))
+	r.RemoteAddr = "198.51.100.7:1234"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("status code is not the same: want=%d, got=%d", http.StatusOK, w.Code)
+	}
+}
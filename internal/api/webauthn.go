@@ -0,0 +1,253 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/danblok/auth/internal/oauth"
+	"github.com/danblok/auth/internal/webauthn"
+	"github.com/danblok/auth/pkg/types"
+)
+
+const (
+	// Grant types of pending WebAuthn ceremonies kept in the grant store.
+	grantWebAuthnRegistration = "webauthn_registration"
+	grantWebAuthnLogin        = "webauthn_login"
+	ceremonyTTL               = 5 * time.Minute
+	// Logins a client IP starts per ceremonyTTL, each keeps a grant until it expires.
+	maxLoginCeremonies = 30
+)
+
+var errInvalidCeremony = errors.New("ceremony not valid")
+
+// PasskeyLoginBody represents the body of a passkey login request.
+type PasskeyLoginBody struct {
+	// Username limits the login to the credentials of the user,
+	// any passkey is accepted if empty.
+	Username string `json:"username,omitempty"`
+}
+
+// PasskeyAssertionBody represents the body of a passkey login
+// completion, the assertion with token options.
+type PasskeyAssertionBody struct {
+	webauthn.AssertionResponse
+	TTL      int64    `json:"ttl,omitempty"`
+	Audience []string `json:"audience,omitempty"`
+}
+
+// Handles the start of a registration of a passkey of the bearer token user.
+func (s *HTTPServer) handleBeginRegistration(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	sub, ok, err := s.authenticateUser(ctx, w, r)
+	if !ok {
+		return err
+	}
+
+	u, err := s.opts.users.User(ctx, sub)
+	if err != nil {
+		return writeUserError(w, err)
+	}
+	challenge, err := s.newCeremony(ctx, grantWebAuthnRegistration, u.ID)
+	if err != nil {
+		return err
+	}
+
+	exclude := make([][]byte, 0, len(u.Credentials))
+	for _, c := range u.Credentials {
+		exclude = append(exclude, c.ID)
+	}
+	name, _ := u.Profile["name"].(string)
+	if name == "" {
+		name = u.Username
+	}
+	user := webauthn.UserEntity{ID: []byte(u.ID), Name: u.Username, DisplayName: name}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, s.opts.webauthn.CreationOptions(challenge, user, exclude, ceremonyTTL))
+}
+
+// Handles the completion of a registration with the created credential.
+func (s *HTTPServer) handleFinishRegistration(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	sub, ok, err := s.authenticateUser(ctx, w, r)
+	if !ok {
+		return err
+	}
+
+	var resp webauthn.RegistrationResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	g, challenge, err := s.takeCeremony(ctx, grantWebAuthnRegistration, resp.Response.ClientDataJSON)
+	if err == nil && g.Subject != sub {
+		err = errInvalidCeremony
+	}
+	if err != nil {
+		return writeCeremonyError(w, err)
+	}
+
+	cred, err := s.opts.webauthn.VerifyRegistration(&resp, challenge)
+	if err != nil {
+		return writeCeremonyError(w, err)
+	}
+	if err := s.opts.users.AddCredential(ctx, sub, *cred); err != nil {
+		if errors.Is(err, types.ErrAlreadyExists) {
+			return writeJSON(w, http.StatusConflict, HTTPErrResponse{Error: "credential is registered"})
+		}
+		return writeUserError(w, err)
+	}
+
+	return writeJSON(w, http.StatusCreated, cred)
+}
+
+// Handles the start of a passkey login.
+func (s *HTTPServer) handleBeginLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var b PasskeyLoginBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	r.Body.Close()
+
+	if s.opts.logins.limit(w, r) {
+		return writeJSON(w, http.StatusTooManyRequests, HTTPErrResponse{Error: "too many logins"})
+	}
+
+	// Unknown users and users without credentials get a credential
+	// derived from the username, so the response doesn't reveal
+	// which usernames exist.
+	var (
+		sub   string
+		allow [][]byte
+	)
+	if b.Username != "" {
+		u, err := s.opts.users.UserByUsername(ctx, b.Username)
+		if err != nil && !errors.Is(err, types.ErrNotFound) {
+			return err
+		}
+		if u != nil {
+			sub = u.ID
+			for _, c := range u.Credentials {
+				allow = append(allow, c.ID)
+			}
+		}
+		if len(allow) == 0 {
+			allow = [][]byte{s.opts.webauthn.SyntheticCredentialID(b.Username)}
+		}
+	}
+
+	challenge, err := s.newCeremony(ctx, grantWebAuthnLogin, sub)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, s.opts.webauthn.RequestOptions(challenge, allow, ceremonyTTL))
+}
+
+// Handles the completion of a passkey login and issues a token.
+func (s *HTTPServer) handleFinishLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var b PasskeyAssertionBody
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	g, challenge, err := s.takeCeremony(ctx, grantWebAuthnLogin, b.Response.ClientDataJSON)
+	if err != nil {
+		return writeCeremonyError(w, err)
+	}
+
+	// Discoverable credentials name their user, others the username did.
+	sub := string(b.Response.UserHandle)
+	if sub == "" {
+		sub = g.Subject
+	}
+	if sub == "" || g.Subject != "" && g.Subject != sub {
+		return writeCeremonyError(w, types.ErrInvalidCredentials)
+	}
+
+	u, err := s.opts.users.User(ctx, sub)
+	if errors.Is(err, types.ErrNotFound) {
+		return writeCeremonyError(w, types.ErrInvalidCredentials)
+	}
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(u.Credentials, func(c types.WebAuthnCredential) bool { return bytes.Equal(c.ID, b.RawID) })
+	if i < 0 {
+		return writeCeremonyError(w, types.ErrInvalidCredentials)
+	}
+
+	signCount, err := s.opts.webauthn.VerifyAssertion(&b.AssertionResponse, challenge, &u.Credentials[i])
+	if err != nil {
+		return writeCeremonyError(w, err)
+	}
+	if err := s.opts.users.UseCredential(ctx, u.ID, b.RawID, signCount); err != nil {
+		return writeCeremonyError(w, err)
+	}
+
+	token, err := issueUserToken(ctx, s.svc, u.ID, b.TTL, b.Audience, "hwk", "user")
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, types.LoginResponse{Token: string(token)})
+}
+
+// Starts a ceremony of the user and returns its challenge, which is
+// kept in the grant store until the ceremony completes.
+func (s *HTTPServer) newCeremony(ctx context.Context, typ, sub string) (string, error) {
+	challenge, id, err := oauth.NewCode()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.opts.grants.Create(ctx, &oauth.Grant{
+		ID:        id,
+		Type:      typ,
+		Subject:   sub,
+		ExpiresAt: time.Now().Add(ceremonyTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// Takes the pending ceremony of the challenge in the client data and
+// returns it with the challenge, so each challenge is answered once.
+func (s *HTTPServer) takeCeremony(ctx context.Context, typ string, clientDataJSON []byte) (*oauth.Grant, string, error) {
+	cd, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, "", err
+	}
+
+	g, err := s.opts.grants.Take(ctx, oauth.HashSecret(cd.Challenge))
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, "", errInvalidCeremony
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if g.Type != typ || g.Expired(time.Now()) {
+		return nil, "", errInvalidCeremony
+	}
+
+	return g, cd.Challenge, nil
+}
+
+// Responds with 401 to ceremonies that fail verification.
+func writeCeremonyError(w http.ResponseWriter, err error) error {
+	if errors.Is(err, webauthn.ErrVerification) || errors.Is(err, errInvalidCeremony) || errors.Is(err, types.ErrInvalidCredentials) {
+		return writeJSON(w, http.StatusUnauthorized, HTTPErrResponse{Error: err.Error()})
+	}
+
+	return err
+}
@@ -0,0 +1,173 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+const (
+	// Prefix of keys, so secret scanners and people can recognize them.
+	Prefix = "ak_"
+	// Default lifetime of keys.
+	defaultTTL = 365 * 24 * time.Hour
+	// Last-used times are written at most this often per key.
+	lastUsedResolution = time.Minute
+
+	idLength = 16
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// APIKeyService issues API keys of the APIKeyStore and authenticates them.
+type APIKeyService struct {
+	store types.APIKeyStore
+	now   func() time.Time
+	// Serializes updates of keys, so recording a use can't undo a revocation.
+	mu sync.Mutex
+}
+
+// NewAPIKeyService creates an APIKeyManager of the store.
+func NewAPIKeyService(store types.APIKeyStore) *APIKeyService {
+	return &APIKeyService{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// CreateAPIKey generates a key formatted as ak_<id>_<secret>.
+// Its subject is the key ID unless set.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, nk types.NewAPIKey) (string, *types.APIKey, error) {
+	if nk.TTL < 0 {
+		return "", nil, errors.New("ttl must be positive")
+	}
+	ttl := time.Duration(nk.TTL) * time.Second
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+
+	id := make([]byte, idLength/2)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	k := &types.APIKey{
+		ID:        hex.EncodeToString(id),
+		Label:     nk.Label,
+		Subject:   nk.Subject,
+		Scopes:    nk.Scopes,
+		CreatedAt: s.now().UTC().Truncate(time.Second),
+	}
+	k.ExpiresAt = k.CreatedAt.Add(ttl)
+	if k.Subject == "" {
+		k.Subject = k.ID
+	}
+	key := Prefix + k.ID + "_" + strings.ToLower(secretEncoding.EncodeToString(secret))
+	k.Hash = hash(key)
+
+	if err := s.store.Create(ctx, k); err != nil {
+		return "", nil, err
+	}
+
+	return key, k, nil
+}
+
+// APIKeys returns all keys.
+func (s *APIKeyService) APIKeys(ctx context.Context) ([]*types.APIKey, error) {
+	return s.store.APIKeys(ctx)
+}
+
+// RevokeAPIKey revokes the key, revoking it again keeps the first time.
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, err := s.store.APIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !k.RevokedAt.IsZero() {
+		return nil
+	}
+	k.RevokedAt = s.now().UTC().Truncate(time.Second)
+
+	return s.store.Update(ctx, k)
+}
+
+// AuthenticateAPIKey checks the key and records its use.
+func (s *APIKeyService) AuthenticateAPIKey(ctx context.Context, key string) (*types.APIKey, error) {
+	id, err := parseID(key)
+	if err != nil {
+		return nil, types.ErrInvalidCredentials
+	}
+
+	k, err := s.store.APIKey(ctx, id)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, types.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash(key))) != 1 ||
+		!k.RevokedAt.IsZero() || !now.Before(k.ExpiresAt) {
+		return nil, types.ErrInvalidCredentials
+	}
+
+	if now.Sub(k.LastUsedAt) >= lastUsedResolution {
+		if err := s.recordUse(ctx, k.ID, now); err != nil {
+			log.Printf("couldn't record use of api key %s: %v", k.ID, err)
+		}
+	}
+
+	return k, nil
+}
+
+// Sets the last use of the key as it is now stored, keeping a
+// revocation that happened since it was authenticated.
+func (s *APIKeyService) recordUse(ctx context.Context, id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, err := s.store.APIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !k.RevokedAt.IsZero() || now.Sub(k.LastUsedAt) < lastUsedResolution {
+		return nil
+	}
+	k.LastUsedAt = now.UTC().Truncate(time.Second)
+
+	return s.store.Update(ctx, k)
+}
+
+// Extracts the key ID of ak_<id>_<secret>.
+func parseID(key string) (string, error) {
+	rest, ok := strings.CutPrefix(key, Prefix)
+	if !ok || len(rest) <= idLength+1 || rest[idLength] != '_' {
+		return "", fmt.Errorf("malformed api key")
+	}
+
+	return rest[:idLength], nil
+}
+
+// Hashes the key, which has too much entropy to need a slow hash.
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
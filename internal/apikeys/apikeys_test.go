@@ -0,0 +1,147 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+func newStores(t *testing.T) map[string]types.APIKeyStore {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "auth.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	boltStore, err := NewBoltStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]types.APIKeyStore{
+		"memory": NewMemoryStore(),
+		"bolt":   boltStore,
+	}
+}
+
+func TestAPIKeyService(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc := NewAPIKeyService(store)
+			now := time.Unix(1700000000, 0)
+			svc.now = func() time.Time { return now }
+
+			key, k, err := svc.CreateAPIKey(ctx, types.NewAPIKey{Label: "partner", Scopes: []string{"invoices:read"}, TTL: 3600})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(key, Prefix+k.ID+"_") || k.Subject != k.ID || !k.ExpiresAt.Equal(now.Add(time.Hour)) {
+				t.Fatalf("unexpected key: %s, %+v", key, k)
+			}
+			stored, _ := store.APIKey(ctx, k.ID)
+			if stored.Hash == "" || strings.Contains(stored.Hash, key[len(Prefix)+idLength+1:]) {
+				t.Error("only a hash of the key should be stored")
+			}
+
+			got, err := svc.AuthenticateAPIKey(ctx, key)
+			if err != nil || got.ID != k.ID || got.Scopes[0] != "invoices:read" {
+				t.Fatalf("key should authenticate: %+v, %v", got, err)
+			}
+			if stored, _ := store.APIKey(ctx, k.ID); !stored.LastUsedAt.Equal(now.UTC()) {
+				t.Errorf("last use should be recorded: %v", stored.LastUsedAt)
+			}
+
+			invalid := map[string]string{
+				"unknown key":  Prefix + strings.Repeat("0", idLength) + "_secret",
+				"wrong secret": key[:len(key)-1] + "x",
+				"malformed":    "secret",
+			}
+			for name, key := range invalid {
+				if _, err := svc.AuthenticateAPIKey(ctx, key); !errors.Is(err, types.ErrInvalidCredentials) {
+					t.Errorf("%s shouldn't authenticate: %v", name, err)
+				}
+			}
+
+			now = now.Add(time.Hour)
+			if _, err := svc.AuthenticateAPIKey(ctx, key); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("expired key shouldn't authenticate: %v", err)
+			}
+
+			other, _, _ := svc.CreateAPIKey(ctx, types.NewAPIKey{Subject: "partner-2"})
+			otherID, _ := parseID(other)
+			if err := svc.RevokeAPIKey(ctx, otherID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.AuthenticateAPIKey(ctx, other); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("revoked key shouldn't authenticate: %v", err)
+			}
+			if err := svc.RevokeAPIKey(ctx, "unknown"); !errors.Is(err, types.ErrNotFound) {
+				t.Errorf("unknown key shouldn't be revoked: %v", err)
+			}
+
+			keys, _ := svc.APIKeys(ctx)
+			if len(keys) != 2 || keys[0].ID != k.ID || keys[1].RevokedAt.IsZero() {
+				t.Errorf("keys should be listed by creation: %+v", keys)
+			}
+		})
+	}
+}
+
+// Revokes the key when it is first read, as if revoked while being authenticated.
+type revokingStore struct {
+	types.APIKeyStore
+	revoke func()
+}
+
+func (s *revokingStore) APIKey(ctx context.Context, id string) (*types.APIKey, error) {
+	k, err := s.APIKeyStore.APIKey(ctx, id)
+	if s.revoke != nil {
+		revoke := s.revoke
+		s.revoke = nil
+		revoke()
+	}
+
+	return k, err
+}
+
+func TestAPIKeyServiceRevokeDuringAuthentication(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			rs := &revokingStore{APIKeyStore: store}
+			svc := NewAPIKeyService(rs)
+			key, k, err := svc.CreateAPIKey(ctx, types.NewAPIKey{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rs.revoke = func() {
+				if err := svc.RevokeAPIKey(ctx, k.ID); err != nil {
+					t.Error(err)
+				}
+			}
+			if _, err := svc.AuthenticateAPIKey(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, _ := store.APIKey(ctx, k.ID)
+			if stored.RevokedAt.IsZero() {
+				t.Error("recording the use shouldn't undo the revocation")
+			}
+			if _, err := svc.AuthenticateAPIKey(ctx, key); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("revoked key shouldn't authenticate: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package apikeys
+
+import (
+	"context"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Keys keyed by id.
+var keysBucket = []byte("api_keys")
+
+// Stored key with its hash, which isn't part of the API.
+type storedKey struct {
+	*types.APIKey
+	Hash string `json:"hash"`
+}
+
+// APIKeyStore persisted in an embedded bolt database.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore creates an APIKeyStore persisted in the bolt database.
+func NewBoltStore(db *bolt.DB) (types.APIKeyStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(keysBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+// Create stores a new key.
+func (s *boltStore) Create(_ context.Context, k *types.APIKey) error {
+	data, err := json.Marshal(storedKey{APIKey: k, Hash: k.Hash})
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(keysBucket)
+		if b.Get([]byte(k.ID)) != nil {
+			return types.ErrAlreadyExists
+		}
+		return b.Put([]byte(k.ID), data)
+	})
+}
+
+// APIKey returns the key.
+func (s *boltStore) APIKey(_ context.Context, id string) (k *types.APIKey, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(keysBucket).Get([]byte(id))
+		if data == nil {
+			return types.ErrNotFound
+		}
+		k, err = decodeKey(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// APIKeys returns all keys ordered by creation.
+func (s *boltStore) APIKeys(_ context.Context) ([]*types.APIKey, error) {
+	var keys []*types.APIKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucket).ForEach(func(_, v []byte) error {
+			k, err := decodeKey(v)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, k)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortKeys(keys)
+
+	return keys, nil
+}
+
+// Update replaces the key.
+func (s *boltStore) Update(_ context.Context, k *types.APIKey) error {
+	data, err := json.Marshal(storedKey{APIKey: k, Hash: k.Hash})
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(keysBucket)
+		if b.Get([]byte(k.ID)) == nil {
+			return types.ErrNotFound
+		}
+		return b.Put([]byte(k.ID), data)
+	})
+}
+
+// Decodes a stored key.
+func decodeKey(data []byte) (*types.APIKey, error) {
+	sk := storedKey{APIKey: new(types.APIKey)}
+	if err := json.Unmarshal(data, &sk); err != nil {
+		return nil, err
+	}
+	sk.APIKey.Hash = sk.Hash
+
+	return sk.APIKey, nil
+}
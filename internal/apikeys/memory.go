@@ -0,0 +1,96 @@
+package apikeys
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// In-memory APIKeyStore.
+type memoryStore struct {
+	mu   sync.Mutex
+	keys map[string]types.APIKey
+}
+
+// NewMemoryStore creates an in-memory APIKeyStore
+// that loses its keys on restart, useful for tests.
+func NewMemoryStore() types.APIKeyStore {
+	return &memoryStore{
+		keys: make(map[string]types.APIKey),
+	}
+}
+
+// Create stores a new key.
+func (s *memoryStore) Create(_ context.Context, k *types.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[k.ID]; ok {
+		return types.ErrAlreadyExists
+	}
+	s.keys[k.ID] = clone(k)
+
+	return nil
+}
+
+// APIKey returns the key.
+func (s *memoryStore) APIKey(_ context.Context, id string) (*types.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	k = clone(&k)
+
+	return &k, nil
+}
+
+// APIKeys returns all keys ordered by creation.
+func (s *memoryStore) APIKeys(_ context.Context) ([]*types.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]*types.APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		k = clone(&k)
+		keys = append(keys, &k)
+	}
+	sortKeys(keys)
+
+	return keys, nil
+}
+
+// Update replaces the key.
+func (s *memoryStore) Update(_ context.Context, k *types.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[k.ID]; !ok {
+		return types.ErrNotFound
+	}
+	s.keys[k.ID] = clone(k)
+
+	return nil
+}
+
+// Copies the key so callers can't modify stored scopes.
+func clone(k *types.APIKey) types.APIKey {
+	c := *k
+	c.Scopes = slices.Clone(k.Scopes)
+	return c
+}
+
+// Orders keys by creation, then by ID.
+func sortKeys(keys []*types.APIKey) {
+	slices.SortFunc(keys, func(a, b *types.APIKey) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+}
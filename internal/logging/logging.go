@@ -25,21 +25,21 @@ func NewLoggingService(svc types.TokenService) types.TokenService {
 
 // Token passes call to Token to the next TokenService implmentator
 // and logs time since start, request_id, err and a new token to stdout.
-func (s *loggingService) Token(ctx context.Context, payload []byte) (token []byte, err error) {
+func (s *loggingService) Token(ctx context.Context, payload []byte, opts ...types.TokenOption) (token []byte, err error) {
 	defer func(t time.Time) {
 		s.log.InfoContext(
 			ctx,
 			fmt.Sprintf(
 				"time=%+v, request_id=%+v, err=%+v, token=%+v",
 				time.Since(t),
-				ctx.Value(types.RequestID("request_id")),
+				types.RequestIDFrom(ctx),
 				err,
 				string(token),
 			),
 		)
 	}(time.Now())
 
-	return s.svc.Token(ctx, payload)
+	return s.svc.Token(ctx, payload, opts...)
 }
 
 // Validate passes call to Validate to the next TokenService implmentator
@@ -51,7 +51,7 @@ func (s *loggingService) Validate(ctx context.Context, token []byte) (err error)
 			fmt.Sprintf(
 				"time=%+v, request_id=%+v, err=%+v, token=%+v",
 				time.Since(t),
-				ctx.Value(types.RequestID("request_id")),
+				types.RequestIDFrom(ctx),
 				err,
 				string(token),
 			),
@@ -60,3 +60,27 @@ func (s *loggingService) Validate(ctx context.Context, token []byte) (err error)
 
 	return s.svc.Validate(ctx, token)
 }
+
+// Introspect passes call to Introspect to the next TokenService implmentator
+// and logs time since start, request_id, err and the reason if the token is not valid.
+func (s *loggingService) Introspect(ctx context.Context, token []byte) (in *types.Introspection, err error) {
+	defer func(t time.Time) {
+		var reason string
+		if in != nil {
+			reason = in.Reason
+		}
+		s.log.InfoContext(
+			ctx,
+			fmt.Sprintf(
+				"time=%+v, request_id=%+v, err=%+v, reason=%+v, token=%+v",
+				time.Since(t),
+				types.RequestIDFrom(ctx),
+				err,
+				reason,
+				string(token),
+			),
+		)
+	}(time.Now())
+
+	return s.svc.Introspect(ctx, token)
+}
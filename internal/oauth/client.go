@@ -0,0 +1,166 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Grant types of the token endpoint.
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantAuthorizationCode = "authorization_code"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// Client is a registered OAuth 2.0 client.
+type Client struct {
+	ID string `json:"client_id"`
+	// SecretHash is a hex encoded SHA-256 hash of the client secret.
+	// Public clients, e.g. native apps, have no secret.
+	SecretHash string `json:"client_secret_sha256,omitempty"`
+	// Scopes the client is allowed to request.
+	Scopes []string `json:"scopes,omitempty"`
+	// GrantTypes the client may use, client_credentials if empty.
+	GrantTypes []string `json:"grant_types,omitempty"`
+	// Audience of tokens issued to the client, the server default if empty.
+	Audience []string `json:"audience,omitempty"`
+	// RedirectURIs the authorization endpoint may redirect to, matched exactly.
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+	// Exchange limits tokens the client obtains by token exchange.
+	Exchange *ExchangePolicy `json:"token_exchange,omitempty"`
+	// Roles grant permissions to tokens whose subject is the client.
+	Roles []string `json:"roles,omitempty"`
+}
+
+// ClientStore finds registered clients.
+type ClientStore interface {
+	// Client returns the client or types.ErrNotFound.
+	Client(ctx context.Context, id string) (*Client, error)
+}
+
+// HashSecret hashes a client secret for Client.SecretHash.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate reports whether the secret is the client secret.
+func (c *Client) Authenticate(secret string) bool {
+	return c.SecretHash != "" && subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(c.SecretHash)) == 1
+}
+
+// Public reports whether the client can't keep a secret.
+func (c *Client) Public() bool {
+	return c.SecretHash == ""
+}
+
+// ResolveRedirectURI returns the registered redirect URI matching the
+// requested one exactly, or the only registered one if none is requested.
+func (c *Client) ResolveRedirectURI(requested string) (string, error) {
+	if requested == "" {
+		if len(c.RedirectURIs) != 1 {
+			return "", NewError(ErrInvalidRequest, "redirect_uri not provided")
+		}
+		return c.RedirectURIs[0], nil
+	}
+	if !slices.Contains(c.RedirectURIs, requested) {
+		return "", NewError(ErrInvalidRequest, "redirect_uri is not registered")
+	}
+
+	return requested, nil
+}
+
+// AllowsGrant reports whether the client may use the grant type.
+func (c *Client) AllowsGrant(grantType string) bool {
+	if len(c.GrantTypes) == 0 {
+		return grantType == GrantClientCredentials
+	}
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+// ResolveScopes checks the space-delimited requested scopes against
+// the allowed ones. All allowed scopes are granted if none are requested.
+func (c *Client) ResolveScopes(requested string) ([]string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return c.Scopes, nil
+	}
+
+	for _, s := range scopes {
+		if !slices.Contains(c.Scopes, s) {
+			return nil, NewError(ErrInvalidScope, "scope "+s+" is not allowed")
+		}
+	}
+
+	return scopes, nil
+}
+
+// In-memory ClientStore.
+type memoryClientStore struct {
+	clients map[string]Client
+}
+
+// NewMemoryClientStore creates a ClientStore of the given clients.
+func NewMemoryClientStore(clients ...Client) ClientStore {
+	s := &memoryClientStore{clients: make(map[string]Client, len(clients))}
+	for _, c := range clients {
+		s.clients[c.ID] = c
+	}
+
+	return s
+}
+
+// Client returns the client.
+func (s *memoryClientStore) Client(_ context.Context, id string) (*Client, error) {
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+
+	return &c, nil
+}
+
+// ClientRoles resolves roles of the clients of the store,
+// tokens of the client_credentials grant have them as subject.
+func ClientRoles(store ClientStore) types.RoleResolver {
+	return clientRoles{store: store}
+}
+
+// RoleResolver of a ClientStore.
+type clientRoles struct {
+	store ClientStore
+}
+
+// Roles returns the roles of the client.
+func (r clientRoles) Roles(ctx context.Context, id string) ([]string, error) {
+	c, err := r.store.Client(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Roles, nil
+}
+
+// LoadClients reads a JSON array of clients from the file.
+func LoadClients(path string) ([]Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []Client
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
@@ -0,0 +1,51 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Error codes of RFC 6749 section 5.2.
+const (
+	ErrInvalidRequest       = "invalid_request"
+	ErrInvalidClient        = "invalid_client"
+	ErrInvalidGrant         = "invalid_grant"
+	ErrUnauthorizedClient   = "unauthorized_client"
+	ErrUnsupportedGrantType = "unsupported_grant_type"
+	ErrInvalidScope         = "invalid_scope"
+	// Defined by RFC 6749 section 4.1.2.1.
+	ErrAccessDenied            = types.OAuthAccessDenied
+	ErrUnsupportedResponseType = "unsupported_response_type"
+	// Defined by RFC 7009 section 2.2.1.
+	ErrUnsupportedTokenType = "unsupported_token_type"
+	// Defined by RFC 8628 section 3.5.
+	ErrAuthorizationPending = types.OAuthAuthorizationPending
+	ErrSlowDown             = types.OAuthSlowDown
+	ErrExpiredToken         = types.OAuthExpiredToken
+	// Defined by RFC 8693 section 2.2.2.
+	ErrInvalidTarget = "invalid_target"
+	// Defined by RFC 6750 section 3.1.
+	ErrInvalidToken = "invalid_token"
+	ErrServerError  = "server_error"
+)
+
+// Error is an RFC 6749 error response, shared with clients.
+type Error = types.OAuthError
+
+// NewError creates an error response with the code and description.
+func NewError(code, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+// Status returns the HTTP status code of the error response.
+func Status(e *Error) int {
+	switch e.Code {
+	case ErrInvalidClient:
+		return http.StatusUnauthorized
+	case ErrServerError:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,57 @@
+package oauth
+
+import (
+	"slices"
+	"strings"
+)
+
+// Token type identifiers of RFC 8693 section 3.
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// ExchangePolicy limits the tokens a client obtains by token exchange.
+type ExchangePolicy struct {
+	// Audiences the client may request tokens for.
+	Audiences []string `json:"audiences"`
+	// Scopes the exchanged tokens may carry, any scope
+	// of the subject token if empty.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// ResolveExchange checks the requested audiences and space-delimited scopes
+// against the policy of the client. Scopes can only be narrowed from those of
+// the subject token, which are kept if none are requested.
+func (c *Client) ResolveExchange(audiences []string, requested string, subjectScopes []string) ([]string, error) {
+	if c.Exchange == nil {
+		return nil, NewError(ErrUnauthorizedClient, "token exchange is not allowed for the client")
+	}
+	if len(audiences) == 0 {
+		return nil, NewError(ErrInvalidTarget, "audience not provided")
+	}
+	for _, aud := range audiences {
+		if !slices.Contains(c.Exchange.Audiences, aud) {
+			return nil, NewError(ErrInvalidTarget, "audience "+aud+" is not allowed")
+		}
+	}
+
+	allowed := subjectScopes
+	if len(c.Exchange.Scopes) > 0 {
+		allowed = slices.DeleteFunc(slices.Clone(subjectScopes), func(s string) bool {
+			return !slices.Contains(c.Exchange.Scopes, s)
+		})
+	}
+
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return allowed, nil
+	}
+	for _, s := range scopes {
+		if !slices.Contains(allowed, s) {
+			return nil, NewError(ErrInvalidScope, "scope "+s+" can't be exchanged")
+		}
+	}
+
+	return scopes, nil
+}
@@ -0,0 +1,139 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"strings"
+	"time"
+)
+
+// Grant is a pending authorization the client redeems at the token endpoint.
+type Grant struct {
+	// ID is a hash of the code the client redeems.
+	ID   string `json:"id"`
+	Type string `json:"type"`
+
+	ClientID string `json:"client_id"`
+	// RedirectURI given in the authorization request, the
+	// token request must repeat it.
+	RedirectURI   string   `json:"redirect_uri,omitempty"`
+	CodeChallenge string   `json:"code_challenge,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
+	Nonce         string   `json:"nonce,omitempty"`
+
+	// Subject and AuthTime of the resource owner who approved the grant.
+	Subject  string    `json:"sub,omitempty"`
+	AuthTime time.Time `json:"auth_time"`
+
+	// UserCode is a hash of the code the user enters to approve a device grant.
+	UserCode string `json:"user_code,omitempty"`
+	// Status of a device grant the client polls for.
+	Status   string        `json:"status,omitempty"`
+	Interval time.Duration `json:"interval,omitempty"`
+	PolledAt time.Time     `json:"polled_at"`
+
+	// Attempts counts failed codes of an MFA challenge.
+	Attempts int `json:"attempts,omitempty"`
+
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Statuses of device grants.
+const (
+	GrantPending  = "pending"
+	GrantApproved = "approved"
+	GrantDenied   = "denied"
+)
+
+// Expired reports whether the grant can't be redeemed at the time.
+func (g *Grant) Expired(now time.Time) bool {
+	return !now.Before(g.ExpiresAt)
+}
+
+// GrantStore persists pending grants.
+type GrantStore interface {
+	// Create stores a new grant.
+	Create(ctx context.Context, g *Grant) error
+	// Grant returns the grant or types.ErrNotFound.
+	Grant(ctx context.Context, id string) (*Grant, error)
+	// GrantByUserCode returns the grant with the user code
+	// hash or types.ErrNotFound.
+	GrantByUserCode(ctx context.Context, userCode string) (*Grant, error)
+	// Update replaces the existing grant or returns types.ErrNotFound.
+	Update(ctx context.Context, g *Grant) error
+	// UpdatePending replaces the existing grant only while it is pending,
+	// so polls don't overwrite a decision, or returns types.ErrNotFound.
+	UpdatePending(ctx context.Context, g *Grant) error
+	// Take deletes the grant and returns it, or types.ErrNotFound
+	// if there is no such grant, so it can be redeemed only once.
+	Take(ctx context.Context, id string) (*Grant, error)
+	// Fail counts a failed attempt of the grant and deletes it once
+	// max attempts failed, or returns types.ErrNotFound.
+	Fail(ctx context.Context, id string, max int) error
+	// Purge removes grants expired at the time.
+	Purge(ctx context.Context, now time.Time) error
+}
+
+// NewCode generates a random code and the grant ID it is stored under.
+func NewCode() (code, id string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	code = base64.RawURLEncoding.EncodeToString(b)
+
+	return code, HashSecret(code), nil
+}
+
+// Alphabet of user codes without vowels and similar looking letters.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// NewUserCode generates a user code of 8 letters formatted
+// as XXXX-XXXX and the hash it is stored under.
+func NewUserCode() (code, hash string, err error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	raw := make([]byte, len(b))
+	for i, v := range b {
+		// 256 isn't a multiple of 20, the slight bias is acceptable.
+		raw[i] = userCodeAlphabet[int(v)%len(userCodeAlphabet)]
+	}
+	code = string(raw[:4]) + "-" + string(raw[4:])
+
+	return code, HashUserCode(code), nil
+}
+
+// HashUserCode hashes the user code ignoring case and separators.
+func HashUserCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteRune(r)
+		}
+	}
+
+	return HashSecret(b.String())
+}
+
+// RunGrantGC purges expired grants from the store
+// every interval until the context is done.
+func RunGrantGC(ctx context.Context, store GrantStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := store.Purge(ctx, now); err != nil {
+				log.Printf("couldn't purge expired grants: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+var (
+	// Grants keyed by id.
+	grantsBucket = []byte("oauth_grants")
+	// Index of user codes to grant ids.
+	userCodesBucket = []byte("oauth_user_codes")
+)
+
+// GrantStore persisted in an embedded bolt database.
+type boltGrantStore struct {
+	db *bolt.DB
+}
+
+// NewBoltGrantStore creates a GrantStore persisted in the bolt database.
+func NewBoltGrantStore(db *bolt.DB) (GrantStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{grantsBucket, userCodesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltGrantStore{db: db}, nil
+}
+
+// Create stores a new grant.
+func (s *boltGrantStore) Create(_ context.Context, g *Grant) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if g.UserCode != "" {
+			if err := tx.Bucket(userCodesBucket).Put([]byte(g.UserCode), []byte(g.ID)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(grantsBucket).Put([]byte(g.ID), data)
+	})
+}
+
+// Grant returns the grant.
+func (s *boltGrantStore) Grant(_ context.Context, id string) (g *Grant, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		g, err = getGrant(tx, []byte(id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// GrantByUserCode returns the grant with the user code.
+func (s *boltGrantStore) GrantByUserCode(_ context.Context, userCode string) (g *Grant, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(userCodesBucket).Get([]byte(userCode))
+		if id == nil {
+			return types.ErrNotFound
+		}
+		g, err = getGrant(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Update replaces the existing grant.
+func (s *boltGrantStore) Update(_ context.Context, g *Grant) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(grantsBucket)
+		if b.Get([]byte(g.ID)) == nil {
+			return types.ErrNotFound
+		}
+		return b.Put([]byte(g.ID), data)
+	})
+}
+
+// UpdatePending replaces the existing grant while it is pending.
+func (s *boltGrantStore) UpdatePending(_ context.Context, g *Grant) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stored, err := getGrant(tx, []byte(g.ID))
+		if err != nil {
+			return err
+		}
+		if stored.Status != GrantPending {
+			return nil
+		}
+		return tx.Bucket(grantsBucket).Put([]byte(g.ID), data)
+	})
+}
+
+// Take deletes the grant and returns it.
+func (s *boltGrantStore) Take(_ context.Context, id string) (g *Grant, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		g, err = getGrant(tx, []byte(id))
+		if err != nil {
+			return err
+		}
+		return deleteGrant(tx, g)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Fail counts a failed attempt of the grant.
+func (s *boltGrantStore) Fail(_ context.Context, id string, max int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		g, err := getGrant(tx, []byte(id))
+		if err != nil {
+			return err
+		}
+		g.Attempts++
+		if g.Attempts >= max {
+			return deleteGrant(tx, g)
+		}
+
+		data, err := json.Marshal(g)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(grantsBucket).Put([]byte(g.ID), data)
+	})
+}
+
+// Purge removes expired grants.
+func (s *boltGrantStore) Purge(_ context.Context, now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var expired []*Grant
+		err := tx.Bucket(grantsBucket).ForEach(func(k, v []byte) error {
+			g := &Grant{ID: string(k)}
+			if err := json.Unmarshal(v, g); err != nil || g.Expired(now) {
+				expired = append(expired, g)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, g := range expired {
+			if err := deleteGrant(tx, g); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Reads the grant in the transaction.
+func getGrant(tx *bolt.Tx, id []byte) (*Grant, error) {
+	data := tx.Bucket(grantsBucket).Get(id)
+	if data == nil {
+		return nil, types.ErrNotFound
+	}
+
+	g := new(Grant)
+	if err := json.Unmarshal(data, g); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Deletes the grant and its user code in the transaction.
+func deleteGrant(tx *bolt.Tx, g *Grant) error {
+	if g.UserCode != "" {
+		if err := tx.Bucket(userCodesBucket).Delete([]byte(g.UserCode)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket(grantsBucket).Delete([]byte(g.ID))
+}
@@ -0,0 +1,136 @@
+package oauth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// In-memory GrantStore.
+type memoryGrantStore struct {
+	mu     sync.Mutex
+	grants map[string]Grant
+}
+
+// NewMemoryGrantStore creates an in-memory GrantStore
+// that loses its grants on restart.
+func NewMemoryGrantStore() GrantStore {
+	return &memoryGrantStore{
+		grants: make(map[string]Grant),
+	}
+}
+
+// Create stores a new grant.
+func (s *memoryGrantStore) Create(_ context.Context, g *Grant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.grants[g.ID] = *g
+
+	return nil
+}
+
+// Grant returns the grant.
+func (s *memoryGrantStore) Grant(_ context.Context, id string) (*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.grants[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+
+	return &g, nil
+}
+
+// GrantByUserCode returns the grant with the user code.
+func (s *memoryGrantStore) GrantByUserCode(_ context.Context, userCode string) (*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, g := range s.grants {
+		if g.UserCode != "" && g.UserCode == userCode {
+			return &g, nil
+		}
+	}
+
+	return nil, types.ErrNotFound
+}
+
+// Update replaces the existing grant.
+func (s *memoryGrantStore) Update(_ context.Context, g *Grant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.grants[g.ID]; !ok {
+		return types.ErrNotFound
+	}
+	s.grants[g.ID] = *g
+
+	return nil
+}
+
+// UpdatePending replaces the existing grant while it is pending.
+func (s *memoryGrantStore) UpdatePending(_ context.Context, g *Grant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.grants[g.ID]
+	if !ok {
+		return types.ErrNotFound
+	}
+	if stored.Status == GrantPending {
+		s.grants[g.ID] = *g
+	}
+
+	return nil
+}
+
+// Take deletes the grant and returns it.
+func (s *memoryGrantStore) Take(_ context.Context, id string) (*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.grants[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	delete(s.grants, id)
+
+	return &g, nil
+}
+
+// Fail counts a failed attempt of the grant.
+func (s *memoryGrantStore) Fail(_ context.Context, id string, max int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.grants[id]
+	if !ok {
+		return types.ErrNotFound
+	}
+	g.Attempts++
+	if g.Attempts >= max {
+		delete(s.grants, id)
+	} else {
+		s.grants[id] = g
+	}
+
+	return nil
+}
+
+// Purge removes expired grants.
+func (s *memoryGrantStore) Purge(_ context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, g := range s.grants {
+		if g.Expired(now) {
+			delete(s.grants, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+func newGrantStores(t *testing.T) map[string]GrantStore {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "auth.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	boltStore, err := NewBoltGrantStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]GrantStore{
+		"memory": NewMemoryGrantStore(),
+		"bolt":   boltStore,
+	}
+}
+
+func TestGrantStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	for name, store := range newGrantStores(t) {
+		t.Run(name, func(t *testing.T) {
+			code, id, err := NewCode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != HashSecret(code) {
+				t.Fatal("grant id should be the hash of the code")
+			}
+
+			err = store.Create(ctx, &Grant{ID: id, Type: GrantAuthorizationCode, Subject: "user-1", ExpiresAt: now.Add(time.Minute)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = store.Create(ctx, &Grant{ID: "expired", ExpiresAt: now.Add(-time.Second)})
+
+			g, err := store.Take(ctx, id)
+			if err != nil || g.Subject != "user-1" {
+				t.Fatalf("grant should be taken: %+v, %v", g, err)
+			}
+			if _, err := store.Take(ctx, id); !errors.Is(err, types.ErrNotFound) {
+				t.Errorf("grant shouldn't be taken twice: %v", err)
+			}
+
+			userCode, hash, _ := NewUserCode()
+			err = store.Create(ctx, &Grant{ID: "device", Type: GrantDeviceCode, UserCode: hash, Status: GrantPending, ExpiresAt: now.Add(time.Minute)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			g, err = store.GrantByUserCode(ctx, HashUserCode(strings.ToLower(userCode)))
+			if err != nil || g.ID != "device" {
+				t.Fatalf("grant should be found by user code: %+v, %v", g, err)
+			}
+			polled := *g
+			polled.PolledAt = now
+			g.Status = GrantApproved
+			if err := store.Update(ctx, g); err != nil {
+				t.Fatal(err)
+			}
+			if g, _ := store.Grant(ctx, "device"); g.Status != GrantApproved {
+				t.Errorf("grant should be updated: %+v", g)
+			}
+			if err := store.UpdatePending(ctx, &polled); err != nil {
+				t.Fatal(err)
+			}
+			if g, _ := store.Grant(ctx, "device"); g.Status != GrantApproved || !g.PolledAt.IsZero() {
+				t.Errorf("poll shouldn't overwrite the approval: %+v", g)
+			}
+			if err := store.Update(ctx, &Grant{ID: "other"}); !errors.Is(err, types.ErrNotFound) {
+				t.Errorf("missing grant shouldn't be updated: %v", err)
+			}
+			_, _ = store.Take(ctx, "device")
+			if _, err := store.GrantByUserCode(ctx, hash); !errors.Is(err, types.ErrNotFound) {
+				t.Errorf("user code of a taken grant shouldn't be found: %v", err)
+			}
+
+			_ = store.Create(ctx, &Grant{ID: "challenge", ExpiresAt: now.Add(time.Minute)})
+			for range 2 {
+				if err := store.Fail(ctx, "challenge", 3); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if g, _ := store.Grant(ctx, "challenge"); g == nil || g.Attempts != 2 {
+				t.Errorf("failed attempts should be counted: %+v", g)
+			}
+			if err := store.Fail(ctx, "challenge", 3); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Fail(ctx, "challenge", 3); !errors.Is(err, types.ErrNotFound) {
+				t.Errorf("grant should be deleted after max attempts: %v", err)
+			}
+
+			if err := store.Purge(ctx, now); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Take(ctx, "expired"); !errors.Is(err, types.ErrNotFound) {
+				t.Errorf("expired grant should be purged: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// Example of RFC 7636 appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !ValidCodeChallenge(challenge) || CodeChallenge(verifier) != challenge {
+		t.Fatal("challenge should be derived from the verifier")
+	}
+	if !VerifyCodeChallenge(challenge, verifier) {
+		t.Error("verifier should match")
+	}
+	if VerifyCodeChallenge(challenge, verifier[:42]) {
+		t.Error("short verifier shouldn't match")
+	}
+	if VerifyCodeChallenge(challenge, verifier+"x") {
+		t.Error("other verifier shouldn't match")
+	}
+}
@@ -0,0 +1,48 @@
+package oauth
+
+import "github.com/danblok/auth/pkg/types"
+
+// Introspection is an RFC 7662 introspection response.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Nbf       int64  `json:"nbf,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       any    `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+}
+
+// NewIntrospection converts the result of TokenService introspection.
+// Nothing but the active flag is disclosed about tokens that are not valid.
+func NewIntrospection(in *types.Introspection) *Introspection {
+	if !in.Valid {
+		return &Introspection{Active: false}
+	}
+
+	str := func(name string) string {
+		s, _ := in.Claims[name].(string)
+		return s
+	}
+	nbf, _ := in.Claims["nbf"].(float64)
+
+	return &Introspection{
+		Active:    true,
+		Scope:     str("scope"),
+		ClientID:  str("client_id"),
+		Username:  str("username"),
+		TokenType: "Bearer",
+		Exp:       in.ExpiresAt,
+		Iat:       in.IssuedAt,
+		Nbf:       int64(nbf),
+		Sub:       str("sub"),
+		Aud:       in.Claims["aud"],
+		Iss:       str("iss"),
+		Jti:       str("jti"),
+	}
+}
@@ -0,0 +1,42 @@
+package oauth
+
+import (
+	"net/http"
+	"time"
+)
+
+// AuthorizationRequest is a validated request of the authorization endpoint.
+type AuthorizationRequest struct {
+	Client      *Client
+	RedirectURI string
+	Scopes      []string
+	State       string
+	Nonce       string
+}
+
+// Consent is the decision of the resource owner.
+type Consent struct {
+	Subject string
+	// Scopes granted, a subset of the requested ones.
+	// All requested scopes are granted if empty.
+	Scopes []string
+	// AuthTime is when the resource owner authenticated, now if zero.
+	AuthTime time.Time
+}
+
+// LoginHandler authenticates the resource owner and obtains their consent.
+//
+// It returns nil consent after writing its own response, e.g. a login page
+// that submits the request parameters back to the authorization endpoint.
+// An *Error such as access_denied is reported to the client redirect URI.
+type LoginHandler interface {
+	Login(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Consent, error)
+}
+
+// LoginHandlerFunc adapts a function to LoginHandler.
+type LoginHandlerFunc func(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Consent, error)
+
+// Login calls f(w, r, req).
+func (f LoginHandlerFunc) Login(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Consent, error) {
+	return f(w, r, req)
+}
@@ -0,0 +1,128 @@
+package oauth
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Form fields of the login page, other fields of the request are
+// passed back to the endpoint as they were.
+var loginFields = []string{"username", "password", "code", "deny"}
+
+// Page where users sign in to grant the requested scopes to the client.
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in</title></head>
+<body>
+<p>Sign in to {{.Client}}{{if .Scopes}}, which asks for {{range $i, $s := .Scopes}}{{if $i}}, {{end}}{{$s}}{{end}}{{end}}.</p>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+{{range .Hidden}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">
+{{end}}<label>Username <input name="username" value="{{.Username}}" autocomplete="username" autofocus></label>
+<label>Password <input name="password" type="password" autocomplete="current-password"></label>
+<label>Code of your authenticator, if enabled <input name="code" autocomplete="one-time-code"></label>
+<button type="submit">Sign in</button>
+<button type="submit" name="deny" value="1">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// Data of the login page.
+type loginData struct {
+	Client   string
+	Scopes   []string
+	Action   string
+	Hidden   []hiddenField
+	Username string
+	Message  string
+}
+
+// Field of the request passed back by the login page.
+type hiddenField struct {
+	Name, Value string
+}
+
+// Signs in users of the UserManager with their password and second factor.
+type passwordLogin struct {
+	users types.UserManager
+}
+
+// NewPasswordLogin returns a LoginHandler showing a login page of the
+// users, signing in grants the client the requested scopes.
+func NewPasswordLogin(users types.UserManager) LoginHandler {
+	return &passwordLogin{users: users}
+}
+
+// Login authenticates the user by the posted login page.
+func (l *passwordLogin) Login(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Consent, error) {
+	if r.Method != http.MethodPost || !r.PostForm.Has("username") {
+		return nil, renderLogin(w, r, req, http.StatusOK, "")
+	}
+	if r.PostForm.Has("deny") {
+		return nil, NewError(ErrAccessDenied, "the user denied access")
+	}
+
+	u, err := l.users.Authenticate(r.Context(), r.PostForm.Get("username"), r.PostForm.Get("password"))
+	if errors.Is(err, types.ErrInvalidCredentials) {
+		return nil, renderLogin(w, r, req, http.StatusUnauthorized, "The username or password is wrong.")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if u.MFA() {
+		code := r.PostForm.Get("code")
+		if code == "" {
+			return nil, renderLogin(w, r, req, http.StatusUnauthorized, "Enter the code of your authenticator.")
+		}
+		err := l.users.VerifyTOTP(r.Context(), u.ID, code)
+		if errors.Is(err, types.ErrInvalidCredentials) {
+			return nil, renderLogin(w, r, req, http.StatusUnauthorized, "The code is wrong.")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Consent{Subject: u.ID}, nil
+}
+
+// Renders the login page posting the request back to its endpoint.
+func renderLogin(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest, code int, message string) error {
+	data := loginData{
+		Client:   req.Client.ID,
+		Scopes:   req.Scopes,
+		Action:   r.URL.Path,
+		Username: r.PostForm.Get("username"),
+		Message:  message,
+	}
+	// Posted pages pass back their fields, not those of the query.
+	form := r.Form
+	if r.Method == http.MethodPost {
+		form = r.PostForm
+	}
+	for name, values := range form {
+		if slices.Contains(loginFields, name) {
+			continue
+		}
+		for _, v := range values {
+			data.Hidden = append(data.Hidden, hiddenField{Name: name, Value: v})
+		}
+	}
+	slices.SortStableFunc(data.Hidden, func(a, b hiddenField) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(code)
+	return loginPage.Execute(w, data)
+}
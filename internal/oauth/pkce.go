@@ -0,0 +1,37 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// The only PKCE method accepted, plain is not.
+const CodeChallengeS256 = "S256"
+
+var (
+	// Code verifier of RFC 7636 section 4.1.
+	codeVerifierRe = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	// Base64url encoded SHA-256 hash.
+	codeChallengeRe = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// ValidCodeChallenge reports whether the challenge is an S256 code challenge.
+func ValidCodeChallenge(challenge string) bool {
+	return codeChallengeRe.MatchString(challenge)
+}
+
+// CodeChallenge derives the S256 code challenge of the verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyCodeChallenge reports whether the verifier matches the S256 challenge.
+func VerifyCodeChallenge(challenge, verifier string) bool {
+	if !codeVerifierRe.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(CodeChallenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,81 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Standard claims released by the OpenID Connect scopes.
+var scopeClaims = map[string][]string{
+	"profile": {
+		"name", "family_name", "given_name", "middle_name", "nickname", "preferred_username",
+		"profile", "picture", "website", "gender", "birthdate", "zoneinfo", "locale", "updated_at",
+	},
+	"email":   {"email", "email_verified"},
+	"address": {"address"},
+	"phone":   {"phone_number", "phone_number_verified"},
+}
+
+// StandardClaims lists the claims userinfo may return.
+func StandardClaims() []string {
+	claims := []string{"sub"}
+	for _, names := range scopeClaims {
+		claims = append(claims, names...)
+	}
+	slices.Sort(claims)
+
+	return claims
+}
+
+// UserinfoClaims returns the profile claims released by the
+// scopes, only sub is released if the token has no scopes.
+func UserinfoClaims(sub string, profile map[string]any, scopes []string) map[string]any {
+	claims := map[string]any{"sub": sub}
+	for _, scope := range scopes {
+		for _, name := range scopeClaims[scope] {
+			if v, ok := profile[name]; ok {
+				claims[name] = v
+			}
+		}
+	}
+
+	return claims
+}
+
+type memoryProfileStore struct {
+	profiles map[string]map[string]any
+}
+
+// NewMemoryProfileStore creates a ProfileStore of profiles keyed by subject.
+func NewMemoryProfileStore(profiles map[string]map[string]any) types.ProfileStore {
+	return &memoryProfileStore{profiles: profiles}
+}
+
+// Profile returns claims of the subject.
+func (s *memoryProfileStore) Profile(_ context.Context, sub string) (map[string]any, error) {
+	p, ok := s.profiles[sub]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+
+	return p, nil
+}
+
+// LoadProfiles reads a JSON object of profiles keyed by subject from the file.
+func LoadProfiles(path string) (map[string]map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles map[string]map[string]any
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,185 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+var errNoPermission = errors.New("permission not provided")
+
+// Roles maps role names to the permissions they grant.
+// A permission ending with * grants all permissions of
+// its prefix, e.g. invoices:* grants invoices:read.
+type Roles map[string][]string
+
+// LoadRoles reads a JSON object of roles and their permissions from the file.
+func LoadRoles(path string) (Roles, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles Roles
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Permissions returns the sorted permissions of the roles.
+// Unknown roles grant nothing.
+func (r Roles) Permissions(roles []string) []string {
+	var perms []string
+	for _, role := range roles {
+		perms = append(perms, r[role]...)
+	}
+	slices.Sort(perms)
+
+	return slices.Compact(perms)
+}
+
+// Grant returns the granted permission that covers the permission.
+func Grant(granted []string, permission string) (string, bool) {
+	for _, g := range granted {
+		prefix, wildcard := strings.CutSuffix(g, "*")
+		if g == permission || wildcard && strings.HasPrefix(permission, prefix) {
+			return g, true
+		}
+	}
+
+	return "", false
+}
+
+// RBACService is a TokenService that embeds roles of subjects and
+// their permissions into tokens of the next TokenService, and
+// authorizes requests by the scope of the tokens.
+type RBACService struct {
+	svc       types.TokenService
+	roles     Roles
+	resolvers []types.RoleResolver
+}
+
+// NewRBACService creates role-based access control for TokenService.
+// Roles of subjects are taken from the first resolver that knows them.
+func NewRBACService(svc types.TokenService, roles Roles, resolvers ...types.RoleResolver) *RBACService {
+	return &RBACService{
+		svc:       svc,
+		roles:     roles,
+		resolvers: resolvers,
+	}
+}
+
+// Token adds the roles claim of the subject to the token of the next
+// TokenService implementator. The scope claim is always computed from
+// permissions of the roles, a scope the server issues the token with,
+// e.g. of a client or an API key, only narrows them.
+func (s *RBACService) Token(ctx context.Context, payload []byte, opts ...types.TokenOption) ([]byte, error) {
+	o := types.NewTokenOptions(opts...)
+	if o.Subject == "" || o.IDToken != nil {
+		return s.svc.Token(ctx, payload, opts...)
+	}
+
+	roles, err := s.resolve(ctx, o.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return s.svc.Token(ctx, payload, opts...)
+	}
+
+	// Claims are copied, options may be reused, e.g. on refresh.
+	claims := maps.Clone(o.ServerClaims)
+	if claims == nil {
+		claims = make(map[string]any, 2)
+	}
+	claims["roles"] = roles
+	scope := s.roles.Permissions(roles)
+	if requested, ok := claims["scope"].(string); ok {
+		scope = narrow(scope, strings.Fields(requested))
+	}
+	claims["scope"] = strings.Join(scope, " ")
+
+	return s.svc.Token(ctx, payload, append(opts, func(o *types.TokenOptions) { o.ServerClaims = claims })...)
+}
+
+// Scopes of OpenID Connect, they release claims of userinfo
+// rather than grant permissions, so roles don't limit them.
+var openIDScopes = []string{"openid", "profile", "email", "address", "phone", "offline_access"}
+
+// Returns the requested scopes the permissions grant.
+func narrow(perms, requested []string) []string {
+	scope := make([]string, 0, len(requested))
+	for _, r := range requested {
+		if _, ok := Grant(perms, r); ok || slices.Contains(openIDScopes, r) {
+			scope = append(scope, r)
+		}
+	}
+
+	return scope
+}
+
+// Validate passes call to Validate to the next TokenService implementator.
+func (s *RBACService) Validate(ctx context.Context, token []byte) error {
+	return s.svc.Validate(ctx, token)
+}
+
+// Introspect passes call to Introspect to the next TokenService implementator.
+func (s *RBACService) Introspect(ctx context.Context, token []byte) (*types.Introspection, error) {
+	return s.svc.Introspect(ctx, token)
+}
+
+// Authorize allows the permission if the token is valid and its scope
+// grants it. Roles of the token don't grant permissions beyond its scope.
+func (s *RBACService) Authorize(ctx context.Context, token []byte, permission string) (*types.Decision, error) {
+	if permission == "" {
+		return nil, errNoPermission
+	}
+
+	in, err := s.svc.Introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !in.Valid {
+		return &types.Decision{Reason: "token not valid: " + in.Reason}, nil
+	}
+
+	sub, _ := in.Claims["sub"].(string)
+	scope, _ := in.Claims["scope"].(string)
+	grant, ok := Grant(strings.Fields(scope), permission)
+	if !ok {
+		return &types.Decision{Reason: fmt.Sprintf("scope doesn't grant %s", permission), Subject: sub}, nil
+	}
+
+	return &types.Decision{Allowed: true, Reason: fmt.Sprintf("granted by scope %s", grant), Subject: sub}, nil
+}
+
+// Returns the roles of the subject of the first resolver that knows it.
+func (s *RBACService) resolve(ctx context.Context, sub string) ([]string, error) {
+	return ResolveRoles(ctx, sub, s.resolvers...)
+}
+
+// ResolveRoles returns the roles of the subject of the first resolver
+// that knows it, or no roles if none does.
+func ResolveRoles(ctx context.Context, sub string, resolvers ...types.RoleResolver) ([]string, error) {
+	for _, r := range resolvers {
+		roles, err := r.Roles(ctx, sub)
+		if errors.Is(err, types.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return roles, nil
+	}
+
+	return nil, nil
+}
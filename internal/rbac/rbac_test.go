@@ -0,0 +1,149 @@
+package rbac
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+// RoleResolver of fixed subjects.
+type staticRoles map[string][]string
+
+func (r staticRoles) Roles(_ context.Context, sub string) ([]string, error) {
+	roles, ok := r[sub]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	return roles, nil
+}
+
+var roles = Roles{
+	"viewer":  {"invoices:read", "users:read"},
+	"billing": {"invoices:*"},
+}
+
+func TestRBACServiceToken(t *testing.T) {
+	ctx := context.Background()
+	next := service.NewJWTService([]byte("secret"))
+	svc := NewRBACService(next, roles, staticRoles{"user-1": {"billing", "viewer"}}, staticRoles{"client-1": {"viewer"}, "user-1": {"admin"}})
+
+	tests := map[string]struct {
+		opts      []types.TokenOption
+		wantRoles []any
+		wantScope any
+	}{
+		"user roles": {
+			opts:      []types.TokenOption{types.WithSubject("user-1")},
+			wantRoles: []any{"billing", "viewer"},
+			wantScope: "invoices:* invoices:read users:read",
+		},
+		"client roles": {
+			opts:      []types.TokenOption{types.WithSubject("client-1")},
+			wantRoles: []any{"viewer"},
+			wantScope: "invoices:read users:read",
+		},
+		"narrowed scope": {
+			opts:      []types.TokenOption{types.WithSubject("user-1"), types.WithServerClaims(map[string]any{"scope": "openid invoices:write users:write"})},
+			wantRoles: []any{"billing", "viewer"},
+			wantScope: "openid invoices:write",
+		},
+		"scope beyond roles": {
+			opts:      []types.TokenOption{types.WithSubject("client-1"), types.WithServerClaims(map[string]any{"scope": "*"})},
+			wantRoles: []any{"viewer"},
+			wantScope: "",
+		},
+		"subject without roles": {
+			opts: []types.TokenOption{types.WithSubject("user-2")},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			token, err := svc.Token(ctx, nil, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in, _ := next.Introspect(ctx, token)
+			got, _ := in.Claims["roles"].([]any)
+			if !slices.Equal(got, tt.wantRoles) {
+				t.Errorf("roles are not the same: want=%v, got=%v", tt.wantRoles, in.Claims["roles"])
+			}
+			if in.Claims["scope"] != tt.wantScope {
+				t.Errorf("scopes are not the same: want=%v, got=%v", tt.wantScope, in.Claims["scope"])
+			}
+		})
+	}
+
+	if _, err := svc.Token(ctx, nil, types.WithSubject("user-2"), types.WithClaims(map[string]any{"scope": "*"})); err == nil {
+		t.Error("scope of custom claims shouldn't be accepted")
+	}
+}
+
+func TestRBACServiceAuthorize(t *testing.T) {
+	ctx := context.Background()
+	svc := NewRBACService(service.NewJWTService([]byte("secret")), roles, staticRoles{"user-1": {"billing", "viewer"}})
+	token, _ := svc.Token(ctx, nil, types.WithSubject("user-1"))
+	narrowed, _ := svc.Token(ctx, nil, types.WithSubject("user-1"), types.WithServerClaims(map[string]any{"scope": "invoices:read"}))
+	other, _ := service.NewJWTService([]byte("other")).Token(ctx, nil, types.WithSubject("user-1"))
+
+	tests := map[string]struct {
+		token       []byte
+		permission  string
+		wantAllowed bool
+		wantReason  string
+		wantErr     bool
+	}{
+		"granted permission": {
+			token:       token,
+			permission:  "users:read",
+			wantAllowed: true,
+			wantReason:  "granted by scope users:read",
+		},
+		"wildcard permission": {
+			token:       token,
+			permission:  "invoices:write",
+			wantAllowed: true,
+			wantReason:  "granted by scope invoices:*",
+		},
+		"missing permission": {
+			token:      token,
+			permission: "users:write",
+			wantReason: "scope doesn't grant users:write",
+		},
+		"permission beyond scope": {
+			token:      narrowed,
+			permission: "invoices:write",
+			wantReason: "scope doesn't grant invoices:write",
+		},
+		"invalid token": {
+			token:      other,
+			permission: "users:read",
+			wantReason: "token not valid: " + types.ReasonBadSignature,
+		},
+		"no permission": {
+			token:   token,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := svc.Authorize(ctx, tt.token, tt.permission)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("authorization should fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Allowed != tt.wantAllowed || d.Reason != tt.wantReason {
+				t.Errorf("decisions are not the same: want=%v %q, got=%v %q", tt.wantAllowed, tt.wantReason, d.Allowed, d.Reason)
+			}
+		})
+	}
+}
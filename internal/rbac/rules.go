@@ -0,0 +1,66 @@
+package rbac
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// PathRule requires a permission of requests of the path.
+type PathRule struct {
+	// Path matches the path exactly, or also all paths
+	// under it if it ends with a slash, e.g. /invoices/.
+	Path string `json:"path"`
+	// Methods limit the rule to the request methods, it applies to all if empty.
+	Methods []string `json:"methods,omitempty"`
+	// Permission the token scope must grant, any valid token passes if empty.
+	Permission string `json:"permission,omitempty"`
+}
+
+// PathRules of requests, the rule of the longest matching path applies.
+// Among rules of the same path, those listing the method take precedence.
+type PathRules []PathRule
+
+// LoadPathRules reads a JSON array of path rules from the file.
+func LoadPathRules(path string) (PathRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules PathRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Match returns the rule of the request. The path is cleaned first,
+// so dot segments can't escape the path of a rule.
+func (rules PathRules) Match(method, p string) (*PathRule, bool) {
+	if p == "" {
+		p = "/"
+	}
+	p = path.Clean("/" + p)
+
+	var match *PathRule
+	for i, r := range rules {
+		if len(r.Methods) > 0 && !slices.Contains(r.Methods, method) {
+			continue
+		}
+		dir, subtree := strings.CutSuffix(r.Path, "/")
+		if p != r.Path && !(subtree && (p == dir || strings.HasPrefix(p, r.Path))) {
+			continue
+		}
+		// Rules of the methods are more specific than those of all methods.
+		if match == nil || len(r.Path) > len(match.Path) ||
+			len(r.Path) == len(match.Path) && len(match.Methods) == 0 && len(r.Methods) > 0 {
+			match = &rules[i]
+		}
+	}
+
+	return match, match != nil
+}
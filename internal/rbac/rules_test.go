@@ -0,0 +1,73 @@
+package rbac
+
+import "testing"
+
+func TestPathRulesMatch(t *testing.T) {
+	rules := PathRules{
+		{Path: "/", Permission: "api:use"},
+		{Path: "/invoices/", Permission: "invoices:read"},
+		{Path: "/invoices/", Methods: []string{"POST", "DELETE"}, Permission: "invoices:write"},
+		{Path: "/health"},
+	}
+
+	tests := map[string]struct {
+		method         string
+		path           string
+		wantPermission string
+	}{
+		"root": {
+			method:         "GET",
+			path:           "/",
+			wantPermission: "api:use",
+		},
+		"subtree": {
+			method:         "GET",
+			path:           "/invoices/42",
+			wantPermission: "invoices:read",
+		},
+		"subtree root": {
+			method:         "GET",
+			path:           "/invoices",
+			wantPermission: "invoices:read",
+		},
+		"method rule": {
+			method:         "POST",
+			path:           "/invoices/",
+			wantPermission: "invoices:write",
+		},
+		"exact path": {
+			method: "GET",
+			path:   "/health",
+		},
+		"below exact path": {
+			method:         "GET",
+			path:           "/health/db",
+			wantPermission: "api:use",
+		},
+		"dot segments": {
+			method:         "GET",
+			path:           "/invoices/../health/db",
+			wantPermission: "api:use",
+		},
+		"empty path": {
+			method:         "GET",
+			wantPermission: "api:use",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, ok := rules.Match(tt.method, tt.path)
+			if !ok {
+				t.Fatal("rule should match")
+			}
+			if r.Permission != tt.wantPermission {
+				t.Errorf("permissions are not the same: want=%q, got=%q", tt.wantPermission, r.Permission)
+			}
+		})
+	}
+
+	if _, ok := (PathRules{{Path: "/invoices/"}}).Match("GET", "/invoicesx"); ok {
+		t.Error("path with the same prefix shouldn't match")
+	}
+}
@@ -0,0 +1,185 @@
+package refresh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+var (
+	// Tokens keyed by family/id, so a family can be deleted by prefix.
+	tokensBucket = []byte("refresh_tokens")
+	// Index of token ids to their families.
+	familiesBucket = []byte("refresh_families")
+)
+
+// RefreshStore persisted in an embedded bolt database.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore creates a RefreshStore persisted in the bolt database.
+func NewBoltStore(db *bolt.DB) (types.RefreshStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{tokensBucket, familiesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+// Create stores a new refresh token.
+func (s *boltStore) Create(_ context.Context, rt *types.RefreshToken) error {
+	data, err := json.Marshal(rt)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(familiesBucket).Put([]byte(rt.ID), []byte(rt.Family)); err != nil {
+			return err
+		}
+		return tx.Bucket(tokensBucket).Put(tokenKey(rt.Family, rt.ID), data)
+	})
+}
+
+// Token returns the refresh token.
+func (s *boltStore) Token(_ context.Context, id string) (rt *types.RefreshToken, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		rt, _, err = getToken(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rt, nil
+}
+
+// Use marks the refresh token used.
+func (s *boltStore) Use(_ context.Context, id string) (rt *types.RefreshToken, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var key []byte
+		rt, key, err = getToken(tx, id)
+		if err != nil {
+			return err
+		}
+
+		used := *rt
+		used.Used = true
+		data, err := json.Marshal(&used)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(tokensBucket).Put(key, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rt, nil
+}
+
+// RevokeFamily deletes all tokens of the family.
+func (s *boltStore) RevokeFamily(_ context.Context, family string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		prefix := tokenKey(family, "")
+		var ids [][]byte
+		c := tx.Bucket(tokensBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			ids = append(ids, k[len(prefix):])
+		}
+
+		for _, id := range ids {
+			if err := tx.Bucket(tokensBucket).Delete(tokenKey(family, string(id))); err != nil {
+				return err
+			}
+			if err := tx.Bucket(familiesBucket).Delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RevokeSubject deletes tokens of the subject of families started before the time.
+func (s *boltStore) RevokeSubject(_ context.Context, sub string, before time.Time) error {
+	return s.deleteTokens(func(rt *types.RefreshToken) bool {
+		return rt.Options.Subject == sub && rt.AuthTime.Before(before)
+	})
+}
+
+// Purge removes expired tokens.
+func (s *boltStore) Purge(_ context.Context, now time.Time) error {
+	return s.deleteTokens(func(rt *types.RefreshToken) bool {
+		return rt.ExpiresAt.Before(now)
+	})
+}
+
+// Deletes the tokens that match.
+func (s *boltStore) deleteTokens(match func(*types.RefreshToken) bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var matched []types.RefreshToken
+		err := tx.Bucket(tokensBucket).ForEach(func(_, v []byte) error {
+			var rt types.RefreshToken
+			if err := json.Unmarshal(v, &rt); err != nil {
+				return err
+			}
+			if match(&rt) {
+				matched = append(matched, rt)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, rt := range matched {
+			if err := tx.Bucket(tokensBucket).Delete(tokenKey(rt.Family, rt.ID)); err != nil {
+				return err
+			}
+			if err := tx.Bucket(familiesBucket).Delete([]byte(rt.ID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Key of a token in the tokens bucket.
+func tokenKey(family, id string) []byte {
+	return []byte(family + "/" + id)
+}
+
+// Reads the token and its key in the transaction.
+func getToken(tx *bolt.Tx, id string) (*types.RefreshToken, []byte, error) {
+	family := tx.Bucket(familiesBucket).Get([]byte(id))
+	if family == nil {
+		return nil, nil, types.ErrNotFound
+	}
+
+	key := tokenKey(string(family), id)
+	data := tx.Bucket(tokensBucket).Get(key)
+	if data == nil {
+		return nil, nil, types.ErrNotFound
+	}
+
+	rt := new(types.RefreshToken)
+	if err := json.Unmarshal(data, rt); err != nil {
+		return nil, nil, err
+	}
+
+	return rt, key, nil
+}
@@ -0,0 +1,104 @@
+package refresh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// In-memory RefreshStore.
+type memoryStore struct {
+	mu     sync.Mutex
+	tokens map[string]types.RefreshToken
+}
+
+// NewMemoryStore creates an in-memory RefreshStore
+// that loses its tokens on restart, useful for tests.
+func NewMemoryStore() types.RefreshStore {
+	return &memoryStore{
+		tokens: make(map[string]types.RefreshToken),
+	}
+}
+
+// Create stores a new refresh token.
+func (s *memoryStore) Create(_ context.Context, rt *types.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[rt.ID] = *rt
+
+	return nil
+}
+
+// Token returns the refresh token.
+func (s *memoryStore) Token(_ context.Context, id string) (*types.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.tokens[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+
+	return &rt, nil
+}
+
+// Use marks the refresh token used.
+func (s *memoryStore) Use(_ context.Context, id string) (*types.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.tokens[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	used := rt
+	used.Used = true
+	s.tokens[id] = used
+
+	return &rt, nil
+}
+
+// RevokeFamily deletes all tokens of the family.
+func (s *memoryStore) RevokeFamily(_ context.Context, family string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, rt := range s.tokens {
+		if rt.Family == family {
+			delete(s.tokens, id)
+		}
+	}
+
+	return nil
+}
+
+// RevokeSubject deletes tokens of the subject of families started before the time.
+func (s *memoryStore) RevokeSubject(_ context.Context, sub string, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, rt := range s.tokens {
+		if rt.Options.Subject == sub && rt.AuthTime.Before(before) {
+			delete(s.tokens, id)
+		}
+	}
+
+	return nil
+}
+
+// Purge removes expired tokens.
+func (s *memoryStore) Purge(_ context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, rt := range s.tokens {
+		if rt.ExpiresAt.Before(now) {
+			delete(s.tokens, id)
+		}
+	}
+
+	return nil
+}
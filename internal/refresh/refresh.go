@@ -0,0 +1,173 @@
+package refresh
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("refresh token not valid")
+	errRefreshTokenReused  = errors.New("refresh token reused, the session is revoked")
+)
+
+const (
+	// Default lifetime of access tokens of a pair.
+	defaultAccessTTL = 15 * time.Minute
+	// Default lifetime of refresh tokens.
+	defaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// RefreshService issues token pairs with the next TokenService
+// and rotates refresh tokens on every refresh.
+type RefreshService struct {
+	svc        types.TokenService
+	store      types.RefreshStore
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+// NewRefreshService creates a Refresher issuing access tokens with the TokenService.
+// Zero lifetimes mean 15 minutes for access tokens and 30 days for refresh tokens.
+func NewRefreshService(svc types.TokenService, store types.RefreshStore, accessTTL, refreshTTL time.Duration) *RefreshService {
+	if accessTTL <= 0 {
+		accessTTL = defaultAccessTTL
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = defaultRefreshTTL
+	}
+
+	return &RefreshService{
+		svc:        svc,
+		store:      store,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		now:        time.Now,
+	}
+}
+
+// TokenPair issues an access token and starts a new family of refresh tokens.
+func (s *RefreshService) TokenPair(ctx context.Context, payload []byte, opts ...types.TokenOption) (*types.TokenPair, error) {
+	o := types.NewTokenOptions(opts...)
+	if o.TTL == 0 {
+		o.TTL = s.accessTTL
+	}
+
+	return s.issue(ctx, uuid.NewString(), s.now(), payload, o)
+}
+
+// Refresh exchanges the refresh token for a new pair. Each refresh token
+// is single-use, presenting a used one revokes the whole family.
+func (s *RefreshService) Refresh(ctx context.Context, refreshToken []byte) (*types.TokenPair, error) {
+	rt, err := s.store.Use(ctx, hash(refreshToken))
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if rt.Used {
+		if err := s.store.RevokeFamily(ctx, rt.Family); err != nil {
+			return nil, err
+		}
+		return nil, errRefreshTokenReused
+	}
+	if s.now().After(rt.ExpiresAt) {
+		return nil, errInvalidRefreshToken
+	}
+
+	return s.issue(ctx, rt.Family, rt.AuthTime, rt.Payload, rt.Options)
+}
+
+// Revoke revokes the refresh token with its whole family if it is
+// bound to the client or to none. Unknown tokens are ignored.
+func (s *RefreshService) Revoke(ctx context.Context, refreshToken []byte, clientID string) error {
+	rt, err := s.store.Token(ctx, hash(refreshToken))
+	if errors.Is(err, types.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if rt.ClientID != "" && rt.ClientID != clientID {
+		return types.ErrOtherClient
+	}
+
+	return s.store.RevokeFamily(ctx, rt.Family)
+}
+
+// RevokeSubject revokes refresh tokens of the subject of families started
+// before the time, so they can't outlive a revocation of its access tokens.
+func (s *RefreshService) RevokeSubject(ctx context.Context, sub string, before time.Time) error {
+	return s.store.RevokeSubject(ctx, sub, before)
+}
+
+// Issues an access token and a refresh token of the family started at authTime.
+func (s *RefreshService) issue(ctx context.Context, family string, authTime time.Time, payload []byte, o types.TokenOptions) (*types.TokenPair, error) {
+	access, err := s.svc.Token(ctx, payload, func(t *types.TokenOptions) { *t = o })
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, _ := o.ServerClaims["client_id"].(string)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(secret)
+
+	err = s.store.Create(ctx, &types.RefreshToken{
+		ID:        hash([]byte(refresh)),
+		Family:    family,
+		Payload:   payload,
+		Options:   o,
+		ClientID:  clientID,
+		AuthTime:  authTime,
+		ExpiresAt: s.now().Add(s.refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.TokenPair{
+		AccessToken:  string(access),
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(o.TTL / time.Second),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// RunGC purges expired refresh tokens from the store
+// every interval until the context is done.
+func (s *RefreshService) RunGC(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.store.Purge(ctx, s.now()); err != nil {
+				log.Printf("couldn't purge refresh tokens: %v", err)
+			}
+		}
+	}
+}
+
+// Refresh tokens are stored only as hashes.
+func hash(token []byte) string {
+	sum := sha256.Sum256(token)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,113 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func newStores(t *testing.T) map[string]types.RefreshStore {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "auth.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	boltStore, err := NewBoltStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]types.RefreshStore{
+		"memory": NewMemoryStore(),
+		"bolt":   boltStore,
+	}
+}
+
+func TestRefreshService(t *testing.T) {
+	ctx := context.Background()
+	jwtSvc := service.NewJWTService([]byte("secret"))
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc := NewRefreshService(jwtSvc, store, time.Minute, time.Hour)
+
+			first, err := svc.TokenPair(ctx, []byte("some payload"), types.WithSubject("user-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.RefreshToken == "" || first.ExpiresIn != 60 {
+				t.Fatalf("unexpected pair: %+v", first)
+			}
+
+			second, err := svc.Refresh(ctx, []byte(first.RefreshToken))
+			if err != nil {
+				t.Fatalf("refresh should succeed: %v", err)
+			}
+			in, _ := jwtSvc.Introspect(ctx, []byte(second.AccessToken))
+			if !in.Valid || in.Claims["sub"] != "user-1" || in.Claims["payload"] != "some payload" {
+				t.Errorf("refreshed access token should keep claims: %+v", in)
+			}
+
+			if _, err := svc.Refresh(ctx, []byte(first.RefreshToken)); err == nil {
+				t.Error("reused refresh token shouldn't be accepted")
+			}
+			if _, err := svc.Refresh(ctx, []byte(second.RefreshToken)); err == nil {
+				t.Error("reuse should revoke the whole family")
+			}
+
+			if _, err := svc.Refresh(ctx, []byte("some-random-text")); err == nil {
+				t.Error("unknown refresh token shouldn't be accepted")
+			}
+
+			bound, _ := svc.TokenPair(ctx, nil, types.WithSubject("billing"), types.WithServerClaims(map[string]any{"client_id": "billing"}))
+			if err := svc.Revoke(ctx, []byte(bound.RefreshToken), "gateway"); !errors.Is(err, types.ErrOtherClient) {
+				t.Errorf("other client shouldn't revoke the refresh token: %v", err)
+			}
+			bound, err = svc.Refresh(ctx, []byte(bound.RefreshToken))
+			if err != nil {
+				t.Fatalf("refresh token should stay valid: %v", err)
+			}
+			if err := svc.Revoke(ctx, []byte(bound.RefreshToken), "billing"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.Refresh(ctx, []byte(bound.RefreshToken)); err == nil {
+				t.Error("revoked refresh token shouldn't be accepted")
+			}
+
+			// Revoking a subject revokes families started before the time,
+			// even their tokens issued by later refreshes.
+			session, _ := svc.TokenPair(ctx, nil, types.WithSubject("user-2"))
+			other, _ := svc.TokenPair(ctx, nil, types.WithSubject("user-3"))
+			svc.now = func() time.Time { return time.Now().Add(time.Minute) }
+			session, _ = svc.Refresh(ctx, []byte(session.RefreshToken))
+			started, _ := svc.TokenPair(ctx, nil, types.WithSubject("user-2"))
+			if err := svc.RevokeSubject(ctx, "user-2", time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.Refresh(ctx, []byte(session.RefreshToken)); err == nil {
+				t.Error("refresh token of a revoked subject shouldn't be accepted")
+			}
+			if _, err := svc.Refresh(ctx, []byte(started.RefreshToken)); err != nil {
+				t.Errorf("family started after the revocation should stay valid: %v", err)
+			}
+			if _, err := svc.Refresh(ctx, []byte(other.RefreshToken)); err != nil {
+				t.Errorf("refresh token of another subject should stay valid: %v", err)
+			}
+
+			third, _ := svc.TokenPair(ctx, []byte("some payload"))
+			svc.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+			if _, err := svc.Refresh(ctx, []byte(third.RefreshToken)); err == nil {
+				t.Error("expired refresh token shouldn't be accepted")
+			}
+		})
+	}
+}
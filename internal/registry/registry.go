@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danblok/auth/internal/rbac"
+	"github.com/danblok/auth/pkg/types"
+)
+
+// TokenTTL is the lifetime of registry tokens.
+const TokenTTL = 5 * time.Minute
+
+// Type of resources the rules grant access to.
+const typeRepository = "repository"
+
+// Prefix of permissions of scoped callers, e.g. registry:pull.
+const permissionPrefix = "registry:"
+
+var errMalformedScope = errors.New("malformed scope")
+
+// Access is a resource and the actions on it, an entry of
+// the scope of requests and of the access claim of tokens.
+type Access struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// ParseScope parses a scope of the form type:name:actions,
+// e.g. repository:samalba/my-app:pull,push. Names may contain
+// colons, e.g. of a registry with a port.
+func ParseScope(scope string) (Access, error) {
+	typ, rest, ok := strings.Cut(scope, ":")
+	i := strings.LastIndex(rest, ":")
+	if !ok || i < 0 || typ == "" || rest[:i] == "" {
+		return Access{}, fmt.Errorf("%w %q", errMalformedScope, scope)
+	}
+
+	var actions []string
+	for _, a := range strings.Split(rest[i+1:], ",") {
+		if a != "" && !slices.Contains(actions, a) {
+			actions = append(actions, a)
+		}
+	}
+
+	return Access{Type: typ, Name: rest[:i], Actions: actions}, nil
+}
+
+// Rule grants actions on repositories to callers.
+type Rule struct {
+	// Repository matches the name exactly, or by prefix
+	// if it ends with *, e.g. team/* matches team/app.
+	Repository string `json:"repository"`
+	// Subjects the rule applies to, * applies to all authenticated callers.
+	Subjects []string `json:"subjects,omitempty"`
+	// Roles the rule applies to, any of them is enough.
+	Roles []string `json:"roles,omitempty"`
+	// Public rules also apply to anonymous callers.
+	Public bool `json:"public,omitempty"`
+	// Actions granted on the repositories, * grants all.
+	Actions []string `json:"actions"`
+}
+
+// Tells whether the rule applies to the caller.
+func (r Rule) appliesTo(c Caller) bool {
+	if r.Public {
+		return true
+	}
+	if c.Subject == "" {
+		return false
+	}
+	if slices.Contains(r.Subjects, "*") || slices.Contains(r.Subjects, c.Subject) {
+		return true
+	}
+
+	return slices.ContainsFunc(r.Roles, func(role string) bool {
+		return slices.Contains(c.Roles, role)
+	})
+}
+
+// Tells whether the rule matches the repository name.
+func (r Rule) matches(name string) bool {
+	prefix, wildcard := strings.CutSuffix(r.Repository, "*")
+	return r.Repository == name || wildcard && strings.HasPrefix(name, prefix)
+}
+
+// ACL of repositories, actions of all matching rules are granted.
+type ACL []Rule
+
+// LoadACL reads a JSON array of rules from the file.
+func LoadACL(path string) (ACL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var acl ACL
+	if err := json.Unmarshal(data, &acl); err != nil {
+		return nil, err
+	}
+
+	return acl, nil
+}
+
+// Grant returns the requested actions granted to the caller,
+// in the order of the request. Only repositories are granted,
+// and scoped callers only the actions their scope permits.
+func (acl ACL) Grant(c Caller, requested Access) []string {
+	if requested.Type != typeRepository {
+		return nil
+	}
+
+	var granted []string
+	for _, r := range acl {
+		if r.appliesTo(c) && r.matches(requested.Name) {
+			granted = append(granted, r.Actions...)
+		}
+	}
+
+	var actions []string
+	for _, a := range requested.Actions {
+		if !slices.Contains(granted, "*") && !slices.Contains(granted, a) {
+			continue
+		}
+		if _, ok := rbac.Grant(c.Scopes, permissionPrefix+a); c.Scopes != nil && !ok {
+			continue
+		}
+		actions = append(actions, a)
+	}
+
+	return actions
+}
+
+// Caller of the token endpoint, anonymous if Subject is empty.
+type Caller struct {
+	Subject string
+	Roles   []string
+	// Scopes of the credential limit the actions by permissions
+	// like registry:pull, nil if the credential isn't scoped.
+	Scopes []string
+}
+
+// Token is the response of the token endpoint of the
+// Docker registry token authentication specification.
+type Token struct {
+	Token string `json:"token"`
+	// AccessToken equals Token, for OAuth 2.0 compatibility.
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int64     `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+// Issuer issues tokens of the registry service with the
+// access claim listing the requested actions the ACL grants.
+type Issuer struct {
+	svc     types.TokenService
+	service string
+	acl     ACL
+}
+
+// NewIssuer creates an issuer of tokens for the registry service. The
+// TokenService should sign them with the key the registry trusts.
+func NewIssuer(svc types.TokenService, service string, acl ACL) *Issuer {
+	return &Issuer{
+		svc:     svc,
+		service: service,
+		acl:     acl,
+	}
+}
+
+// Service returns the name of the registry service, the audience of tokens.
+func (i *Issuer) Service() string {
+	return i.service
+}
+
+// Token issues a token of the caller granting the scopes. Denied
+// actions are left out, a token of no access is still issued.
+func (i *Issuer) Token(ctx context.Context, c Caller, scopes []string) (*Token, error) {
+	access := []Access{}
+	for _, scope := range scopes {
+		requested, err := ParseScope(scope)
+		if err != nil {
+			return nil, err
+		}
+		if actions := i.acl.Grant(c, requested); len(actions) > 0 {
+			access = append(access, Access{Type: requested.Type, Name: requested.Name, Actions: actions})
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	token, err := i.svc.Token(ctx, nil,
+		types.WithSubject(c.Subject),
+		types.WithAudience(i.service),
+		types.WithTTL(TokenTTL),
+		types.WithServerClaims(map[string]any{"access": access}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		Token:       string(token),
+		AccessToken: string(token),
+		ExpiresIn:   int64(TokenTTL / time.Second),
+		IssuedAt:    now,
+	}, nil
+}
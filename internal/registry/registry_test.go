@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/danblok/auth/internal/service"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := map[string]struct {
+		scope   string
+		want    Access
+		wantErr bool
+	}{
+		"repository": {
+			scope: "repository:samalba/my-app:pull,push",
+			want:  Access{Type: "repository", Name: "samalba/my-app", Actions: []string{"pull", "push"}},
+		},
+		"name with port": {
+			scope: "repository:localhost:5000/my-app:pull",
+			want:  Access{Type: "repository", Name: "localhost:5000/my-app", Actions: []string{"pull"}},
+		},
+		"duplicate actions": {
+			scope: "repository:my-app:pull,,pull",
+			want:  Access{Type: "repository", Name: "my-app", Actions: []string{"pull"}},
+		},
+		"no actions": {
+			scope:   "repository:my-app",
+			wantErr: true,
+		},
+		"no name": {
+			scope:   "repository::pull",
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseScope(tt.scope)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("access is not the same: want=%v, got=%v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestACLGrant(t *testing.T) {
+	acl := ACL{
+		{Repository: "public/*", Public: true, Actions: []string{"pull"}},
+		{Repository: "team/*", Subjects: []string{"*"}, Actions: []string{"pull"}},
+		{Repository: "team/*", Roles: []string{"developer"}, Actions: []string{"push"}},
+		{Repository: "jane/app", Subjects: []string{"jane"}, Actions: []string{"*"}},
+	}
+	jane := Caller{Subject: "jane"}
+	dev := Caller{Subject: "john", Roles: []string{"developer"}}
+
+	tests := map[string]struct {
+		caller    Caller
+		requested Access
+		want      []string
+	}{
+		"anonymous pull of public": {
+			requested: Access{Type: "repository", Name: "public/app", Actions: []string{"pull", "push"}},
+			want:      []string{"pull"},
+		},
+		"anonymous pull of team": {
+			requested: Access{Type: "repository", Name: "team/app", Actions: []string{"pull"}},
+		},
+		"any authenticated": {
+			caller:    jane,
+			requested: Access{Type: "repository", Name: "team/app", Actions: []string{"pull", "push"}},
+			want:      []string{"pull"},
+		},
+		"union of roles": {
+			caller:    dev,
+			requested: Access{Type: "repository", Name: "team/app", Actions: []string{"push", "pull"}},
+			want:      []string{"push", "pull"},
+		},
+		"all actions": {
+			caller:    jane,
+			requested: Access{Type: "repository", Name: "jane/app", Actions: []string{"pull", "push", "delete"}},
+			want:      []string{"pull", "push", "delete"},
+		},
+		"scoped caller": {
+			caller:    Caller{Subject: "jane", Scopes: []string{"registry:pull", "orders:read"}},
+			requested: Access{Type: "repository", Name: "jane/app", Actions: []string{"pull", "push"}},
+			want:      []string{"pull"},
+		},
+		"wildcard scope": {
+			caller:    Caller{Subject: "jane", Scopes: []string{"registry:*"}},
+			requested: Access{Type: "repository", Name: "jane/app", Actions: []string{"pull", "push"}},
+			want:      []string{"pull", "push"},
+		},
+		"scope without registry permissions": {
+			caller:    Caller{Subject: "jane", Scopes: []string{}},
+			requested: Access{Type: "repository", Name: "jane/app", Actions: []string{"pull"}},
+		},
+		"other subject": {
+			caller:    dev,
+			requested: Access{Type: "repository", Name: "jane/app", Actions: []string{"pull"}},
+		},
+		"other type": {
+			caller:    jane,
+			requested: Access{Type: "registry", Name: "catalog", Actions: []string{"*"}},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := acl.Grant(tt.caller, tt.requested); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("actions are not the same: want=%v, got=%v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIssuerToken(t *testing.T) {
+	ctx := context.Background()
+	svc := service.NewJWTService([]byte("secret-key"), service.WithAudience("registry.example.com"))
+	issuer := NewIssuer(svc, "registry.example.com", ACL{{Repository: "jane/*", Subjects: []string{"jane"}, Actions: []string{"pull"}}})
+
+	tkn, err := issuer.Token(ctx, Caller{Subject: "jane"}, []string{"repository:jane/app:pull,push", "repository:john/app:pull"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tkn.Token != tkn.AccessToken || tkn.ExpiresIn != int64(TokenTTL.Seconds()) {
+		t.Errorf("unexpected token response: %+v", tkn)
+	}
+
+	in, err := svc.Introspect(ctx, []byte(tkn.Token))
+	if err != nil || !in.Valid {
+		t.Fatalf("token should be valid: %v %v", in, err)
+	}
+	want := []any{map[string]any{"type": "repository", "name": "jane/app", "actions": []any{"pull"}}}
+	if !reflect.DeepEqual(in.Claims["access"], want) {
+		t.Errorf("access claims are not the same: want=%v, got=%v", want, in.Claims["access"])
+	}
+	if in.Claims["sub"] != "jane" {
+		t.Errorf("subjects are not the same: want=%q, got=%v", "jane", in.Claims["sub"])
+	}
+
+	if _, err := issuer.Token(ctx, Caller{}, []string{"repository"}); err == nil {
+		t.Error("malformed scope should be rejected")
+	}
+}
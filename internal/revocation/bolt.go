@@ -0,0 +1,131 @@
+package revocation
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+var (
+	idsBucket      = []byte("revoked_ids")
+	subjectsBucket = []byte("revoked_subjects")
+)
+
+// RevocationStore persisted in an embedded bolt database.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore creates a RevocationStore persisted in
+// the bolt database that survives restarts.
+func NewBoltStore(db *bolt.DB) (types.RevocationStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{idsBucket, subjectsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+// RevokeID revokes the token with the jti.
+func (s *boltStore) RevokeID(_ context.Context, jti string, expiresAt time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(idsBucket)
+		if prev := b.Get([]byte(jti)); prev != nil && decodeTime(prev).After(expiresAt) {
+			return nil
+		}
+		return b.Put([]byte(jti), encodeTime(expiresAt))
+	})
+}
+
+// RevokeSubject revokes tokens of the subject issued before the time.
+func (s *boltStore) RevokeSubject(_ context.Context, sub string, before, expiresAt time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(subjectsBucket)
+		e := subjectEntry{before: before, expiresAt: expiresAt}
+		if prev := b.Get([]byte(sub)); prev != nil {
+			e = mergeSubject(decodeSubject(prev), e)
+		}
+		return b.Put([]byte(sub), append(encodeTime(e.before), encodeTime(e.expiresAt)...))
+	})
+}
+
+// Revoked reports whether the token is revoked.
+func (s *boltStore) Revoked(_ context.Context, jti, sub string, issuedAt time.Time) (revoked bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if jti != "" && tx.Bucket(idsBucket).Get([]byte(jti)) != nil {
+			revoked = true
+			return nil
+		}
+		if v := tx.Bucket(subjectsBucket).Get([]byte(sub)); sub != "" && v != nil {
+			revoked = !issuedAt.After(decodeSubject(v).before)
+		}
+		return nil
+	})
+
+	return revoked, err
+}
+
+// Purge removes expired entries.
+func (s *boltStore) Purge(_ context.Context, now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := purgeBucket(tx.Bucket(idsBucket), func(v []byte) bool {
+			return decodeTime(v).Before(now)
+		})
+		if err != nil {
+			return err
+		}
+
+		return purgeBucket(tx.Bucket(subjectsBucket), func(v []byte) bool {
+			return decodeSubject(v).expiresAt.Before(now)
+		})
+	})
+}
+
+// Deletes entries of the bucket whose values are expired.
+func purgeBucket(b *bolt.Bucket, expired func([]byte) bool) error {
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		if expired(v) {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encodes time as big-endian unix nanoseconds.
+func encodeTime(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+// Decodes time encoded by encodeTime.
+func decodeTime(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
+
+// Decodes a subject entry of two encoded times.
+func decodeSubject(b []byte) subjectEntry {
+	return subjectEntry{before: decodeTime(b[:8]), expiresAt: decodeTime(b[8:16])}
+}
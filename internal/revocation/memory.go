@@ -0,0 +1,99 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// In-memory RevocationStore.
+type memoryStore struct {
+	mu       sync.RWMutex
+	ids      map[string]time.Time
+	subjects map[string]subjectEntry
+}
+
+// Revocation of the subject's tokens.
+type subjectEntry struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates an in-memory RevocationStore
+// that loses its entries on restart.
+func NewMemoryStore() types.RevocationStore {
+	return &memoryStore{
+		ids:      make(map[string]time.Time),
+		subjects: make(map[string]subjectEntry),
+	}
+}
+
+// RevokeID revokes the token with the jti.
+func (s *memoryStore) RevokeID(_ context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if exp, ok := s.ids[jti]; !ok || exp.Before(expiresAt) {
+		s.ids[jti] = expiresAt
+	}
+
+	return nil
+}
+
+// RevokeSubject revokes tokens of the subject issued before the time.
+func (s *memoryStore) RevokeSubject(_ context.Context, sub string, before, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subjects[sub] = mergeSubject(s.subjects[sub], subjectEntry{before: before, expiresAt: expiresAt})
+
+	return nil
+}
+
+// Revoked reports whether the token is revoked.
+func (s *memoryStore) Revoked(_ context.Context, jti, sub string, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.ids[jti]; ok && jti != "" {
+		return true, nil
+	}
+	if e, ok := s.subjects[sub]; ok && sub != "" {
+		return !issuedAt.After(e.before), nil
+	}
+
+	return false, nil
+}
+
+// Purge removes expired entries.
+func (s *memoryStore) Purge(_ context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for jti, exp := range s.ids {
+		if exp.Before(now) {
+			delete(s.ids, jti)
+		}
+	}
+	for sub, e := range s.subjects {
+		if e.expiresAt.Before(now) {
+			delete(s.subjects, sub)
+		}
+	}
+
+	return nil
+}
+
+// Keeps the latest revocation time and the longest expiration.
+func mergeSubject(prev, next subjectEntry) subjectEntry {
+	if prev.before.After(next.before) {
+		next.before = prev.before
+	}
+	if prev.expiresAt.After(next.expiresAt) {
+		next.expiresAt = prev.expiresAt
+	}
+
+	return next
+}
@@ -0,0 +1,141 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+var errNotValidToken = errors.New("token not valid")
+
+// Default period during which revoked ids
+// and subjects are kept in the store.
+const defaultMaxTTL = 24 * time.Hour
+
+// RevocationService is a TokenService that rejects
+// revoked tokens of the next TokenService.
+type RevocationService struct {
+	svc    types.TokenService
+	store  types.RevocationStore
+	maxTTL time.Duration
+	now    func() time.Time
+}
+
+// NewRevocationService creates revocation for TokenService. The maxTTL is the
+// longest lifetime of issued tokens, entries whose token expiration is unknown
+// are kept for that long.
+func NewRevocationService(svc types.TokenService, store types.RevocationStore, maxTTL time.Duration) *RevocationService {
+	if maxTTL <= 0 {
+		maxTTL = defaultMaxTTL
+	}
+
+	return &RevocationService{
+		svc:    svc,
+		store:  store,
+		maxTTL: maxTTL,
+		now:    time.Now,
+	}
+}
+
+// Token passes call to Token to the next TokenService implementator.
+func (s *RevocationService) Token(ctx context.Context, payload []byte, opts ...types.TokenOption) ([]byte, error) {
+	return s.svc.Token(ctx, payload, opts...)
+}
+
+// Validate validates the token with the next TokenService
+// implementator and rejects it if it is revoked.
+func (s *RevocationService) Validate(ctx context.Context, token []byte) error {
+	in, err := s.Introspect(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case in.Reason == types.ReasonRevoked:
+		return types.ErrTokenRevoked
+	case !in.Valid:
+		return fmt.Errorf("%w: %s", errNotValidToken, in.Reason)
+	}
+
+	return nil
+}
+
+// Introspect introspects the token with the next TokenService
+// implementator and marks it not valid if it is revoked.
+func (s *RevocationService) Introspect(ctx context.Context, token []byte) (*types.Introspection, error) {
+	in, err := s.svc.Introspect(ctx, token)
+	if err != nil || !in.Valid {
+		return in, err
+	}
+
+	jti, _ := in.Claims["jti"].(string)
+	sub, _ := in.Claims["sub"].(string)
+	revoked, err := s.store.Revoked(ctx, jti, sub, time.Unix(in.IssuedAt, 0))
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return &types.Introspection{Valid: false, Reason: types.ReasonRevoked}, nil
+	}
+
+	return in, nil
+}
+
+// RevokeToken revokes the valid token until it expires.
+func (s *RevocationService) RevokeToken(ctx context.Context, token []byte) error {
+	in, err := s.svc.Introspect(ctx, token)
+	if err != nil {
+		return err
+	}
+	if !in.Valid {
+		return fmt.Errorf("%w: %s", errNotValidToken, in.Reason)
+	}
+
+	jti, _ := in.Claims["jti"].(string)
+	if jti == "" {
+		return errors.New("token has no jti claim")
+	}
+
+	return s.store.RevokeID(ctx, jti, time.Unix(in.ExpiresAt, 0))
+}
+
+// RevokeID revokes the token with the jti. As its expiration
+// is unknown the entry is kept for the longest token lifetime.
+func (s *RevocationService) RevokeID(ctx context.Context, jti string) error {
+	if jti == "" {
+		return errors.New("jti not provided")
+	}
+
+	return s.store.RevokeID(ctx, jti, s.now().Add(s.maxTTL))
+}
+
+// RevokeSubject revokes all tokens of the subject issued before the time.
+func (s *RevocationService) RevokeSubject(ctx context.Context, sub string, before time.Time) error {
+	if sub == "" {
+		return errors.New("subject not provided")
+	}
+
+	return s.store.RevokeSubject(ctx, sub, before, before.Add(s.maxTTL))
+}
+
+// RunGC purges entries of expired tokens from the store
+// every interval until the context is done.
+func (s *RevocationService) RunGC(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.store.Purge(ctx, s.now()); err != nil {
+				log.Printf("couldn't purge revoked tokens: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/danblok/auth/internal/service"
+	"github.com/danblok/auth/pkg/types"
+)
+
+func newStores(t *testing.T) map[string]types.RevocationStore {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "auth.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	boltStore, err := NewBoltStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]types.RevocationStore{
+		"memory": NewMemoryStore(),
+		"bolt":   boltStore,
+	}
+}
+
+func TestRevocationService(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc := NewRevocationService(service.NewJWTService([]byte("secret")), store, time.Hour)
+
+			tkn, _ := svc.Token(ctx, []byte("some payload"), types.WithSubject("user-1"))
+			other, _ := svc.Token(ctx, []byte("some payload"), types.WithSubject("user-2"))
+
+			if err := svc.RevokeToken(ctx, tkn); err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.Validate(ctx, tkn); !errors.Is(err, types.ErrTokenRevoked) {
+				t.Errorf("revoked token shouldn't be valid: %v", err)
+			}
+			if in, _ := svc.Introspect(ctx, tkn); in.Valid || in.Reason != types.ReasonRevoked {
+				t.Errorf("introspection should report revocation: %+v", in)
+			}
+			if err := svc.Validate(ctx, other); err != nil {
+				t.Errorf("other token should be valid: %v", err)
+			}
+
+			if err := svc.RevokeSubject(ctx, "user-2", time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.Validate(ctx, other); !errors.Is(err, types.ErrTokenRevoked) {
+				t.Errorf("token of revoked subject shouldn't be valid: %v", err)
+			}
+
+			// Tokens issued after the revocation time stay valid.
+			later := time.Now().Add(2 * time.Second)
+			if revoked, _ := store.Revoked(ctx, "", "user-2", later); revoked {
+				t.Error("token issued after revocation shouldn't be revoked")
+			}
+
+			if err := store.Purge(ctx, time.Now().Add(2*time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.Validate(ctx, other); err != nil {
+				t.Errorf("purged subject revocation shouldn't apply: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Static set of verification keys.
+type keySet struct {
+	keys []*Key
+}
+
+// NewKeySet creates a KeyProvider that publishes
+// public halves of the given keys. HMAC keys are never published.
+func NewKeySet(keys ...*Key) types.KeyProvider {
+	return &keySet{keys: keys}
+}
+
+// Keys returns the JWK set of the keys.
+func (s *keySet) Keys(_ context.Context) (*types.JWKSet, error) {
+	set := &types.JWKSet{Keys: []types.JWK{}}
+	for _, k := range s.keys {
+		if jwk, ok := k.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set, nil
+}
+
+// JWK returns the public verification key as a JWK.
+// It returns false for symmetric keys which must never be published.
+func (k *Key) JWK() (types.JWK, bool) {
+	jwk := types.JWK{
+		KeyID:     k.ID,
+		Algorithm: k.Method.Alg(),
+		Use:       "sig",
+	}
+
+	switch pub := k.verify.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return types.JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// Computes RFC 7638 thumbprint of the public key.
+// Symmetric keys are identified by a hash of the secret.
+func thumbprint(k *Key) string {
+	jwk, ok := k.JWK()
+	if !ok {
+		secret, _ := k.verify.([]byte)
+		sum := sha256.Sum256(append([]byte("hmac:"), secret...))
+		return b64(sum[:12])
+	}
+
+	// Members must be in lexicographic order, which is
+	// what encoding/json does for maps.
+	members := map[string]string{"kty": jwk.KeyType}
+	switch jwk.KeyType {
+	case "RSA":
+		members["e"], members["n"] = jwk.E, jwk.N
+	case "EC":
+		members["crv"], members["x"], members["y"] = jwk.Curve, jwk.X, jwk.Y
+	case "OKP":
+		members["crv"], members["x"] = jwk.Curve, jwk.X
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+
+	return b64(sum[:])
+}
+
+// Encodes to unpadded base64url.
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
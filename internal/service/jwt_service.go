@@ -2,17 +2,50 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 
 	"github.com/danblok/auth/pkg/types"
 )
 
-var errNotVaildToken = errors.New("token not valid")
+var (
+	errNotVaildToken      = errors.New("token not valid")
+	errTTLTooLong         = errors.New("requested token lifetime exceeds the maximum")
+	errAudienceNotAllowed = errors.New("requested audience is not allowed")
+	errReservedClaim      = errors.New("custom claim is reserved")
+	errClaimsTooLarge     = errors.New("custom claims are too large")
+	errNoClientID         = errors.New("id token requires a client id")
+	errIDToken            = errors.New("id token is not an access token")
+)
+
+const (
+	// Default lifetime of issued tokens.
+	defaultTTL = 24 * time.Hour
+	// Default limit of JSON encoded custom claims.
+	defaultMaxClaimsSize = 4096
+)
+
+// Claims set by the service which custom claims can't overwrite.
+var reservedClaims = []string{
+	"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "payload",
+	"nonce", "auth_time", "at_hash", "azp", "act",
+}
+
+// Authorization claims only the server sets through WithServerClaims,
+// so custom claims of requests can't forge them.
+var serverClaims = []string{
+	"scope", "roles", "client_id", "groups", "access", "api_key", "amr",
+}
 
-// JWTClaim that supports payload.
+// JWTClaim that supports payload. Issued tokens may
+// also carry custom top-level claims.
 type JWTClaim struct {
 	Payload string `json:"payload"`
 	jwt.RegisteredClaims
@@ -20,47 +53,315 @@ type JWTClaim struct {
 
 // TokenService implementation.
 type jwtTokenService struct {
-	key []byte
+	keys     *Keyring
+	issuer   string
+	audience []string
+	subject  string
+	ttl      time.Duration
+	maxTTL   time.Duration
+	leeway   time.Duration
+	// Limit of JSON encoded custom claims in bytes.
+	maxClaimsSize int
 }
 
-// NewJWTService creates a JWT TokenService implementation.
-func NewJWTService(key []byte) types.TokenService {
-	return &jwtTokenService{
-		key: key,
+// JWTOption configures server-level defaults of the JWT TokenService.
+type JWTOption func(*jwtTokenService)
+
+// WithIssuer sets the iss claim of issued tokens
+// and requires it on validated tokens.
+func WithIssuer(iss string) JWTOption {
+	return func(s *jwtTokenService) {
+		s.issuer = iss
+	}
+}
+
+// WithAudience sets the default aud claim of issued tokens to the first
+// audience. Per-request audiences must be one of the given ones and
+// validated tokens must be issued for at least one of them.
+func WithAudience(aud ...string) JWTOption {
+	return func(s *jwtTokenService) {
+		s.audience = aud
 	}
 }
 
+// WithSubject sets the default sub claim of issued tokens.
+func WithSubject(sub string) JWTOption {
+	return func(s *jwtTokenService) {
+		s.subject = sub
+	}
+}
+
+// WithTTL sets the default lifetime of issued tokens.
+func WithTTL(ttl time.Duration) JWTOption {
+	return func(s *jwtTokenService) {
+		s.ttl = ttl
+	}
+}
+
+// WithMaxTTL limits per-request lifetime of issued tokens.
+// It equals to the default lifetime if not set.
+func WithMaxTTL(ttl time.Duration) JWTOption {
+	return func(s *jwtTokenService) {
+		s.maxTTL = ttl
+	}
+}
+
+// WithLeeway sets the allowed clock skew when validating time based claims.
+func WithLeeway(leeway time.Duration) JWTOption {
+	return func(s *jwtTokenService) {
+		s.leeway = leeway
+	}
+}
+
+// WithMaxClaimsSize limits the size of JSON encoded custom claims.
+func WithMaxClaimsSize(size int) JWTOption {
+	return func(s *jwtTokenService) {
+		s.maxClaimsSize = size
+	}
+}
+
+// NewJWTService creates a JWT TokenService implementation
+// that signs tokens with the HS256 secret.
+func NewJWTService(key []byte, opts ...JWTOption) types.TokenService {
+	return NewJWTServiceWithKey(NewHMACKey(key), opts...)
+}
+
+// NewJWTServiceWithKey creates a JWT TokenService implementation
+// that signs and verifies tokens with the given key.
+// A service created from a public key can only validate tokens.
+func NewJWTServiceWithKey(key *Key, opts ...JWTOption) types.TokenService {
+	return NewJWTServiceWithKeyring(NewKeyring(key), opts...)
+}
+
+// NewJWTServiceWithKeyring creates a JWT TokenService implementation
+// that signs tokens with the current key of the keyring and
+// verifies them with the key matching their kid header.
+func NewJWTServiceWithKeyring(keys *Keyring, opts ...JWTOption) types.TokenService {
+	s := &jwtTokenService{
+		keys:          keys,
+		ttl:           defaultTTL,
+		maxClaimsSize: defaultMaxClaimsSize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.maxTTL < s.ttl {
+		s.maxTTL = s.ttl
+	}
+
+	return s
+}
+
 // Validates given token.
 func (s jwtTokenService) Validate(_ context.Context, token []byte) error {
-	tkn, err := jwt.Parse(string(token), func(_ *jwt.Token) (interface{}, error) {
-		return []byte(s.key), nil
-	}, jwt.WithValidMethods([]string{"HS256"}))
+	_, err := s.parse(token)
+	return err
+}
+
+// Introspects given token and returns its verified claims.
+func (s jwtTokenService) Introspect(_ context.Context, token []byte) (*types.Introspection, error) {
+	tkn, err := s.parse(token)
 	if err != nil {
-		return err
+		return &types.Introspection{Valid: false, Reason: reason(err)}, nil
 	}
 
-	if !tkn.Valid {
-		return errNotVaildToken
+	claims, ok := tkn.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errNotVaildToken
+	}
+
+	in := &types.Introspection{
+		Valid:  true,
+		Claims: claims,
+	}
+	if exp, _ := claims.GetExpirationTime(); exp != nil {
+		in.ExpiresAt = exp.Unix()
+	}
+	if iat, _ := claims.GetIssuedAt(); iat != nil {
+		in.IssuedAt = iat.Unix()
 	}
+	in.KeyID, _ = tkn.Header["kid"].(string)
 
-	return nil
+	return in, nil
 }
 
 // Issues new token with given body.
-func (s jwtTokenService) Token(_ context.Context, payload []byte) ([]byte, error) {
-	claims := &JWTClaim{
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt:  &jwt.NumericDate{Time: time.Now()},
-			ExpiresAt: &jwt.NumericDate{Time: time.Now().AddDate(0, 0, 1)},
-		},
-		Payload: string(payload),
+func (s jwtTokenService) Token(_ context.Context, payload []byte, opts ...types.TokenOption) ([]byte, error) {
+	key, err := s.keys.Signing()
+	if err != nil {
+		return nil, err
+	}
+	if !key.CanSign() {
+		return nil, errVerifyOnly
+	}
+
+	o := types.NewTokenOptions(opts...)
+	ttl := s.ttl
+	if o.TTL > 0 {
+		if o.TTL > s.maxTTL {
+			return nil, fmt.Errorf("%w: %v", errTTLTooLong, s.maxTTL)
+		}
+		ttl = o.TTL
+	}
+
+	aud := s.audience
+	if len(s.audience) > 0 {
+		aud = s.audience[:1]
+	}
+	if o.IDToken != nil {
+		if o.IDToken.ClientID == "" {
+			return nil, errNoClientID
+		}
+		aud = []string{o.IDToken.ClientID}
+	} else if len(o.Audience) > 0 {
+		for _, a := range o.Audience {
+			if len(s.audience) > 0 && !slices.Contains(s.audience, a) {
+				return nil, fmt.Errorf("%w: %s", errAudienceNotAllowed, a)
+			}
+		}
+		aud = o.Audience
+	}
+
+	sub := s.subject
+	if o.Subject != "" {
+		sub = o.Subject
 	}
 
-	tkn := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := tkn.SignedString(s.key)
+	claims, err := s.customClaims(o.Claims, o.ServerClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims["jti"] = uuid.NewString()
+	claims["iat"] = jwt.NewNumericDate(now)
+	claims["nbf"] = jwt.NewNumericDate(now)
+	claims["exp"] = jwt.NewNumericDate(now.Add(ttl))
+	if s.issuer != "" {
+		claims["iss"] = s.issuer
+	}
+	if sub != "" {
+		claims["sub"] = sub
+	}
+	if len(aud) > 0 {
+		claims["aud"] = jwt.ClaimStrings(aud)
+	}
+	if len(payload) > 0 {
+		claims["payload"] = string(payload)
+	}
+	if len(o.Actor) > 0 {
+		claims["act"] = o.Actor
+	}
+	if o.IDToken != nil {
+		if err := idTokenClaims(claims, key.Method, o.IDToken); err != nil {
+			return nil, err
+		}
+	}
+
+	tkn := jwt.NewWithClaims(key.Method, claims)
+	tkn.Header["kid"] = key.ID
+	if len(key.Certificates) > 0 {
+		x5c := make([]string, 0, len(key.Certificates))
+		for _, cert := range key.Certificates {
+			x5c = append(x5c, base64.StdEncoding.EncodeToString(cert))
+		}
+		tkn.Header["x5c"] = x5c
+	}
+	ss, err := tkn.SignedString(key.sign)
 	if err != nil {
 		return nil, err
 	}
 
 	return []byte(ss), nil
 }
+
+// Checks custom claims and claims set by the server,
+// and copies them into new claims of a token.
+func (s jwtTokenService) customClaims(custom, server map[string]any) (jwt.MapClaims, error) {
+	claims := make(jwt.MapClaims, len(custom)+len(server)+len(reservedClaims))
+	for _, name := range reservedClaims {
+		_, inCustom := custom[name]
+		_, inServer := server[name]
+		if inCustom || inServer {
+			return nil, fmt.Errorf("%w: %s", errReservedClaim, name)
+		}
+	}
+	for _, name := range serverClaims {
+		if _, ok := custom[name]; ok {
+			return nil, fmt.Errorf("%w: %s", errReservedClaim, name)
+		}
+	}
+
+	if len(custom) > 0 {
+		data, err := json.Marshal(custom)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > s.maxClaimsSize {
+			return nil, fmt.Errorf("%w: %d bytes exceeds %d", errClaimsTooLarge, len(data), s.maxClaimsSize)
+		}
+	}
+
+	for k, v := range custom {
+		claims[k] = v
+	}
+	for k, v := range server {
+		claims[k] = v
+	}
+
+	return claims, nil
+}
+
+// Parses and verifies the token, enforcing the configured issuer
+// and audience. ID tokens, the only tokens with azp, are rejected.
+func (s jwtTokenService) parse(token []byte) (*jwt.Token, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(s.keys.Algs()),
+		jwt.WithLeeway(s.leeway),
+	}
+	if s.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(s.issuer))
+	}
+
+	tkn, err := jwt.Parse(string(token), s.keyFunc, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !tkn.Valid {
+		return nil, errNotVaildToken
+	}
+	if claims, ok := tkn.Claims.(jwt.MapClaims); ok {
+		if _, ok := claims["azp"]; ok {
+			return nil, fmt.Errorf("%w: %w", jwt.ErrTokenInvalidClaims, errIDToken)
+		}
+	}
+
+	if len(s.audience) > 0 {
+		aud, err := tkn.Claims.GetAudience()
+		if err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(aud, func(a string) bool { return slices.Contains(s.audience, a) }) {
+			return nil, fmt.Errorf("%w: %w", jwt.ErrTokenInvalidClaims, jwt.ErrTokenInvalidAudience)
+		}
+	}
+
+	return tkn, nil
+}
+
+// Selects the verification key by the kid header
+// and checks that the token is signed with its algorithm.
+func (s jwtTokenService) keyFunc(tkn *jwt.Token) (interface{}, error) {
+	kid, _ := tkn.Header["kid"].(string)
+	key, err := s.keys.Lookup(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if tkn.Method.Alg() != key.Method.Alg() {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+
+	return key.verify, nil
+}
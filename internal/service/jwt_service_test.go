@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+func mustPEM(t *testing.T, typ string, der []byte, err error) []byte {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+func TestAsymmetricJWTService(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	rsaDER, rsaErr := x509.MarshalPKCS8PrivateKey(rsaKey)
+	ecDER, ecErr := x509.MarshalECPrivateKey(ecKey)
+	edDER, edErr := x509.MarshalPKCS8PrivateKey(edKey)
+	rsaPubDER, rsaPubErr := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	ecPubDER, ecPubErr := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	edPubDER, edPubErr := x509.MarshalPKIXPublicKey(edKey.Public())
+
+	tests := map[string]struct {
+		priv    []byte
+		pub     []byte
+		alg     string
+		wantAlg string
+	}{
+		"rsa": {
+			priv:    mustPEM(t, "PRIVATE KEY", rsaDER, rsaErr),
+			pub:     mustPEM(t, "PUBLIC KEY", rsaPubDER, rsaPubErr),
+			wantAlg: "RS256",
+		},
+		"rsa pss": {
+			priv:    mustPEM(t, "PRIVATE KEY", rsaDER, rsaErr),
+			pub:     mustPEM(t, "PUBLIC KEY", rsaPubDER, rsaPubErr),
+			alg:     "PS256",
+			wantAlg: "PS256",
+		},
+		"ecdsa": {
+			priv:    mustPEM(t, "EC PRIVATE KEY", ecDER, ecErr),
+			pub:     mustPEM(t, "PUBLIC KEY", ecPubDER, ecPubErr),
+			wantAlg: "ES256",
+		},
+		"ed25519": {
+			priv:    mustPEM(t, "PRIVATE KEY", edDER, edErr),
+			pub:     mustPEM(t, "PUBLIC KEY", edPubDER, edPubErr),
+			wantAlg: "EdDSA",
+		},
+	}
+
+	ctx := context.Background()
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			priv, err := ParsePrivateKeyPEM(tt.priv, tt.alg)
+			if err != nil {
+				t.Fatalf("couldn't parse private key: %v", err)
+			}
+			if priv.Method.Alg() != tt.wantAlg {
+				t.Errorf("alg is not the same: want=%s, got=%s", tt.wantAlg, priv.Method.Alg())
+			}
+
+			pub, err := ParsePublicKeyPEM(tt.pub, tt.alg)
+			if err != nil {
+				t.Fatalf("couldn't parse public key: %v", err)
+			}
+
+			signer := NewJWTServiceWithKey(priv)
+			verifier := NewJWTServiceWithKey(pub)
+
+			tkn, err := signer.Token(ctx, []byte("some payload"))
+			if err != nil {
+				t.Fatalf("couldn't issue token: %v", err)
+			}
+
+			if err := verifier.Validate(ctx, tkn); err != nil {
+				t.Errorf("token should be valid: %v", err)
+			}
+
+			if _, err := verifier.Token(ctx, []byte("some payload")); err == nil {
+				t.Error("verify-only service shouldn't issue tokens")
+			}
+
+			hmac := NewJWTService([]byte("secret"))
+			if err := hmac.Validate(ctx, tkn); err == nil {
+				t.Error("token signed with another algorithm shouldn't be valid")
+			}
+		})
+	}
+}
+
+func TestParsePrivateKeyPEMWrongAlg(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+
+	if _, err := ParsePrivateKeyPEM(mustPEM(t, "PRIVATE KEY", der, err), "RS256"); err == nil {
+		t.Error("error shouldn't be nil")
+	}
+
+	if _, err := ParsePrivateKeyPEM([]byte("not a pem"), ""); err == nil {
+		t.Error("error shouldn't be nil")
+	}
+}
+
+func TestAttachCertificatesPEM(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "ca"}, IsCA: true, BasicConstraintsValid: true,
+		NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour), KeyUsage: x509.KeyUsageCertSign}
+	caDER, caErr := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	key, _ := GenerateKey("ES256")
+	leaf := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "token signer"},
+		NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour), KeyUsage: x509.KeyUsageDigitalSignature}
+	leafDER, leafErr := x509.CreateCertificate(rand.Reader, leaf, caCert, key.Public(), caKey)
+	chain := append(mustPEM(t, "CERTIFICATE", leafDER, leafErr), mustPEM(t, "CERTIFICATE", caDER, caErr)...)
+
+	if err := key.AttachCertificatesPEM(chain); err != nil {
+		t.Fatal(err)
+	}
+	tkn, err := NewJWTServiceWithKey(key).Token(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, _ := jwt.NewParser().ParseUnverified(string(tkn), jwt.MapClaims{})
+	x5c, _ := parsed.Header["x5c"].([]any)
+	if len(x5c) != 2 || x5c[0] != base64.StdEncoding.EncodeToString(leafDER) {
+		t.Errorf("x5c header should have the chain: %v", parsed.Header["x5c"])
+	}
+
+	other, _ := GenerateKey("ES256")
+	if err := other.AttachCertificatesPEM(chain); !errors.Is(err, errCertificateKey) {
+		t.Errorf("chain of another key shouldn't be attached: %v", err)
+	}
+}
+
+func TestJWTServiceRegisteredClaims(t *testing.T) {
+	ctx := context.Background()
+	svc := NewJWTService([]byte("secret"),
+		WithIssuer("https://auth.example.com"),
+		WithAudience("api", "billing"),
+		WithTTL(time.Hour),
+		WithMaxTTL(2*time.Hour),
+	)
+
+	tests := map[string]struct {
+		opts      []types.TokenOption
+		wantAud   []string
+		wantTTL   time.Duration
+		wantErr   bool
+		wantValid bool
+	}{
+		"defaults": {
+			wantAud:   []string{"api"},
+			wantTTL:   time.Hour,
+			wantValid: true,
+		},
+		"overrides": {
+			opts:      []types.TokenOption{types.WithTTL(90 * time.Minute), types.WithAudience("billing"), types.WithSubject("user-1")},
+			wantAud:   []string{"billing"},
+			wantTTL:   90 * time.Minute,
+			wantValid: true,
+		},
+		"ttl over maximum": {
+			opts:    []types.TokenOption{types.WithTTL(3 * time.Hour)},
+			wantErr: true,
+		},
+		"audience not allowed": {
+			opts:    []types.TokenOption{types.WithAudience("other")},
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tkn, err := svc.Token(ctx, []byte("some payload"), tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var claims JWTClaim
+			if _, _, err := jwt.NewParser().ParseUnverified(string(tkn), &claims); err != nil {
+				t.Fatal(err)
+			}
+			if claims.Issuer != "https://auth.example.com" || claims.ID == "" || claims.NotBefore == nil {
+				t.Errorf("iss, jti and nbf should be set: %+v", claims.RegisteredClaims)
+			}
+			if !slices.Equal(claims.Audience, tt.wantAud) {
+				t.Errorf("aud is not the same: want=%v, got=%v", tt.wantAud, claims.Audience)
+			}
+			if got := claims.ExpiresAt.Sub(claims.IssuedAt.Time); got != tt.wantTTL {
+				t.Errorf("ttl is not the same: want=%v, got=%v", tt.wantTTL, got)
+			}
+			if err := svc.Validate(ctx, tkn); (err == nil) != tt.wantValid {
+				t.Errorf("unexpected validation result: %v", err)
+			}
+		})
+	}
+
+	other := NewJWTService([]byte("secret"), WithIssuer("https://other.example.com"))
+	tkn, _ := other.Token(ctx, []byte("some payload"))
+	if err := svc.Validate(ctx, tkn); err == nil {
+		t.Error("token of another issuer shouldn't be valid")
+	}
+
+	other = NewJWTService([]byte("secret"), WithIssuer("https://auth.example.com"), WithAudience("other"))
+	tkn, _ = other.Token(ctx, []byte("some payload"))
+	if err := svc.Validate(ctx, tkn); err == nil {
+		t.Error("token of another audience shouldn't be valid")
+	}
+}
+
+func TestJWTServiceLeeway(t *testing.T) {
+	ctx := context.Background()
+	expired := NewJWTService([]byte("secret"), WithTTL(-time.Second))
+	tkn, _ := expired.Token(ctx, []byte("some payload"))
+
+	if err := NewJWTService([]byte("secret")).Validate(ctx, tkn); err == nil {
+		t.Error("expired token shouldn't be valid")
+	}
+
+	if err := NewJWTService([]byte("secret"), WithLeeway(time.Minute)).Validate(ctx, tkn); err != nil {
+		t.Errorf("token expired within leeway should be valid: %v", err)
+	}
+}
+
+func TestJWTServiceCustomClaims(t *testing.T) {
+	ctx := context.Background()
+	svc := NewJWTService([]byte("secret"), WithMaxClaimsSize(64))
+
+	tkn, err := svc.Token(ctx, nil, types.WithClaims(map[string]any{"role": "admin", "org": map[string]any{"id": 5.0}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(string(tkn), claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["role"] != "admin" {
+		t.Errorf("custom claim should be top-level: %v", claims)
+	}
+	if org, _ := claims["org"].(map[string]any); org["id"] != 5.0 {
+		t.Errorf("nested custom claim should be kept: %v", claims)
+	}
+	if _, ok := claims["payload"]; ok {
+		t.Error("empty payload shouldn't be set")
+	}
+
+	if _, err := svc.Token(ctx, nil, types.WithClaims(map[string]any{"sub": "admin"})); err == nil {
+		t.Error("reserved claim shouldn't be overwritten")
+	}
+	if _, err := svc.Token(ctx, nil, types.WithClaims(map[string]any{"scope": "*"})); err == nil {
+		t.Error("claim of the server shouldn't be set by custom claims")
+	}
+	if _, err := svc.Token(ctx, nil, types.WithServerClaims(map[string]any{"iss": "admin"})); err == nil {
+		t.Error("reserved claim shouldn't be overwritten by claims of the server")
+	}
+
+	tkn, err = svc.Token(ctx, nil, types.WithServerClaims(map[string]any{"scope": "invoices:read"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims = jwt.MapClaims{}
+	_, _, _ = jwt.NewParser().ParseUnverified(string(tkn), claims)
+	if claims["scope"] != "invoices:read" {
+		t.Errorf("claim of the server should be set: %v", claims)
+	}
+
+	if _, err := svc.Token(ctx, nil, types.WithClaims(map[string]any{"big": string(make([]byte, 64))})); err == nil {
+		t.Error("claims over the size limit shouldn't be accepted")
+	}
+}
+
+func TestJWTServiceIntrospect(t *testing.T) {
+	ctx := context.Background()
+	svc := NewJWTService([]byte("secret"))
+	valid, _ := svc.Token(ctx, []byte("some payload"), types.WithClaims(map[string]any{"role": "admin"}))
+	expired, _ := NewJWTService([]byte("secret"), WithTTL(-time.Minute)).Token(ctx, nil)
+	forged, _ := NewJWTService([]byte("other")).Token(ctx, nil)
+
+	tests := map[string]struct {
+		token      []byte
+		wantValid  bool
+		wantReason string
+	}{
+		"valid": {
+			token:     valid,
+			wantValid: true,
+		},
+		"expired": {
+			token:      expired,
+			wantReason: types.ReasonExpired,
+		},
+		"bad signature": {
+			token:      forged,
+			wantReason: types.ReasonBadSignature,
+		},
+		"malformed": {
+			token:      []byte("some-random-text"),
+			wantReason: types.ReasonMalformed,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := svc.Introspect(ctx, tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Valid != tt.wantValid || got.Reason != tt.wantReason {
+				t.Errorf("unexpected result: want=%v %q, got=%v %q", tt.wantValid, tt.wantReason, got.Valid, got.Reason)
+			}
+			if tt.wantValid && (got.Claims["role"] != "admin" || got.ExpiresAt == 0 || got.IssuedAt == 0 || got.KeyID == "") {
+				t.Errorf("claims should be returned: %+v", got)
+			}
+		})
+	}
+}
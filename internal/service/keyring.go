@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+var (
+	errNoSigningKey = errors.New("keyring has no signing key")
+	errUnknownKey   = errors.New("unknown key id")
+)
+
+// Keyring holds signing and verification keys of the JWT TokenService.
+// Exactly one key signs new tokens, the others only verify until
+// their retirement time, so rotation doesn't invalidate issued tokens.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[string]*ringKey
+	current string
+	now     func() time.Time
+}
+
+// Key in a keyring with its retirement time.
+// Zero retireAt means the key never retires.
+type ringKey struct {
+	*Key
+	addedAt  time.Time
+	retireAt time.Time
+	// Rotated keys were promoted by Rotate rather than
+	// synced, so Sync doesn't retire them.
+	rotated bool
+}
+
+// NewKeyring creates a keyring that signs with the first key
+// that can sign. Other keys are used for verification only.
+func NewKeyring(keys ...*Key) *Keyring {
+	r := &Keyring{
+		keys: make(map[string]*ringKey),
+		now:  time.Now,
+	}
+	for _, k := range keys {
+		r.keys[k.ID] = &ringKey{Key: k, addedAt: r.now()}
+		if r.current == "" && k.CanSign() {
+			r.current = k.ID
+		}
+	}
+
+	return r
+}
+
+// Signing returns the key that signs new tokens.
+func (r *Keyring) Signing() (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	k, ok := r.keys[r.current]
+	if !ok {
+		return nil, errNoSigningKey
+	}
+
+	return k.Key, nil
+}
+
+// Lookup returns an active verification key by its id.
+// An empty id selects the signing key for tokens issued without kid.
+func (r *Keyring) Lookup(kid string) (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if kid == "" {
+		kid = r.current
+	}
+	k, ok := r.keys[kid]
+	if !ok || k.retired(r.now()) {
+		return nil, errUnknownKey
+	}
+
+	return k.Key, nil
+}
+
+// Algs returns algorithms of all active keys.
+func (r *Keyring) Algs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var algs []string
+	for _, k := range r.keys {
+		if alg := k.Method.Alg(); !seen[alg] && !k.retired(r.now()) {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+
+	return algs
+}
+
+// Rotate promotes the key to sign new tokens. The previous
+// signing key keeps verifying tokens for the overlap duration.
+func (r *Keyring) Rotate(key *Key, overlap time.Duration) error {
+	if !key.CanSign() {
+		return errVerifyOnly
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rotate(key, overlap)
+	r.keys[key.ID].rotated = true
+	r.purge()
+
+	return nil
+}
+
+// RotateKey implements types.KeyRotator. It promotes the PEM-encoded private key
+// or, if none is given, a newly generated key of the current algorithm.
+func (r *Keyring) RotateKey(_ context.Context, privateKeyPEM []byte, overlap time.Duration) (string, error) {
+	alg := ""
+	if cur, err := r.Signing(); err == nil {
+		alg = cur.Method.Alg()
+	}
+
+	var (
+		key *Key
+		err error
+	)
+	if len(privateKeyPEM) == 0 {
+		key, err = GenerateKey(alg)
+	} else {
+		key, err = ParsePrivateKeyPEM(privateKeyPEM, "")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.Rotate(key, overlap); err != nil {
+		return "", err
+	}
+
+	return key.ID, nil
+}
+
+// Sync makes the keyring match the given keys, which is used to reload
+// a key directory. The last key that can sign becomes the signing key,
+// keys that disappeared retire after the overlap duration. A key promoted
+// by Rotate keeps signing until the keys bring a new signing key.
+func (r *Keyring) Sync(keys []*Key, overlap time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	present := make(map[string]bool)
+	added := make(map[string]bool)
+	var signing *Key
+	for _, k := range keys {
+		present[k.ID] = true
+		if rk, ok := r.keys[k.ID]; !ok || rk.retired(now) {
+			r.keys[k.ID] = &ringKey{Key: k, addedAt: now}
+			added[k.ID] = true
+		} else {
+			rk.retireAt = time.Time{}
+		}
+		if k.CanSign() {
+			signing = k
+		}
+	}
+
+	cur, ok := r.keys[r.current]
+	if signing != nil && (!ok || !cur.rotated || added[signing.ID]) {
+		r.rotate(signing, overlap)
+	}
+
+	for id, k := range r.keys {
+		if !present[id] && !k.rotated && k.retireAt.IsZero() {
+			k.retireAt = now.Add(overlap)
+		}
+	}
+	r.purge()
+}
+
+// Keys implements types.KeyProvider and publishes all active keys,
+// the signing key first.
+func (r *Keyring) Keys(_ context.Context) (*types.JWKSet, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	active := make([]*ringKey, 0, len(r.keys))
+	for _, k := range r.keys {
+		if !k.retired(r.now()) {
+			active = append(active, k)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].ID == r.current || active[j].ID == r.current {
+			return active[i].ID == r.current
+		}
+		return active[i].addedAt.After(active[j].addedAt)
+	})
+
+	set := &types.JWKSet{Keys: []types.JWK{}}
+	for _, k := range active {
+		if jwk, ok := k.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set, nil
+}
+
+// Promotes the key, must be called with the lock held.
+func (r *Keyring) rotate(key *Key, overlap time.Duration) {
+	now := r.now()
+	if key.ID == r.current {
+		return
+	}
+
+	if prev, ok := r.keys[r.current]; ok {
+		prev.retireAt = now.Add(overlap)
+	}
+	if _, ok := r.keys[key.ID]; !ok {
+		r.keys[key.ID] = &ringKey{Key: key, addedAt: now}
+	}
+	r.keys[key.ID].retireAt = time.Time{}
+	r.current = key.ID
+}
+
+// Removes retired keys, must be called with the lock held.
+func (r *Keyring) purge() {
+	for id, k := range r.keys {
+		if k.retired(r.now()) {
+			delete(r.keys, id)
+		}
+	}
+}
+
+// Reports whether the key stopped verifying tokens.
+func (k *ringKey) retired(now time.Time) bool {
+	return !k.retireAt.IsZero() && now.After(k.retireAt)
+}
+
+// LoadKeyDir loads all *.pem keys from the directory ordered by
+// modification time, so the most recently written private key
+// becomes the signing key after Keyring.Sync.
+func LoadKeyDir(dir, alg string) ([]*Key, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		key     *Key
+		modTime time.Time
+	}
+	var files []file
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".pem") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParsePrivateKeyPEM(data, alg)
+		if errors.Is(err, errUnexpectedPEMBlock) {
+			key, err = ParsePublicKeyPEM(data, alg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		files = append(files, file{key: key, modTime: info.ModTime()})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	keys := make([]*Key, 0, len(files))
+	for _, f := range files {
+		keys = append(keys, f.key)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	oldKey, _ := GenerateKey("ES256")
+	ring := NewKeyring(oldKey)
+	ring.now = func() time.Time { return now }
+	svc := NewJWTServiceWithKeyring(ring)
+
+	oldTkn, err := svc.Token(ctx, []byte("some payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid, err := ring.RotateKey(ctx, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid == oldKey.ID {
+		t.Fatal("a new key should be generated")
+	}
+
+	newTkn, err := svc.Token(ctx, []byte("some payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Validate(ctx, oldTkn); err != nil {
+		t.Errorf("token of the previous key should be valid during overlap: %v", err)
+	}
+	if err := svc.Validate(ctx, newTkn); err != nil {
+		t.Errorf("token of the new key should be valid: %v", err)
+	}
+
+	set, _ := ring.Keys(ctx)
+	if len(set.Keys) != 2 || set.Keys[0].KeyID != kid {
+		t.Errorf("both keys should be published, newest first: %+v", set.Keys)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := svc.Validate(ctx, oldTkn); err == nil {
+		t.Error("token of the retired key shouldn't be valid")
+	}
+
+	set, _ = ring.Keys(ctx)
+	if len(set.Keys) != 1 {
+		t.Errorf("retired key shouldn't be published: %+v", set.Keys)
+	}
+}
+
+func TestKeyringSync(t *testing.T) {
+	now := time.Now()
+	a, _ := GenerateKey("EdDSA")
+	b, _ := GenerateKey("EdDSA")
+
+	ring := NewKeyring()
+	ring.now = func() time.Time { return now }
+	ring.Sync([]*Key{a}, time.Hour)
+
+	if k, _ := ring.Signing(); k == nil || k.ID != a.ID {
+		t.Fatal("the only key should sign")
+	}
+
+	ring.Sync([]*Key{b}, time.Hour)
+	if k, _ := ring.Signing(); k.ID != b.ID {
+		t.Error("the new key should sign")
+	}
+	if _, err := ring.Lookup(a.ID); err != nil {
+		t.Error("the removed key should verify during overlap")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := ring.Lookup(a.ID); err == nil {
+		t.Error("the removed key should be retired")
+	}
+
+	// A key rotated at runtime survives reloads of the same keys.
+	c, _ := GenerateKey("EdDSA")
+	if err := ring.Rotate(c, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	ring.Sync([]*Key{b}, time.Hour)
+	if k, _ := ring.Signing(); k.ID != c.ID {
+		t.Error("the rotated key should keep signing")
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := ring.Lookup(c.ID); err != nil {
+		t.Error("the rotated key shouldn't be retired by a reload")
+	}
+
+	d, _ := GenerateKey("EdDSA")
+	ring.Sync([]*Key{b, d}, time.Hour)
+	if k, _ := ring.Signing(); k.ID != d.ID {
+		t.Error("a new key of the reload should sign")
+	}
+	if _, err := ring.Lookup(c.ID); err != nil {
+		t.Error("the rotated key should verify during overlap")
+	}
+}
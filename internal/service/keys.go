@@ -0,0 +1,254 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	errNoPEMBlock         = errors.New("no PEM block found")
+	errUnexpectedPEMBlock = errors.New("unexpected PEM block")
+	errUnsupportedKeyType = errors.New("unsupported key type")
+	errVerifyOnly         = errors.New("key can only verify tokens")
+	errCertificateKey     = errors.New("certificate doesn't certify the key")
+)
+
+// Key is a key used by the JWT TokenService
+// to sign and verify tokens.
+type Key struct {
+	// ID is the key id put in the kid header of tokens.
+	ID string
+	// Method is the signing method chosen from the key type.
+	Method jwt.SigningMethod
+	// Private signing key, nil for verify-only keys.
+	sign any
+	// Public verification key.
+	verify any
+	// Certificates are the DER encoded chain of the key, leaf first.
+	// They are put in the x5c header of tokens if set.
+	Certificates [][]byte
+}
+
+// NewHMACKey creates a symmetric HS256 key from the given secret.
+func NewHMACKey(secret []byte) *Key {
+	key := &Key{
+		Method: jwt.SigningMethodHS256,
+		sign:   secret,
+		verify: secret,
+	}
+	key.ID = thumbprint(key)
+
+	return key
+}
+
+// GenerateKey generates a new random signing key for the algorithm.
+func GenerateKey(alg string) (*Key, error) {
+	var (
+		priv any
+		err  error
+	)
+	switch alg {
+	case "HS256", "":
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return NewHMACKey(secret), nil
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		priv, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		priv, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "EdDSA":
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedKeyType, alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(priv, alg)
+}
+
+// ParsePrivateKeyPEM parses a PEM-encoded RSA, ECDSA or Ed25519 private key.
+// The signing algorithm is chosen from the key type unless alg is given,
+// which is only useful to pick a different hash or PSS padding for RSA keys.
+func ParsePrivateKeyPEM(data []byte, alg string) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errNoPEMBlock
+	}
+
+	var (
+		priv any
+		err  error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		priv, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w %q", errUnexpectedPEMBlock, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(priv, alg)
+}
+
+// ParsePublicKeyPEM parses a PEM-encoded RSA, ECDSA or Ed25519 public key
+// or certificate into a key that can only verify tokens.
+func ParsePublicKeyPEM(data []byte, alg string) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errNoPEMBlock
+	}
+
+	var (
+		pub any
+		err error
+	)
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			pub = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", errUnexpectedPEMBlock, block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(pub, alg)
+}
+
+// Creates a Key from a parsed private or public key.
+func newKey(k any, alg string) (*Key, error) {
+	key := new(Key)
+	switch k := k.(type) {
+	case *rsa.PrivateKey:
+		key.sign, key.verify = k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.verify = k
+	case *ecdsa.PrivateKey:
+		key.sign, key.verify = k, &k.PublicKey
+	case *ecdsa.PublicKey:
+		key.verify = k
+	case ed25519.PrivateKey:
+		key.sign, key.verify = k, k.Public()
+	case ed25519.PublicKey:
+		key.verify = k
+	default:
+		return nil, fmt.Errorf("%w: %T", errUnsupportedKeyType, k)
+	}
+
+	method, err := methodFor(key.verify, alg)
+	if err != nil {
+		return nil, err
+	}
+	key.Method = method
+	key.ID = thumbprint(key)
+
+	return key, nil
+}
+
+// Picks a signing method for the public key.
+func methodFor(pub any, alg string) (jwt.SigningMethod, error) {
+	var allowed []string
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		allowed = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			allowed = []string{"ES256"}
+		case elliptic.P384():
+			allowed = []string{"ES384"}
+		case elliptic.P521():
+			allowed = []string{"ES512"}
+		default:
+			return nil, fmt.Errorf("%w: curve %s", errUnsupportedKeyType, pub.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		allowed = []string{"EdDSA"}
+	}
+
+	if alg == "" {
+		return jwt.GetSigningMethod(allowed[0]), nil
+	}
+	for _, a := range allowed {
+		if a == alg {
+			return jwt.GetSigningMethod(a), nil
+		}
+	}
+
+	return nil, fmt.Errorf("algorithm %s can't be used with %T", alg, pub)
+}
+
+// CanSign reports whether the key can sign tokens.
+func (k *Key) CanSign() bool {
+	return k.sign != nil
+}
+
+// Public returns the public verification key.
+// For HMAC keys it is the shared secret.
+func (k *Key) Public() any {
+	return k.verify
+}
+
+// AttachCertificatesPEM sets the chain of the key from PEM-encoded
+// certificates, leaf first. The leaf must certify the key.
+func (k *Key) AttachCertificatesPEM(data []byte) error {
+	var chain [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("%w %q", errUnexpectedPEMBlock, block.Type)
+		}
+		chain = append(chain, block.Bytes)
+	}
+	if len(chain) == 0 {
+		return errNoPEMBlock
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return err
+	}
+	pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(k.verify) {
+		return errCertificateKey
+	}
+	k.Certificates = chain
+
+	return nil
+}
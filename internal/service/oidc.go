@@ -0,0 +1,56 @@
+package service
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// idTokenClaims sets the OpenID Connect claims of the ID token.
+func idTokenClaims(claims jwt.MapClaims, method jwt.SigningMethod, o *types.IDTokenOptions) error {
+	claims["azp"] = o.ClientID
+	if o.Nonce != "" {
+		claims["nonce"] = o.Nonce
+	}
+	if !o.AuthTime.IsZero() {
+		claims["auth_time"] = jwt.NewNumericDate(o.AuthTime)
+	}
+	if o.AccessToken != "" {
+		h, err := TokenHash(method, o.AccessToken)
+		if err != nil {
+			return err
+		}
+		claims["at_hash"] = h
+	}
+
+	return nil
+}
+
+// TokenHash computes at_hash or c_hash of the value for tokens
+// signed with the method: left half of the hash used by the alg.
+func TokenHash(method jwt.SigningMethod, value string) (string, error) {
+	var h crypto.Hash
+	switch alg := method.Alg(); {
+	case alg == "EdDSA", strings.HasSuffix(alg, "512"):
+		h = crypto.SHA512
+	case strings.HasSuffix(alg, "384"):
+		h = crypto.SHA384
+	case strings.HasSuffix(alg, "256"):
+		h = crypto.SHA256
+	default:
+		return "", fmt.Errorf("%w: %s", errUnsupportedKeyType, alg)
+	}
+
+	d := h.New()
+	d.Write([]byte(value))
+	sum := d.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
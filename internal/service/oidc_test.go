@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+func TestJWTServiceIDToken(t *testing.T) {
+	ctx := context.Background()
+	key, _ := GenerateKey("ES384")
+	svc := NewJWTServiceWithKey(key, WithAudience("api"))
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	access, _ := svc.Token(ctx, nil, types.WithSubject("user-1"))
+	idToken, err := svc.Token(ctx, nil, types.WithSubject("user-1"), types.AsIDToken(types.IDTokenOptions{
+		ClientID:    "web",
+		Nonce:       "n-0S6_WzA2Mj",
+		AuthTime:    authTime,
+		AccessToken: string(access),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(string(idToken), claims); err != nil {
+		t.Fatal(err)
+	}
+	wantHash, _ := TokenHash(jwt.SigningMethodES384, string(access))
+	if claims["at_hash"] != wantHash || len(wantHash) != 32 {
+		t.Errorf("at_hash is not the same: want=%s, got=%v", wantHash, claims["at_hash"])
+	}
+	if aud, _ := claims.GetAudience(); len(aud) != 1 || aud[0] != "web" || claims["azp"] != "web" {
+		t.Errorf("aud and azp should be the client: %v", claims)
+	}
+	if claims["nonce"] != "n-0S6_WzA2Mj" || claims["auth_time"] != float64(authTime.Unix()) {
+		t.Errorf("nonce and auth_time should be set: %v", claims)
+	}
+
+	// ID tokens are issued to clients and aren't access tokens.
+	if err := NewJWTServiceWithKey(key).Validate(ctx, idToken); err == nil {
+		t.Error("id token shouldn't be valid as an access token")
+	}
+	if in, _ := NewJWTServiceWithKey(key).Introspect(ctx, idToken); in.Valid || in.Reason != types.ReasonInvalidClaims {
+		t.Errorf("id token shouldn't be introspected as an access token: %+v", in)
+	}
+
+	if _, err := svc.Token(ctx, nil, types.AsIDToken(types.IDTokenOptions{})); err == nil {
+		t.Error("id token without a client shouldn't be issued")
+	}
+	if _, err := svc.Token(ctx, nil, types.WithClaims(map[string]any{"nonce": "x"})); err == nil {
+		t.Error("nonce shouldn't be set as a custom claim")
+	}
+}
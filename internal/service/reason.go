@@ -0,0 +1,27 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Classifies a validation error as a machine-readable reason.
+func reason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return types.ReasonMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return types.ReasonBadSignature
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return types.ReasonExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return types.ReasonNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidClaims):
+		return types.ReasonInvalidClaims
+	default:
+		return types.ReasonInvalid
+	}
+}
@@ -14,7 +14,7 @@ type tokenService struct {
 }
 
 // Custom sign func type.
-type signFunc func(context.Context, []byte) ([]byte, error)
+type signFunc func(context.Context, []byte, ...types.TokenOption) ([]byte, error)
 
 // Custom validation func type.
 type validationFunc func(context.Context, []byte) error
@@ -32,7 +32,17 @@ func (s *tokenService) Validate(ctx context.Context, token []byte) error {
 	return s.validate(ctx, token)
 }
 
+// Introspect function for TokenService. The bare
+// service only knows whether the token is valid.
+func (s *tokenService) Introspect(ctx context.Context, token []byte) (*types.Introspection, error) {
+	if err := s.validate(ctx, token); err != nil {
+		return &types.Introspection{Valid: false, Reason: reason(err)}, nil
+	}
+
+	return &types.Introspection{Valid: true}, nil
+}
+
 // Token function for TokenService.
-func (s *tokenService) Token(ctx context.Context, payload []byte) ([]byte, error) {
-	return s.sign(ctx, payload)
+func (s *tokenService) Token(ctx context.Context, payload []byte, opts ...types.TokenOption) ([]byte, error) {
+	return s.sign(ctx, payload, opts...)
 }
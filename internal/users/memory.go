@@ -0,0 +1,145 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// In-memory UserStore.
+type memoryStore struct {
+	mu    sync.Mutex
+	users map[string]types.User
+}
+
+// NewMemoryStore creates an in-memory UserStore
+// that loses its users on restart, useful for tests.
+func NewMemoryStore() types.UserStore {
+	return &memoryStore{
+		users: make(map[string]types.User),
+	}
+}
+
+// Create stores a new user.
+func (s *memoryStore) Create(_ context.Context, u *types.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.users {
+		if other.Username == u.Username || sharesCredential(&other, u) {
+			return types.ErrAlreadyExists
+		}
+	}
+	s.users[u.ID] = clone(u)
+
+	return nil
+}
+
+// User returns the user.
+func (s *memoryStore) User(_ context.Context, id string) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	u = clone(&u)
+
+	return &u, nil
+}
+
+// UserByUsername returns the user.
+func (s *memoryStore) UserByUsername(_ context.Context, username string) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Username == username {
+			u = clone(&u)
+			return &u, nil
+		}
+	}
+
+	return nil, types.ErrNotFound
+}
+
+// Users returns all users ordered by username.
+func (s *memoryStore) Users(_ context.Context) ([]*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]*types.User, 0, len(s.users))
+	for _, u := range s.users {
+		u = clone(&u)
+		users = append(users, &u)
+	}
+	slices.SortFunc(users, func(a, b *types.User) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+
+	return users, nil
+}
+
+// Update replaces the user.
+func (s *memoryStore) Update(_ context.Context, u *types.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[u.ID]; !ok {
+		return types.ErrNotFound
+	}
+	for id, other := range s.users {
+		if id != u.ID && (other.Username == u.Username || sharesCredential(&other, u)) {
+			return types.ErrAlreadyExists
+		}
+	}
+	s.users[u.ID] = clone(u)
+
+	return nil
+}
+
+// Delete deletes the user.
+func (s *memoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return types.ErrNotFound
+	}
+	delete(s.users, id)
+
+	return nil
+}
+
+// Copies the user so callers can't modify stored maps.
+func clone(u *types.User) types.User {
+	c := *u
+	c.Profile = maps.Clone(u.Profile)
+	if u.TOTP != nil {
+		totp := *u.TOTP
+		totp.RecoveryCodes = slices.Clone(u.TOTP.RecoveryCodes)
+		c.TOTP = &totp
+	}
+	c.Credentials = slices.Clone(u.Credentials)
+	c.Roles = slices.Clone(u.Roles)
+	return c
+}
+
+// Reports whether the users have a credential ID in common.
+func sharesCredential(a, b *types.User) bool {
+	for _, c := range b.Credentials {
+		if slices.ContainsFunc(a.Credentials, func(o types.WebAuthnCredential) bool {
+			return bytes.Equal(o.ID, c.ID)
+		}) {
+			return true
+		}
+	}
+
+	return false
+}
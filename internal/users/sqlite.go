@@ -0,0 +1,421 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Schema of the user tables, applied on every start.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id            TEXT PRIMARY KEY,
+		username      TEXT NOT NULL UNIQUE,
+		password_hash TEXT NOT NULL,
+		profile       TEXT NOT NULL DEFAULT '{}',
+		disabled      INTEGER NOT NULL DEFAULT 0,
+		created_at    INTEGER NOT NULL,
+		updated_at    INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_totp (
+		user_id        TEXT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		secret         TEXT NOT NULL,
+		confirmed      INTEGER NOT NULL DEFAULT 0,
+		last_step      INTEGER NOT NULL DEFAULT 0,
+		recovery_codes TEXT NOT NULL DEFAULT '[]'
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_totp_pending (
+		user_id TEXT PRIMARY KEY REFERENCES user_totp (user_id) ON DELETE CASCADE,
+		secret  TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_credentials (
+		id           BLOB PRIMARY KEY,
+		user_id      TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		public_key   BLOB NOT NULL,
+		sign_count   INTEGER NOT NULL DEFAULT 0,
+		aaguid       BLOB,
+		created_at   INTEGER NOT NULL,
+		last_used_at INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS user_credentials_user_id ON user_credentials (user_id)`,
+	`CREATE TABLE IF NOT EXISTS user_roles (
+		user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		role    TEXT NOT NULL,
+		PRIMARY KEY (user_id, role)
+	)`,
+}
+
+// OpenSQLite opens the SQLite database file with
+// write-ahead logging and foreign keys enabled.
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// UserStore persisted in a SQLite database.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore creates a UserStore in the SQLite database.
+func NewSQLiteStore(ctx context.Context, db *sql.DB) (types.UserStore, error) {
+	for _, stmt := range schema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	return &sqliteStore{db: db}, nil
+}
+
+// Create stores a new user.
+func (s *sqliteStore) Create(ctx context.Context, u *types.User) error {
+	profile, err := marshalProfile(u.Profile)
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO users (id, username, password_hash, profile, disabled, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			u.ID, u.Username, u.PasswordHash, profile, u.Disabled, u.CreatedAt.Unix(), u.UpdatedAt.Unix(),
+		)
+		if err != nil {
+			return storeError(err)
+		}
+		if err := saveTOTP(ctx, tx, u); err != nil {
+			return err
+		}
+		if err := saveRoles(ctx, tx, u); err != nil {
+			return err
+		}
+
+		return saveCredentials(ctx, tx, u)
+	})
+}
+
+// User returns the user.
+func (s *sqliteStore) User(ctx context.Context, id string) (*types.User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, selectUsers+` WHERE u.id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	return u, s.loadRelations(ctx, u)
+}
+
+// UserByUsername returns the user.
+func (s *sqliteStore) UserByUsername(ctx context.Context, username string) (*types.User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, selectUsers+` WHERE u.username = ?`, username))
+	if err != nil {
+		return nil, err
+	}
+
+	return u, s.loadRelations(ctx, u)
+}
+
+// Users returns all users ordered by username.
+func (s *sqliteStore) Users(ctx context.Context) ([]*types.User, error) {
+	rows, err := s.db.QueryContext(ctx, selectUsers+` ORDER BY u.username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*types.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, u := range users {
+		if err := s.loadRelations(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+
+	return users, nil
+}
+
+// Update replaces the user.
+func (s *sqliteStore) Update(ctx context.Context, u *types.User) error {
+	profile, err := marshalProfile(u.Profile)
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE users SET username = ?, password_hash = ?, profile = ?, disabled = ?, updated_at = ? WHERE id = ?`,
+			u.Username, u.PasswordHash, profile, u.Disabled, u.UpdatedAt.Unix(), u.ID,
+		)
+		if err != nil {
+			return storeError(err)
+		}
+		if err := affected(res); err != nil {
+			return err
+		}
+		if err := saveTOTP(ctx, tx, u); err != nil {
+			return err
+		}
+		if err := saveRoles(ctx, tx, u); err != nil {
+			return err
+		}
+
+		return saveCredentials(ctx, tx, u)
+	})
+}
+
+// Delete deletes the user.
+func (s *sqliteStore) Delete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return affected(res)
+}
+
+const selectUsers = `SELECT u.id, u.username, u.password_hash, u.profile, u.disabled, u.created_at, u.updated_at,
+	t.secret, t.confirmed, t.last_step, t.recovery_codes, p.secret
+	FROM users u LEFT JOIN user_totp t ON t.user_id = u.id LEFT JOIN user_totp_pending p ON p.user_id = u.id`
+
+// Scans a row of selectUsers.
+func scanUser(row interface{ Scan(...any) error }) (*types.User, error) {
+	var (
+		u                    types.User
+		profile              string
+		createdAt, updatedAt int64
+		totpSecret, codes    sql.NullString
+		pendingSecret        sql.NullString
+		totpConfirmed        sql.NullBool
+		totpLastStep         sql.NullInt64
+	)
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &profile, &u.Disabled, &createdAt, &updatedAt,
+		&totpSecret, &totpConfirmed, &totpLastStep, &codes, &pendingSecret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, types.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(profile), &u.Profile); err != nil {
+		return nil, err
+	}
+	if len(u.Profile) == 0 {
+		u.Profile = nil
+	}
+	u.CreatedAt = time.Unix(createdAt, 0).UTC()
+	u.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+
+	if totpSecret.Valid {
+		u.TOTP = &types.TOTP{
+			Secret:        totpSecret.String,
+			Confirmed:     totpConfirmed.Bool,
+			LastStep:      totpLastStep.Int64,
+			PendingSecret: pendingSecret.String,
+		}
+		if err := json.Unmarshal([]byte(codes.String), &u.TOTP.RecoveryCodes); err != nil {
+			return nil, err
+		}
+	}
+
+	return &u, nil
+}
+
+// Replaces the authenticator of the user in the transaction.
+func saveTOTP(ctx context.Context, tx *sql.Tx, u *types.User) error {
+	if u.TOTP == nil {
+		_, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = ?`, u.ID)
+		return err
+	}
+
+	codes, err := json.Marshal(u.TOTP.RecoveryCodes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_totp (user_id, secret, confirmed, last_step, recovery_codes) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, confirmed = excluded.confirmed,
+		last_step = excluded.last_step, recovery_codes = excluded.recovery_codes`,
+		u.ID, u.TOTP.Secret, u.TOTP.Confirmed, u.TOTP.LastStep, string(codes),
+	)
+	if err != nil {
+		return err
+	}
+
+	if u.TOTP.PendingSecret == "" {
+		_, err = tx.ExecContext(ctx, `DELETE FROM user_totp_pending WHERE user_id = ?`, u.ID)
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_totp_pending (user_id, secret) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret`,
+		u.ID, u.TOTP.PendingSecret,
+	)
+
+	return err
+}
+
+// Loads the credentials and roles of the user.
+func (s *sqliteStore) loadRelations(ctx context.Context, u *types.User) error {
+	if err := s.loadCredentials(ctx, u); err != nil {
+		return err
+	}
+
+	return s.loadRoles(ctx, u)
+}
+
+// Loads the WebAuthn credentials of the user.
+func (s *sqliteStore) loadCredentials(ctx context.Context, u *types.User) error {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, public_key, sign_count, aaguid, created_at, last_used_at FROM user_credentials WHERE user_id = ? ORDER BY created_at, id`, u.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			c                     types.WebAuthnCredential
+			createdAt, lastUsedAt int64
+		)
+		if err := rows.Scan(&c.ID, &c.PublicKey, &c.SignCount, &c.AAGUID, &createdAt, &lastUsedAt); err != nil {
+			return err
+		}
+		c.CreatedAt = time.Unix(createdAt, 0).UTC()
+		if lastUsedAt > 0 {
+			c.LastUsedAt = time.Unix(lastUsedAt, 0).UTC()
+		}
+		u.Credentials = append(u.Credentials, c)
+	}
+
+	return rows.Err()
+}
+
+// Replaces the WebAuthn credentials of the user in the transaction.
+func saveCredentials(ctx context.Context, tx *sql.Tx, u *types.User) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_credentials WHERE user_id = ?`, u.ID); err != nil {
+		return err
+	}
+
+	for _, c := range u.Credentials {
+		var lastUsedAt int64
+		if !c.LastUsedAt.IsZero() {
+			lastUsedAt = c.LastUsedAt.Unix()
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO user_credentials (id, user_id, public_key, sign_count, aaguid, created_at, last_used_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			c.ID, u.ID, c.PublicKey, c.SignCount, c.AAGUID, c.CreatedAt.Unix(), lastUsedAt,
+		)
+		if err != nil {
+			return storeError(err)
+		}
+	}
+
+	return nil
+}
+
+// Loads the roles of the user.
+func (s *sqliteStore) loadRoles(ctx context.Context, u *types.User) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT role FROM user_roles WHERE user_id = ? ORDER BY role`, u.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return err
+		}
+		u.Roles = append(u.Roles, role)
+	}
+
+	return rows.Err()
+}
+
+// Replaces the roles of the user in the transaction.
+func saveRoles(ctx context.Context, tx *sql.Tx, u *types.User) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = ?`, u.ID); err != nil {
+		return err
+	}
+
+	for _, role := range u.Roles {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO user_roles (user_id, role) VALUES (?, ?)`, u.ID, role); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Runs the function in a transaction committed if it succeeds.
+func (s *sqliteStore) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Encodes the profile as a JSON object.
+func marshalProfile(profile map[string]any) (string, error) {
+	if profile == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(profile)
+	return string(data), err
+}
+
+// Returns ErrNotFound if the statement changed no rows.
+func affected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return types.ErrNotFound
+	}
+
+	return nil
+}
+
+// Maps unique constraint violations to ErrAlreadyExists.
+func storeError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+		sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+		return types.ErrAlreadyExists
+	}
+
+	return err
+}
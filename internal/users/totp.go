@@ -0,0 +1,117 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238 that authenticator apps support.
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// Steps before and after the current one accepted for clock drift.
+	totpWindow = 1
+	// Number of recovery codes generated on enrollment.
+	recoveryCodes = 10
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generates a random 160 bit base32 encoded secret.
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return b32.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth URI of the secret for authenticator apps.
+func TOTPURI(issuer, account, secret string) string {
+	q := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(int(totpPeriod / time.Second))},
+	}
+	label := url.PathEscape(issuer + ":" + account)
+
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// TOTPCode computes the code of the secret at the time.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	return hotp(secret, t.Unix()/int64(totpPeriod/time.Second))
+}
+
+// Verifies the code within the window and returns its time step,
+// steps up to the last used one are rejected as replays.
+func verifyTOTP(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	step := t.Unix() / int64(totpPeriod/time.Second)
+	for s := step - totpWindow; s <= step+totpWindow; s++ {
+		if s <= lastStep {
+			continue
+		}
+		want, err := hotp(secret, s)
+		if err == nil && subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
+// Computes the HOTP value of RFC 4226 for the counter.
+func hotp(secret string, counter int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000), nil
+}
+
+// Generates recovery codes formatted as xxxxx-xxxxx and their hashes.
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	for range recoveryCodes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(b32.EncodeToString(b))[:10]
+		code = code[:5] + "-" + code[5:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// Hashes the recovery code ignoring case and separators.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,389 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"github.com/google/uuid"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+var (
+	errNoUsername  = errors.New("username not provided")
+	errNoPassword  = errors.New("password not provided")
+	errNotEnrolled = errors.New("authenticator is not enrolled")
+)
+
+// UserService authenticates users of the UserStore and manages them.
+// It is also the ProfileStore of the users.
+type UserService struct {
+	store  types.UserStore
+	params Params
+	// Serializes updates of users, so none undoes a concurrent one
+	// and a second factor code can't be replayed concurrently.
+	mu sync.Mutex
+	// Hash verified for unknown users, so they take as long as known ones.
+	dummyHash string
+	now       func() time.Time
+}
+
+// NewUserService creates a UserService hashing new passwords with the params.
+func NewUserService(store types.UserStore, params Params) (*UserService, error) {
+	dummy, err := HashPassword(uuid.NewString(), params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserService{
+		store:     store,
+		params:    params,
+		dummyHash: dummy,
+		now:       time.Now,
+	}, nil
+}
+
+// Authenticate checks the password of the user and
+// rehashes it if the hash parameters have changed.
+func (s *UserService) Authenticate(ctx context.Context, username, password string) (*types.User, error) {
+	u, err := s.store.UserByUsername(ctx, username)
+	if errors.Is(err, types.ErrNotFound) {
+		_, _, _ = VerifyPassword(s.dummyHash, password, s.params)
+		return nil, types.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ok, rehash, err := VerifyPassword(u.PasswordHash, password, s.params)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || u.Disabled {
+		return nil, types.ErrInvalidCredentials
+	}
+
+	if rehash {
+		if err := s.rehash(ctx, u, password); err != nil {
+			log.Printf("couldn't rehash password of user %s: %v", u.ID, err)
+		}
+	}
+
+	return u, nil
+}
+
+// Replaces the verified password hash of the user with one of the
+// current params, unless the password was changed since.
+func (s *UserService) rehash(ctx context.Context, u *types.User, password string) error {
+	hash, err := HashPassword(password, s.params)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, err := s.store.User(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if cur.PasswordHash != u.PasswordHash {
+		return nil
+	}
+	cur.PasswordHash = hash
+
+	return s.store.Update(ctx, cur)
+}
+
+// CreateUser creates a user with the password.
+func (s *UserService) CreateUser(ctx context.Context, username, password string, profile map[string]any) (*types.User, error) {
+	if username == "" {
+		return nil, errNoUsername
+	}
+	if password == "" {
+		return nil, errNoPassword
+	}
+
+	hash, err := HashPassword(password, s.params)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now().UTC().Truncate(time.Second)
+	u := &types.User{
+		ID:           uuid.NewString(),
+		Username:     username,
+		PasswordHash: hash,
+		Profile:      profile,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.store.Create(ctx, u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// User returns the user.
+func (s *UserService) User(ctx context.Context, id string) (*types.User, error) {
+	return s.store.User(ctx, id)
+}
+
+// UserByUsername returns the user with the username.
+func (s *UserService) UserByUsername(ctx context.Context, username string) (*types.User, error) {
+	return s.store.UserByUsername(ctx, username)
+}
+
+// Users returns all users.
+func (s *UserService) Users(ctx context.Context) ([]*types.User, error) {
+	return s.store.Users(ctx)
+}
+
+// UpdateUser changes the password, profile, status or roles of the user.
+// Profile claims set to null are removed.
+func (s *UserService) UpdateUser(ctx context.Context, id string, upd types.UserUpdate) (*types.User, error) {
+	var hash string
+	if upd.Password != nil {
+		if *upd.Password == "" {
+			return nil, errNoPassword
+		}
+		var err error
+		if hash, err = HashPassword(*upd.Password, s.params); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.store.User(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if hash != "" {
+		u.PasswordHash = hash
+	}
+	if len(upd.Profile) > 0 {
+		if u.Profile == nil {
+			u.Profile = make(map[string]any, len(upd.Profile))
+		}
+		maps.Copy(u.Profile, upd.Profile)
+		maps.DeleteFunc(u.Profile, func(_ string, v any) bool { return v == nil })
+	}
+	if upd.Disabled != nil {
+		u.Disabled = *upd.Disabled
+	}
+	if upd.ResetMFA {
+		u.TOTP = nil
+	}
+	if upd.Roles != nil {
+		u.Roles = normalizeRoles(*upd.Roles)
+	}
+	u.UpdatedAt = s.now().UTC().Truncate(time.Second)
+
+	if err := s.store.Update(ctx, u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// DeleteUser deletes the user.
+func (s *UserService) DeleteUser(ctx context.Context, id string) error {
+	return s.store.Delete(ctx, id)
+}
+
+// Roles returns the roles of the user, so UserService
+// is a RoleResolver of tokens issued to users.
+func (s *UserService) Roles(ctx context.Context, id string) ([]string, error) {
+	u, err := s.store.User(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.Roles, nil
+}
+
+// Sorts the roles and drops empty and duplicate ones.
+func normalizeRoles(roles []string) []string {
+	roles = slices.DeleteFunc(slices.Clone(roles), func(r string) bool { return r == "" })
+	slices.Sort(roles)
+	return slices.Compact(roles)
+}
+
+// EnrollTOTP generates a new authenticator secret of the user.
+// It is used for logins once confirmed, until then a confirmed
+// authenticator and its recovery codes stay in use.
+func (s *UserService) EnrollTOTP(ctx context.Context, id, issuer string) (*types.TOTPEnrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.store.User(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	uri := TOTPURI(issuer, u.Username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.MFA() {
+		u.TOTP.PendingSecret = secret
+	} else {
+		u.TOTP = &types.TOTP{Secret: secret}
+	}
+	u.UpdatedAt = s.now().UTC().Truncate(time.Second)
+	if err := s.store.Update(ctx, u); err != nil {
+		return nil, err
+	}
+
+	return &types.TOTPEnrollment{Secret: secret, URI: uri, QRCode: png}, nil
+}
+
+// ConfirmTOTP enables the enrolled authenticator and replaces recovery codes.
+func (s *UserService) ConfirmTOTP(ctx context.Context, id, code string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.store.User(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.TOTP == nil || u.TOTP.Confirmed && u.TOTP.PendingSecret == "" {
+		return nil, errNotEnrolled
+	}
+
+	secret, lastStep := u.TOTP.Secret, u.TOTP.LastStep
+	if u.TOTP.PendingSecret != "" {
+		secret, lastStep = u.TOTP.PendingSecret, 0
+	}
+	step, ok := verifyTOTP(secret, code, s.now(), lastStep)
+	if !ok {
+		return nil, types.ErrInvalidCredentials
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	u.TOTP.Secret = secret
+	u.TOTP.PendingSecret = ""
+	u.TOTP.Confirmed = true
+	u.TOTP.LastStep = step
+	u.TOTP.RecoveryCodes = hashes
+	u.UpdatedAt = s.now().UTC().Truncate(time.Second)
+	if err := s.store.Update(ctx, u); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// VerifyTOTP checks the code of the confirmed authenticator or uses up a recovery code.
+func (s *UserService) VerifyTOTP(ctx context.Context, id, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.store.User(ctx, id)
+	if errors.Is(err, types.ErrNotFound) {
+		return types.ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	if !u.MFA() || u.Disabled {
+		return types.ErrInvalidCredentials
+	}
+
+	if step, ok := verifyTOTP(u.TOTP.Secret, code, s.now(), u.TOTP.LastStep); ok {
+		u.TOTP.LastStep = step
+	} else {
+		i := slices.Index(u.TOTP.RecoveryCodes, hashRecoveryCode(code))
+		if i < 0 {
+			return types.ErrInvalidCredentials
+		}
+		u.TOTP.RecoveryCodes = slices.Delete(u.TOTP.RecoveryCodes, i, i+1)
+	}
+
+	return s.store.Update(ctx, u)
+}
+
+// AddCredential registers the WebAuthn credential of the user.
+func (s *UserService) AddCredential(ctx context.Context, id string, cred types.WebAuthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.store.User(ctx, id)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(u.Credentials, func(c types.WebAuthnCredential) bool { return bytes.Equal(c.ID, cred.ID) }) {
+		return types.ErrAlreadyExists
+	}
+
+	now := s.now().UTC().Truncate(time.Second)
+	cred.CreatedAt = now
+	u.Credentials = append(u.Credentials, cred)
+	u.UpdatedAt = now
+
+	return s.store.Update(ctx, u)
+}
+
+// UseCredential records an assertion of the credential. A counter that
+// didn't increase means the authenticator may have been cloned.
+func (s *UserService) UseCredential(ctx context.Context, id string, credID []byte, signCount uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.store.User(ctx, id)
+	if errors.Is(err, types.ErrNotFound) {
+		return types.ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(u.Credentials, func(c types.WebAuthnCredential) bool { return bytes.Equal(c.ID, credID) })
+	if i < 0 || u.Disabled {
+		return types.ErrInvalidCredentials
+	}
+
+	cred := &u.Credentials[i]
+	// Authenticators that don't count always report zero.
+	if (cred.SignCount != 0 || signCount != 0) && signCount <= cred.SignCount {
+		log.Printf("sign counter of credential of user %s didn't increase: %d <= %d", u.ID, signCount, cred.SignCount)
+		return types.ErrInvalidCredentials
+	}
+	cred.SignCount = signCount
+	cred.LastUsedAt = s.now().UTC().Truncate(time.Second)
+
+	return s.store.Update(ctx, u)
+}
+
+// Profile returns the profile claims of the user with preferred_username.
+func (s *UserService) Profile(ctx context.Context, sub string) (map[string]any, error) {
+	u, err := s.store.User(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := map[string]any{"preferred_username": u.Username}
+	maps.Copy(profile, u.Profile)
+
+	return profile, nil
+}
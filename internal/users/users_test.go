@@ -0,0 +1,480 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// Cheap parameters so tests run fast.
+var testParams = Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func newStores(t *testing.T) map[string]types.UserStore {
+	t.Helper()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	sqliteStore, err := NewSQLiteStore(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]types.UserStore{
+		"memory": NewMemoryStore(),
+		"sqlite": sqliteStore,
+	}
+}
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("s3cr3t", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		hash       string
+		password   string
+		params     Params
+		wantOK     bool
+		wantRehash bool
+		wantErr    bool
+	}{
+		"match": {
+			hash:     hash,
+			password: "s3cr3t",
+			params:   testParams,
+			wantOK:   true,
+		},
+		"mismatch": {
+			hash:     hash,
+			password: "other",
+			params:   testParams,
+		},
+		"outdated params": {
+			hash:       hash,
+			password:   "s3cr3t",
+			params:     DefaultParams,
+			wantOK:     true,
+			wantRehash: true,
+		},
+		"not argon2id": {
+			hash:     "$2a$10$abcdefghijklmnopqrstuv",
+			password: "s3cr3t",
+			params:   testParams,
+			wantErr:  true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ok, rehash, err := VerifyPassword(tt.hash, tt.password, tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("unexpected result: want=%v %v, got=%v %v", tt.wantOK, tt.wantRehash, ok, rehash)
+			}
+		})
+	}
+}
+
+func TestUserService(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc, err := NewUserService(store, testParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			u, err := svc.CreateUser(ctx, "jane", "s3cr3t", map[string]any{"name": "Jane Doe"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.CreateUser(ctx, "jane", "other", nil); !errors.Is(err, types.ErrAlreadyExists) {
+				t.Errorf("username should be unique: %v", err)
+			}
+
+			got, err := svc.Authenticate(ctx, "jane", "s3cr3t")
+			if err != nil || got.ID != u.ID || got.Profile["name"] != "Jane Doe" {
+				t.Fatalf("user should authenticate: %+v, %v", got, err)
+			}
+			if _, err := svc.Authenticate(ctx, "jane", "wrong"); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("wrong password shouldn't authenticate: %v", err)
+			}
+			if _, err := svc.Authenticate(ctx, "john", "s3cr3t"); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("unknown user shouldn't authenticate: %v", err)
+			}
+
+			// Hashes of older parameters are replaced on login.
+			newer := testParams
+			newer.Iterations = 2
+			svc.params = newer
+			if _, err := svc.Authenticate(ctx, "jane", "s3cr3t"); err != nil {
+				t.Fatal(err)
+			}
+			stored, _ := store.User(ctx, u.ID)
+			if _, rehash, _ := VerifyPassword(stored.PasswordHash, "s3cr3t", newer); rehash {
+				t.Error("password should be rehashed with the new parameters")
+			}
+
+			password, disabled, roles := "n3w", true, []string{"viewer", "admin", "viewer", ""}
+			_, err = svc.UpdateUser(ctx, u.ID, types.UserUpdate{
+				Password: &password,
+				Profile:  map[string]any{"name": nil, "email": "jane@example.com"},
+				Disabled: &disabled,
+				Roles:    &roles,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.Authenticate(ctx, "jane", "n3w"); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("disabled user shouldn't authenticate: %v", err)
+			}
+			profile, _ := svc.Profile(ctx, u.ID)
+			if _, ok := profile["name"]; ok || profile["email"] != "jane@example.com" || profile["preferred_username"] != "jane" {
+				t.Errorf("unexpected profile: %v", profile)
+			}
+			if got, _ := svc.Roles(ctx, u.ID); !slices.Equal(got, []string{"admin", "viewer"}) {
+				t.Errorf("unexpected roles: %v", got)
+			}
+			if _, err := svc.Roles(ctx, "missing"); !errors.Is(err, types.ErrNotFound) {
+				t.Errorf("missing user shouldn't have roles: %v", err)
+			}
+
+			_, _ = svc.CreateUser(ctx, "adam", "s3cr3t", nil)
+			list, _ := svc.Users(ctx)
+			if len(list) != 2 || list[0].Username != "adam" {
+				t.Errorf("users should be ordered by username: %+v", list)
+			}
+
+			if err := svc.DeleteUser(ctx, u.ID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.User(ctx, u.ID); !errors.Is(err, types.ErrNotFound) {
+				t.Errorf("deleted user shouldn't be found: %v", err)
+			}
+			if err := svc.DeleteUser(ctx, u.ID); !errors.Is(err, types.ErrNotFound) {
+				t.Errorf("missing user shouldn't be deleted: %v", err)
+			}
+		})
+	}
+}
+
+// Runs change once the user is first looked up by username, as if
+// changed while being authenticated.
+type changingStore struct {
+	types.UserStore
+	change func()
+}
+
+func (s *changingStore) UserByUsername(ctx context.Context, username string) (*types.User, error) {
+	u, err := s.UserStore.UserByUsername(ctx, username)
+	if s.change != nil {
+		change := s.change
+		s.change = nil
+		change()
+	}
+
+	return u, err
+}
+
+func TestUserServiceUpdateDuringRehash(t *testing.T) {
+	ctx := context.Background()
+	newer := testParams
+	newer.Iterations = 2
+
+	tests := map[string]struct {
+		disabled bool
+		password string
+	}{
+		"disabled":         {disabled: true, password: "s3cr3t"},
+		"password changed": {password: "n3w"},
+	}
+	for name, tc := range tests {
+		for storeName, store := range newStores(t) {
+			t.Run(name+"/"+storeName, func(t *testing.T) {
+				cs := &changingStore{UserStore: store}
+				svc, err := NewUserService(cs, testParams)
+				if err != nil {
+					t.Fatal(err)
+				}
+				u, err := svc.CreateUser(ctx, "jane", "s3cr3t", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				upd := types.UserUpdate{Disabled: &tc.disabled}
+				if tc.password != "s3cr3t" {
+					upd.Password = &tc.password
+				}
+				svc.params = newer
+				cs.change = func() {
+					if _, err := svc.UpdateUser(ctx, u.ID, upd); err != nil {
+						t.Error(err)
+					}
+				}
+				if _, err := svc.Authenticate(ctx, "jane", "s3cr3t"); err != nil {
+					t.Fatal(err)
+				}
+
+				stored, _ := store.User(ctx, u.ID)
+				if stored.Disabled != tc.disabled {
+					t.Errorf("status is not the same: want=%v, got=%v", tc.disabled, stored.Disabled)
+				}
+				if ok, _, _ := VerifyPassword(stored.PasswordHash, tc.password, newer); !ok {
+					t.Errorf("password %q should be kept", tc.password)
+				}
+			})
+		}
+	}
+}
+
+func TestTOTPCode(t *testing.T) {
+	// SHA-1 vectors of RFC 6238 appendix B truncated to 6 digits.
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	tests := map[string]struct {
+		unix int64
+		want string
+	}{
+		"59":         {unix: 59, want: "287082"},
+		"1111111109": {unix: 1111111109, want: "081804"},
+		"1111111111": {unix: 1111111111, want: "050471"},
+		"1234567890": {unix: 1234567890, want: "005924"},
+		"2000000000": {unix: 2000000000, want: "279037"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := TOTPCode(secret, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("codes are not the same: want=%s, got=%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUserServiceTOTP(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc, err := NewUserService(store, testParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Unix(1700000000, 0)
+			svc.now = func() time.Time { return now }
+
+			u, _ := svc.CreateUser(ctx, "jane", "s3cr3t", nil)
+			enrollment, err := svc.EnrollTOTP(ctx, u.ID, "auth.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(enrollment.URI, "otpauth://totp/auth.example.com:jane?") || len(enrollment.QRCode) == 0 {
+				t.Errorf("unexpected enrollment: %s", enrollment.URI)
+			}
+			if err := svc.VerifyTOTP(ctx, u.ID, mustTOTPCode(t, enrollment.Secret, now)); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("unconfirmed authenticator shouldn't verify: %v", err)
+			}
+
+			if _, err := svc.ConfirmTOTP(ctx, u.ID, "000000"); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("wrong code shouldn't confirm: %v", err)
+			}
+			recovery, err := svc.ConfirmTOTP(ctx, u.ID, mustTOTPCode(t, enrollment.Secret, now))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recovery) != recoveryCodes {
+				t.Errorf("recovery codes are not the same: want=%d, got=%d", recoveryCodes, len(recovery))
+			}
+			if got, _ := svc.User(ctx, u.ID); !got.MFA() {
+				t.Error("confirmed authenticator should be required")
+			}
+
+			// The code used to confirm and earlier ones are replays.
+			if err := svc.VerifyTOTP(ctx, u.ID, mustTOTPCode(t, enrollment.Secret, now)); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("used code shouldn't verify: %v", err)
+			}
+			if err := svc.VerifyTOTP(ctx, u.ID, mustTOTPCode(t, enrollment.Secret, now.Add(-totpPeriod))); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("earlier code shouldn't verify: %v", err)
+			}
+			if err := svc.VerifyTOTP(ctx, u.ID, mustTOTPCode(t, enrollment.Secret, now.Add(2*totpPeriod))); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("code outside of the window shouldn't verify: %v", err)
+			}
+			// A code of the next step is accepted for clock drift.
+			if err := svc.VerifyTOTP(ctx, u.ID, mustTOTPCode(t, enrollment.Secret, now.Add(totpPeriod))); err != nil {
+				t.Errorf("code within the window should verify: %v", err)
+			}
+
+			if err := svc.VerifyTOTP(ctx, u.ID, strings.ToUpper(recovery[0])); err != nil {
+				t.Errorf("recovery code should verify: %v", err)
+			}
+			if err := svc.VerifyTOTP(ctx, u.ID, recovery[0]); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("recovery code should be used once: %v", err)
+			}
+			stored, _ := store.User(ctx, u.ID)
+			if len(stored.TOTP.RecoveryCodes) != recoveryCodes-1 || slices.Contains(stored.TOTP.RecoveryCodes, recovery[1]) {
+				t.Errorf("only hashes of unused recovery codes should be stored: %v", stored.TOTP.RecoveryCodes)
+			}
+
+			// A replacement is pending until confirmed, the old authenticator stays in use.
+			now = now.Add(2 * totpPeriod)
+			replacement, err := svc.EnrollTOTP(ctx, u.ID, "auth.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := svc.User(ctx, u.ID); !got.MFA() || len(got.TOTP.RecoveryCodes) != recoveryCodes-1 {
+				t.Error("confirmed authenticator should stay required on re-enrollment")
+			}
+			if err := svc.VerifyTOTP(ctx, u.ID, mustTOTPCode(t, enrollment.Secret, now)); err != nil {
+				t.Errorf("old authenticator should verify until the replacement is confirmed: %v", err)
+			}
+			if err := svc.VerifyTOTP(ctx, u.ID, mustTOTPCode(t, replacement.Secret, now)); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("unconfirmed replacement shouldn't verify: %v", err)
+			}
+			if _, err := svc.ConfirmTOTP(ctx, u.ID, mustTOTPCode(t, replacement.Secret, now)); err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.VerifyTOTP(ctx, u.ID, mustTOTPCode(t, enrollment.Secret, now.Add(totpPeriod))); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("replaced authenticator shouldn't verify: %v", err)
+			}
+			if err := svc.VerifyTOTP(ctx, u.ID, recovery[1]); !errors.Is(err, types.ErrInvalidCredentials) {
+				t.Errorf("recovery codes should be replaced: %v", err)
+			}
+
+			if _, err := svc.UpdateUser(ctx, u.ID, types.UserUpdate{ResetMFA: true}); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := svc.User(ctx, u.ID); got.MFA() || got.TOTP != nil {
+				t.Error("authenticator should be removed on reset")
+			}
+		})
+	}
+}
+
+func TestUserServiceResetMFADuringVerify(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc, err := NewUserService(store, testParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for range 20 {
+				u, _ := svc.CreateUser(ctx, uuid.NewString(), "s3cr3t", nil)
+				enrollment, _ := svc.EnrollTOTP(ctx, u.ID, "auth.example.com")
+				recovery, err := svc.ConfirmTOTP(ctx, u.ID, mustTOTPCode(t, enrollment.Secret, time.Now()))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var wg sync.WaitGroup
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					_ = svc.VerifyTOTP(ctx, u.ID, recovery[0])
+				}()
+				go func() {
+					defer wg.Done()
+					if _, err := svc.UpdateUser(ctx, u.ID, types.UserUpdate{ResetMFA: true}); err != nil {
+						t.Error(err)
+					}
+				}()
+				wg.Wait()
+
+				if stored, _ := store.User(ctx, u.ID); stored.TOTP != nil {
+					t.Fatal("using a recovery code shouldn't undo the reset")
+				}
+			}
+		})
+	}
+}
+
+func mustTOTPCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := TOTPCode(secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestUserServiceCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			svc, err := NewUserService(store, testParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jane, _ := svc.CreateUser(ctx, "jane", "s3cr3t", nil)
+			john, _ := svc.CreateUser(ctx, "john", "s3cr3t", nil)
+			cred := types.WebAuthnCredential{ID: []byte("cred-1"), PublicKey: []byte("key"), SignCount: 1}
+			if err := svc.AddCredential(ctx, jane.ID, cred); err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.AddCredential(ctx, john.ID, cred); !errors.Is(err, types.ErrAlreadyExists) {
+				t.Errorf("credential should belong to one user: %v", err)
+			}
+
+			tests := []struct {
+				name      string
+				credID    string
+				signCount uint32
+				wantErr   bool
+			}{
+				{name: "increased counter", credID: "cred-1", signCount: 5},
+				{name: "same counter", credID: "cred-1", signCount: 5, wantErr: true},
+				{name: "decreased counter", credID: "cred-1", signCount: 2, wantErr: true},
+				{name: "unknown credential", credID: "cred-2", signCount: 6, wantErr: true},
+			}
+			for _, tt := range tests {
+				err := svc.UseCredential(ctx, jane.ID, []byte(tt.credID), tt.signCount)
+				if tt.wantErr != errors.Is(err, types.ErrInvalidCredentials) {
+					t.Errorf("%s: unexpected error: %v", tt.name, err)
+				}
+			}
+
+			got, _ := svc.User(ctx, jane.ID)
+			if len(got.Credentials) != 1 || got.Credentials[0].SignCount != 5 || string(got.Credentials[0].PublicKey) != "key" || got.Credentials[0].LastUsedAt.IsZero() {
+				t.Errorf("unexpected credentials: %+v", got.Credentials)
+			}
+
+			// Authenticators without counters always report zero.
+			_ = svc.AddCredential(ctx, john.ID, types.WebAuthnCredential{ID: []byte("cred-3"), PublicKey: []byte("key")})
+			for range 2 {
+				if err := svc.UseCredential(ctx, john.ID, []byte("cred-3"), 0); err != nil {
+					t.Errorf("credential without counter should be usable: %v", err)
+				}
+			}
+
+			if err := svc.DeleteUser(ctx, jane.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.AddCredential(ctx, john.ID, cred); err != nil {
+				t.Errorf("credentials of deleted users should be removed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE algorithms of RFC 9053 offered to authenticators.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms offered in creation options, most preferred first.
+var Algorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters.
+const (
+	coseKty = 1
+	coseAlg = 3
+	// Curve of EC2 and OKP keys, modulus of RSA keys.
+	coseCrvOrN = -1
+	// X of EC2 and OKP keys, exponent of RSA keys.
+	coseXOrE = -2
+	coseY    = -3
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// Credential public key with its algorithm.
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// Decodes a COSE_Key of a supported algorithm.
+func parsePublicKey(data []byte) (*publicKey, error) {
+	var params map[int64]any
+	if err := cbor.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("%w: malformed public key: %v", ErrVerification, err)
+	}
+
+	kty, _ := intParam(params, coseKty)
+	alg, _ := intParam(params, coseAlg)
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := intParam(params, coseCrvOrN)
+		x, okX := params[coseXOrE].([]byte)
+		y, okY := params[coseY].([]byte)
+		if crv != crvP256 || !okX || !okY || len(x) != 32 || len(y) != 32 {
+			break
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			break
+		}
+		return &publicKey{alg: alg, key: key}, nil
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := intParam(params, coseCrvOrN)
+		x, ok := params[coseXOrE].([]byte)
+		if crv != crvEd25519 || !ok || len(x) != ed25519.PublicKeySize {
+			break
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, okN := params[coseCrvOrN].([]byte)
+		e, okE := params[coseXOrE].([]byte)
+		if !okN || !okE || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			break
+		}
+		return &publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unsupported public key (kty %d, alg %d)", ErrVerification, kty, alg)
+}
+
+// Pairs a certificate key with the COSE algorithm of its signature.
+func newPublicKey(alg int64, key crypto.PublicKey) (*publicKey, error) {
+	switch key.(type) {
+	case *ecdsa.PublicKey:
+		if alg == AlgES256 {
+			return &publicKey{alg: alg, key: key}, nil
+		}
+	case ed25519.PublicKey:
+		if alg == AlgEdDSA {
+			return &publicKey{alg: alg, key: key}, nil
+		}
+	case *rsa.PublicKey:
+		if alg == AlgRS256 {
+			return &publicKey{alg: alg, key: key}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unsupported attestation algorithm %d", ErrVerification, alg)
+}
+
+// Verifies the signature of the data.
+func (k *publicKey) verify(data, sig []byte) error {
+	var ok bool
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, sum[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	}
+	if !ok {
+		return fmt.Errorf("%w: invalid signature", ErrVerification)
+	}
+
+	return nil
+}
+
+// Reads an integer parameter, CBOR decodes them as int64 or uint64.
+func intParam(params map[int64]any, label int64) (int64, bool) {
+	switch v := params[label].(type) {
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,168 @@
+package webauthn
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Bytes is binary data encoded as unpadded base64url in JSON,
+// like the JSON serialization of WebAuthn Level 3.
+type Bytes []byte
+
+// MarshalJSON encodes the bytes as base64url.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes base64url with or without padding.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = raw
+
+	return nil
+}
+
+// CredentialCreationOptions are passed to navigator.credentials.create.
+type CredentialCreationOptions struct {
+	PublicKey PublicKeyCredentialCreationOptions `json:"publicKey"`
+}
+
+// PublicKeyCredentialCreationOptions of a registration ceremony.
+type PublicKeyCredentialCreationOptions struct {
+	// Challenge is base64url encoded.
+	Challenge              string                 `json:"challenge"`
+	RP                     RPEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation,omitempty"`
+}
+
+// RPEntity names the relying party.
+type RPEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity is the account a credential is created for.
+type UserEntity struct {
+	// ID is the user handle returned by assertions.
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is an accepted key type.
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// CredentialDescriptor refers to a registered credential.
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+// AuthenticatorSelection states the requirements of authenticators.
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey,omitempty"`
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+// CredentialRequestOptions are passed to navigator.credentials.get.
+type CredentialRequestOptions struct {
+	PublicKey PublicKeyCredentialRequestOptions `json:"publicKey"`
+}
+
+// PublicKeyCredentialRequestOptions of an assertion ceremony.
+type PublicKeyCredentialRequestOptions struct {
+	// Challenge is base64url encoded.
+	Challenge string `json:"challenge"`
+	RPID      string `json:"rpId"`
+	Timeout   int64  `json:"timeout,omitempty"`
+	// AllowCredentials is empty for discoverable credentials (passkeys).
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification,omitempty"`
+}
+
+// RegistrationResponse is the credential created by navigator.credentials.create.
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AttestationObject Bytes `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the credential returned by navigator.credentials.get.
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AuthenticatorData Bytes `json:"authenticatorData"`
+		Signature         Bytes `json:"signature"`
+		// UserHandle is the user ID of a discoverable credential.
+		UserHandle Bytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// CreationOptions returns the options of a registration of the user, which
+// excludes the credentials it already has. User verification is required.
+func (rp *RelyingParty) CreationOptions(challenge string, user UserEntity, exclude [][]byte, timeout time.Duration) *CredentialCreationOptions {
+	params := make([]CredentialParameter, 0, len(Algorithms))
+	for _, alg := range Algorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	return &CredentialCreationOptions{PublicKey: PublicKeyCredentialCreationOptions{
+		Challenge:          challenge,
+		RP:                 RPEntity{ID: rp.ID, Name: rp.Name},
+		User:               user,
+		PubKeyCredParams:   params,
+		Timeout:            timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}}
+}
+
+// RequestOptions returns the options of an assertion by one of the
+// allowed credentials, or by any passkey if none are given.
+func (rp *RelyingParty) RequestOptions(challenge string, allow [][]byte, timeout time.Duration) *CredentialRequestOptions {
+	return &CredentialRequestOptions{PublicKey: PublicKeyCredentialRequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          timeout.Milliseconds(),
+		AllowCredentials: descriptors(allow),
+		UserVerification: "required",
+	}}
+}
+
+// Describes the credential IDs.
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	var out []CredentialDescriptor
+	for _, id := range ids {
+		out = append(out, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+
+	return out
+}
@@ -0,0 +1,304 @@
+// Package webauthn verifies WebAuthn registration and assertion
+// ceremonies of passkeys and security keys.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/danblok/auth/pkg/types"
+)
+
+// ErrVerification is wrapped by errors of ceremonies that fail verification.
+var ErrVerification = errors.New("webauthn verification failed")
+
+// Client data types of the ceremonies.
+const (
+	typeCreate = "webauthn.create"
+	typeGet    = "webauthn.get"
+)
+
+// Flags of authenticator data.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+	flagExtensions   = 0x80
+)
+
+// RelyingParty verifies ceremonies of the relying party.
+type RelyingParty struct {
+	// ID is the domain credentials are scoped to, e.g. example.com.
+	ID   string
+	Name string
+	// Origins allowed to run ceremonies, e.g. https://login.example.com.
+	Origins []string
+
+	secretOnce sync.Once
+	secret     []byte
+}
+
+// SyntheticCredentialID returns a credential ID derived from the
+// username with a secret of the process, so logins of unknown users
+// allow the same credential each time like those of known users.
+func (rp *RelyingParty) SyntheticCredentialID(username string) []byte {
+	rp.secretOnce.Do(func() {
+		rp.secret = make([]byte, 32)
+		if _, err := rand.Read(rp.secret); err != nil {
+			panic(err)
+		}
+	})
+
+	mac := hmac.New(sha256.New, rp.secret)
+	mac.Write([]byte(username))
+	return mac.Sum(nil)
+}
+
+// ClientData is the client data passed to the authenticator.
+type ClientData struct {
+	Type string `json:"type"`
+	// Challenge is base64url encoded.
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+}
+
+// ParseClientData decodes the client data JSON, e.g. to
+// look up the ceremony of its challenge.
+func ParseClientData(raw []byte) (*ClientData, error) {
+	var cd ClientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, fmt.Errorf("%w: malformed client data: %v", ErrVerification, err)
+	}
+	if cd.Challenge == "" {
+		return nil, fmt.Errorf("%w: client data has no challenge", ErrVerification)
+	}
+
+	return &cd, nil
+}
+
+// AuthenticatorData is the data signed by the authenticator.
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// Attested credential data of registrations.
+	AAGUID       []byte
+	CredentialID []byte
+	// PublicKey is COSE encoded.
+	PublicKey []byte
+}
+
+// ParseAuthenticatorData decodes the binary authenticator data.
+func ParseAuthenticatorData(b []byte) (*AuthenticatorData, error) {
+	if len(b) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrVerification)
+	}
+
+	ad := &AuthenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	rest := b[37:]
+
+	if ad.Flags&flagAttestedData != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data too short", ErrVerification)
+		}
+		ad.AAGUID = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || len(rest) < n {
+			return nil, fmt.Errorf("%w: malformed credential id", ErrVerification)
+		}
+		ad.CredentialID, rest = rest[:n], rest[n:]
+
+		var key cbor.RawMessage
+		var err error
+		if rest, err = cbor.UnmarshalFirst(rest, &key); err != nil {
+			return nil, fmt.Errorf("%w: malformed public key: %v", ErrVerification, err)
+		}
+		ad.PublicKey = key
+	}
+
+	// Extension outputs aren't used.
+	if ad.Flags&flagExtensions == 0 && len(rest) > 0 {
+		return nil, fmt.Errorf("%w: trailing authenticator data", ErrVerification)
+	}
+
+	return ad, nil
+}
+
+// Attestation object of RFC 8809 / WebAuthn section 6.5.
+type attestationObject struct {
+	Format   string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+// Statement of the packed attestation format.
+type packedStatement struct {
+	Alg int64    `cbor:"alg"`
+	Sig []byte   `cbor:"sig"`
+	X5C [][]byte `cbor:"x5c,omitempty"`
+}
+
+// VerifyRegistration verifies the response to the creation options with
+// the challenge and returns the new credential. Attestation is checked
+// for integrity, not against trusted roots, as dashboards accept any
+// authenticator. The user must be verified.
+func (rp *RelyingParty) VerifyRegistration(resp *RegistrationResponse, challenge string) (*types.WebAuthnCredential, error) {
+	clientData := resp.Response.ClientDataJSON
+	if err := rp.verifyClientData(clientData, typeCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	var att attestationObject
+	if err := cbor.Unmarshal(resp.Response.AttestationObject, &att); err != nil {
+		return nil, fmt.Errorf("%w: malformed attestation object: %v", ErrVerification, err)
+	}
+	ad, err := ParseAuthenticatorData(att.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(ad); err != nil {
+		return nil, err
+	}
+	if ad.Flags&flagAttestedData == 0 {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrVerification)
+	}
+	if !bytes.Equal(ad.CredentialID, resp.RawID) {
+		return nil, fmt.Errorf("%w: credential id mismatch", ErrVerification)
+	}
+
+	key, err := parsePublicKey(ad.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientData)
+	signed := slices.Concat(att.AuthData, clientDataHash[:])
+	switch att.Format {
+	case "none":
+	case "packed":
+		var stmt packedStatement
+		if err := cbor.Unmarshal(att.AttStmt, &stmt); err != nil {
+			return nil, fmt.Errorf("%w: malformed attestation statement: %v", ErrVerification, err)
+		}
+		if err := verifyPacked(stmt, key, signed); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported attestation format %q", ErrVerification, att.Format)
+	}
+
+	return &types.WebAuthnCredential{
+		ID:        slices.Clone(ad.CredentialID),
+		PublicKey: slices.Clone(ad.PublicKey),
+		SignCount: ad.SignCount,
+		AAGUID:    slices.Clone(ad.AAGUID),
+	}, nil
+}
+
+// Verifies a packed attestation signature, made by the credential
+// key itself or by the attestation certificate.
+func verifyPacked(stmt packedStatement, key *publicKey, signed []byte) error {
+	if len(stmt.X5C) == 0 {
+		if stmt.Alg != key.alg {
+			return fmt.Errorf("%w: self attestation algorithm mismatch", ErrVerification)
+		}
+		return key.verify(signed, stmt.Sig)
+	}
+
+	cert, err := x509.ParseCertificate(stmt.X5C[0])
+	if err != nil {
+		return fmt.Errorf("%w: malformed attestation certificate: %v", ErrVerification, err)
+	}
+	certKey, err := newPublicKey(stmt.Alg, cert.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	return certKey.verify(signed, stmt.Sig)
+}
+
+// VerifyAssertion verifies the response to the request options with the
+// challenge and the stored credential, and returns the new signature counter.
+// Comparing the counter is up to the caller. The user must be verified.
+func (rp *RelyingParty) VerifyAssertion(resp *AssertionResponse, challenge string, cred *types.WebAuthnCredential) (uint32, error) {
+	if !bytes.Equal(resp.RawID, cred.ID) {
+		return 0, fmt.Errorf("%w: credential id mismatch", ErrVerification)
+	}
+
+	clientData := resp.Response.ClientDataJSON
+	if err := rp.verifyClientData(clientData, typeGet, challenge); err != nil {
+		return 0, err
+	}
+
+	ad, err := ParseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(ad); err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	if err := key.verify(slices.Concat(resp.Response.AuthenticatorData, clientDataHash[:]), resp.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	return ad.SignCount, nil
+}
+
+// Checks the type, challenge and origin of the client data.
+func (rp *RelyingParty) verifyClientData(raw []byte, typ, challenge string) error {
+	cd, err := ParseClientData(raw)
+	if err != nil {
+		return err
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("%w: unexpected client data type %q", ErrVerification, cd.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrVerification)
+	}
+	if !slices.Contains(rp.Origins, cd.Origin) {
+		return fmt.Errorf("%w: origin %q not allowed", ErrVerification, cd.Origin)
+	}
+
+	return nil
+}
+
+// Checks the relying party and that the user was present and verified.
+func (rp *RelyingParty) verifyAuthenticatorData(ad *AuthenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(ad.RPIDHash, rpIDHash[:]) != 1 {
+		return fmt.Errorf("%w: relying party mismatch", ErrVerification)
+	}
+	if ad.Flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user not present", ErrVerification)
+	}
+	if ad.Flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user not verified", ErrVerification)
+	}
+
+	return nil
+}
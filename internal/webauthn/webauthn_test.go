@@ -0,0 +1,148 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danblok/auth/internal/webauthn"
+	"github.com/danblok/auth/internal/webauthn/webauthntest"
+)
+
+const origin = "https://login.example.com"
+
+var rp = &webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{origin}}
+
+func creationOptions(challenge string) webauthn.PublicKeyCredentialCreationOptions {
+	user := webauthn.UserEntity{ID: []byte("user-1"), Name: "jane", DisplayName: "Jane Doe"}
+	return rp.CreationOptions(challenge, user, nil, time.Minute).PublicKey
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	tests := map[string]struct {
+		format    string
+		flags     byte
+		origin    string
+		challenge string
+		rpID      string
+		wantErr   bool
+	}{
+		"none attestation": {
+			format: "none",
+		},
+		"packed self attestation": {
+			format: "packed",
+		},
+		"user not verified": {
+			format:  "none",
+			flags:   webauthntest.FlagUserPresent,
+			wantErr: true,
+		},
+		"other origin": {
+			format:  "none",
+			origin:  "https://evil.example.net",
+			wantErr: true,
+		},
+		"other challenge": {
+			format:    "none",
+			challenge: "other-challenge",
+			wantErr:   true,
+		},
+		"other relying party": {
+			format:  "none",
+			rpID:    "evil.example.net",
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := webauthntest.NewAuthenticator()
+			a.Format = tt.format
+			if tt.flags != 0 {
+				a.Flags = tt.flags
+			}
+			opts := creationOptions("challenge")
+			if tt.challenge != "" {
+				opts.Challenge = tt.challenge
+			}
+			if tt.rpID != "" {
+				opts.RP.ID = tt.rpID
+			}
+			at := origin
+			if tt.origin != "" {
+				at = tt.origin
+			}
+
+			resp, err := a.Register(at, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cred, err := rp.VerifyRegistration(resp, "challenge")
+			if tt.wantErr {
+				if !errors.Is(err, webauthn.ErrVerification) {
+					t.Errorf("registration should fail verification: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(cred.ID) != string(resp.RawID) || string(cred.AAGUID) != string(webauthntest.AAGUID) || len(cred.PublicKey) == 0 {
+				t.Errorf("unexpected credential: %+v", cred)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	a := webauthntest.NewAuthenticator()
+	resp, err := a.Register(origin, creationOptions("challenge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := rp.VerifyRegistration(resp, "challenge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authCred := a.Credentials()[0]
+
+	t.Run("valid", func(t *testing.T) {
+		resp, _ := a.Login(origin, rp.RequestOptions("login", nil, time.Minute).PublicKey)
+		signCount, err := rp.VerifyAssertion(resp, "login", cred)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signCount != authCred.SignCount {
+			t.Errorf("sign counts are not the same: want=%d, got=%d", authCred.SignCount, signCount)
+		}
+	})
+
+	t.Run("tampered signature", func(t *testing.T) {
+		resp, _ := a.Login(origin, rp.RequestOptions("login", nil, time.Minute).PublicKey)
+		resp.Response.Signature[len(resp.Response.Signature)-1] ^= 0xff
+		if _, err := rp.VerifyAssertion(resp, "login", cred); !errors.Is(err, webauthn.ErrVerification) {
+			t.Errorf("tampered signature should fail verification: %v", err)
+		}
+	})
+
+	t.Run("registration client data", func(t *testing.T) {
+		resp, _ := a.Login(origin, rp.RequestOptions("login", nil, time.Minute).PublicKey)
+		// Client data of the registration has the wrong type.
+		reg, _ := a.Register(origin, creationOptions("login"))
+		resp.Response.ClientDataJSON = reg.Response.ClientDataJSON
+		if _, err := rp.VerifyAssertion(resp, "login", cred); !errors.Is(err, webauthn.ErrVerification) {
+			t.Errorf("assertion should fail verification: %v", err)
+		}
+	})
+
+	t.Run("other credential", func(t *testing.T) {
+		other := webauthntest.NewAuthenticator()
+		_, _ = other.Register(origin, creationOptions("challenge"))
+		resp, _ := other.Login(origin, rp.RequestOptions("login", nil, time.Minute).PublicKey)
+		resp.RawID = cred.ID
+		if _, err := rp.VerifyAssertion(resp, "login", cred); !errors.Is(err, webauthn.ErrVerification) {
+			t.Errorf("assertion of another key should fail verification: %v", err)
+		}
+	})
+}
@@ -0,0 +1,204 @@
+// Package webauthntest provides a software authenticator that creates
+// registration and assertion responses for tests of WebAuthn ceremonies.
+package webauthntest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/danblok/auth/internal/webauthn"
+)
+
+// AAGUID of the software authenticator.
+var AAGUID = []byte("danblok-softauth")
+
+// Authenticator is a platform authenticator holding discoverable ES256
+// credentials in memory. Its flags and counters can be changed to
+// produce invalid responses.
+type Authenticator struct {
+	// Format of attestation statements, "none" or "packed" self attestation.
+	Format string
+	// Flags of the authenticator data, user present and verified by default.
+	Flags byte
+
+	credentials []*Credential
+}
+
+// Credential is a credential of the authenticator.
+type Credential struct {
+	ID         []byte
+	RPID       string
+	UserHandle []byte
+	Key        *ecdsa.PrivateKey
+	// SignCount is incremented before each assertion.
+	SignCount uint32
+}
+
+// Flags of authenticator data.
+const (
+	FlagUserPresent  = 0x01
+	FlagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// NewAuthenticator creates an authenticator without credentials.
+func NewAuthenticator() *Authenticator {
+	return &Authenticator{
+		Format: "none",
+		Flags:  FlagUserPresent | FlagUserVerified,
+	}
+}
+
+// Credentials returns the credentials created by the authenticator.
+func (a *Authenticator) Credentials() []*Credential {
+	return a.credentials
+}
+
+// Register creates a credential for the options at the origin.
+func (a *Authenticator) Register(origin string, opts webauthn.PublicKeyCredentialCreationOptions) (*webauthn.RegistrationResponse, error) {
+	if !slices.ContainsFunc(opts.PubKeyCredParams, func(p webauthn.CredentialParameter) bool { return p.Alg == webauthn.AlgES256 }) {
+		return nil, errors.New("ES256 is not accepted")
+	}
+	for _, c := range a.credentials {
+		if c.RPID == opts.RP.ID && slices.ContainsFunc(opts.ExcludeCredentials, func(d webauthn.CredentialDescriptor) bool { return bytes.Equal(d.ID, c.ID) }) {
+			return nil, errors.New("credential already registered")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	cred := &Credential{ID: id, RPID: opts.RP.ID, UserHandle: opts.User.ID, Key: key}
+
+	clientData, err := clientDataJSON("webauthn.create", opts.Challenge, origin)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := cbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: key.X.FillBytes(make([]byte, 32)),
+		-3: key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	authData := authenticatorData(opts.RP.ID, a.Flags|flagAttestedData, cred.SignCount)
+	authData = append(authData, AAGUID...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(id)))
+	authData = append(authData, id...)
+	authData = append(authData, publicKey...)
+
+	attStmt := map[string]any{}
+	if a.Format == "packed" {
+		sig, err := sign(key, authData, clientData)
+		if err != nil {
+			return nil, err
+		}
+		attStmt = map[string]any{"alg": webauthn.AlgES256, "sig": sig}
+	}
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      a.Format,
+		"attStmt":  attStmt,
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.credentials = append(a.credentials, cred)
+
+	resp := &webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(id),
+		RawID: id,
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AttestationObject = attestation
+
+	return resp, nil
+}
+
+// Login signs an assertion for the options at the origin with an allowed
+// credential, or with the latest discoverable credential of the relying party.
+func (a *Authenticator) Login(origin string, opts webauthn.PublicKeyCredentialRequestOptions) (*webauthn.AssertionResponse, error) {
+	var cred *Credential
+	for i := len(a.credentials) - 1; i >= 0; i-- {
+		c := a.credentials[i]
+		if c.RPID != opts.RPID {
+			continue
+		}
+		if len(opts.AllowCredentials) == 0 || slices.ContainsFunc(opts.AllowCredentials, func(d webauthn.CredentialDescriptor) bool { return bytes.Equal(d.ID, c.ID) }) {
+			cred = c
+			break
+		}
+	}
+	if cred == nil {
+		return nil, errors.New("no credential of the relying party")
+	}
+
+	return a.Assert(cred, origin, opts.Challenge)
+}
+
+// Assert signs an assertion of the challenge with the credential.
+func (a *Authenticator) Assert(cred *Credential, origin, challenge string) (*webauthn.AssertionResponse, error) {
+	clientData, err := clientDataJSON("webauthn.get", challenge, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	cred.SignCount++
+	authData := authenticatorData(cred.RPID, a.Flags, cred.SignCount)
+	sig, err := sign(cred.Key, authData, clientData)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(cred.ID),
+		RawID: cred.ID,
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = sig
+	resp.Response.UserHandle = cred.UserHandle
+
+	return resp, nil
+}
+
+// Encodes the client data of the ceremony like a browser.
+func clientDataJSON(typ, challenge, origin string) ([]byte, error) {
+	return json.Marshal(webauthn.ClientData{Type: typ, Challenge: challenge, Origin: origin})
+}
+
+// Encodes authenticator data without attested credential data.
+func authenticatorData(rpID string, flags byte, signCount uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, signCount)
+}
+
+// Signs the authenticator data and the client data hash.
+func sign(key *ecdsa.PrivateKey, authData, clientData []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientData)
+	sum := sha256.Sum256(slices.Concat(authData, clientDataHash[:]))
+	return ecdsa.SignASN1(rand.Reader, key, sum[:])
+}
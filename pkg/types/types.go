@@ -1,24 +1,377 @@
 package types
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTokenRevoked is returned by Validate for revoked tokens.
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrNotFound is returned by stores when an entry doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned by stores when a unique entry exists.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrInvalidCredentials is returned when a login fails.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrOtherClient is returned when a client revokes
+	// a token issued to another client.
+	ErrOtherClient = errors.New("token was issued to another client")
+)
 
 // TokenService interfaces out
 // implementations details of
 // services of different levels.
 type TokenService interface {
 	Validate(context.Context, []byte) error
-	Token(context.Context, []byte) ([]byte, error)
+	Token(context.Context, []byte, ...TokenOption) ([]byte, error)
+	Introspect(context.Context, []byte) (*Introspection, error)
+}
+
+// Machine-readable reasons why a token is not valid.
+const (
+	ReasonExpired       = "expired"
+	ReasonBadSignature  = "bad_signature"
+	ReasonMalformed     = "malformed"
+	ReasonNotYetValid   = "not_yet_valid"
+	ReasonRevoked       = "revoked"
+	ReasonInvalidClaims = "invalid_claims"
+	ReasonInvalid       = "invalid"
+)
+
+// TokenOptions are per-request overrides of claims of a new token.
+// Zero values mean server defaults.
+type TokenOptions struct {
+	TTL      time.Duration `json:"ttl,omitempty"`
+	Audience []string      `json:"aud,omitempty"`
+	Subject  string        `json:"sub,omitempty"`
+	// Claims are custom claims merged into the token as top-level claims.
+	Claims map[string]any `json:"claims,omitempty"`
+	// ServerClaims are authorization claims set by the server, e.g.
+	// scope and roles, which custom claims of requests can't set.
+	ServerClaims map[string]any `json:"server_claims,omitempty"`
+	// Actor is the RFC 8693 act claim of a delegated token.
+	Actor map[string]any `json:"act,omitempty"`
+	// IDToken makes the token an OpenID Connect ID token.
+	IDToken *IDTokenOptions `json:"id_token,omitempty"`
+}
+
+// IDTokenOptions are the claims specific to OpenID Connect ID tokens.
+type IDTokenOptions struct {
+	// ClientID is the client the token is issued to, it becomes aud and azp.
+	ClientID string    `json:"client_id"`
+	Nonce    string    `json:"nonce,omitempty"`
+	AuthTime time.Time `json:"auth_time,omitempty"`
+	// AccessToken issued alongside, its hash becomes at_hash.
+	AccessToken string `json:"-"`
+}
+
+// TokenOption overrides a claim of a new token.
+type TokenOption func(*TokenOptions)
+
+// WithTTL overrides lifetime of the token.
+func WithTTL(ttl time.Duration) TokenOption {
+	return func(o *TokenOptions) {
+		o.TTL = ttl
+	}
+}
+
+// WithAudience overrides the aud claim of the token.
+func WithAudience(aud ...string) TokenOption {
+	return func(o *TokenOptions) {
+		o.Audience = aud
+	}
+}
+
+// WithSubject sets the sub claim of the token.
+func WithSubject(sub string) TokenOption {
+	return func(o *TokenOptions) {
+		o.Subject = sub
+	}
 }
 
-// RequestID type is used by a context
-// in services to attach and receive
-// the request id of each request.
-type RequestID string
+// WithClaims merges custom claims into the token.
+// Registered claims can't be overwritten.
+func WithClaims(claims map[string]any) TokenOption {
+	return func(o *TokenOptions) {
+		if o.Claims == nil {
+			o.Claims = make(map[string]any, len(claims))
+		}
+		for k, v := range claims {
+			o.Claims[k] = v
+		}
+	}
+}
+
+// WithServerClaims merges authorization claims set by the server into
+// the token, e.g. scope, roles or client_id. Unlike WithClaims it may set
+// the claims reserved for the server, so values must not come from requests.
+func WithServerClaims(claims map[string]any) TokenOption {
+	return func(o *TokenOptions) {
+		if o.ServerClaims == nil {
+			o.ServerClaims = make(map[string]any, len(claims))
+		}
+		for k, v := range claims {
+			o.ServerClaims[k] = v
+		}
+	}
+}
+
+// WithActor sets the act claim naming the party acting on behalf of the subject.
+func WithActor(act map[string]any) TokenOption {
+	return func(o *TokenOptions) {
+		o.Actor = act
+	}
+}
+
+// AsIDToken issues an OpenID Connect ID token for the client.
+// The aud claim is the client and isn't checked against the allowed audiences.
+func AsIDToken(idt IDTokenOptions) TokenOption {
+	return func(o *TokenOptions) {
+		o.IDToken = &idt
+	}
+}
+
+// NewTokenOptions applies the options.
+func NewTokenOptions(opts ...TokenOption) TokenOptions {
+	var o TokenOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// KeyProvider publishes public keys
+// that verify issued tokens.
+type KeyProvider interface {
+	Keys(context.Context) (*JWKSet, error)
+}
+
+// KeyRotator promotes a new signing key at runtime.
+// Previous keys keep verifying tokens for the overlap duration.
+type KeyRotator interface {
+	RotateKey(ctx context.Context, privateKeyPEM []byte, overlap time.Duration) (string, error)
+}
+
+// Revoker invalidates issued tokens before they expire.
+type Revoker interface {
+	// RevokeToken revokes the given token until it expires.
+	RevokeToken(ctx context.Context, token []byte) error
+	// RevokeID revokes the token with the jti claim.
+	RevokeID(ctx context.Context, jti string) error
+	// RevokeSubject revokes all tokens of the subject issued before the time.
+	RevokeSubject(ctx context.Context, sub string, before time.Time) error
+}
+
+// RevocationStore keeps revoked token ids and subjects
+// until the revoked tokens expire.
+type RevocationStore interface {
+	// RevokeID revokes the token with the jti, the entry is kept until expiresAt.
+	RevokeID(ctx context.Context, jti string, expiresAt time.Time) error
+	// RevokeSubject revokes tokens of the subject issued before the time,
+	// the entry is kept until expiresAt.
+	RevokeSubject(ctx context.Context, sub string, before, expiresAt time.Time) error
+	// Revoked reports whether the token with the jti, subject and issue time is revoked.
+	Revoked(ctx context.Context, jti, sub string, issuedAt time.Time) (bool, error)
+	// Purge removes entries that expired before now.
+	Purge(ctx context.Context, now time.Time) error
+}
+
+// Refresher issues pairs of short-lived access tokens and opaque
+// refresh tokens, and exchanges refresh tokens for new pairs.
+type Refresher interface {
+	TokenPair(ctx context.Context, payload []byte, opts ...TokenOption) (*TokenPair, error)
+	Refresh(ctx context.Context, refreshToken []byte) (*TokenPair, error)
+	// Revoke revokes the refresh token and all refresh tokens of its
+	// family, or returns ErrOtherClient if the family is bound to
+	// another client than clientID.
+	Revoke(ctx context.Context, refreshToken []byte, clientID string) error
+	// RevokeSubject revokes refresh tokens of the subject of families
+	// started before the time.
+	RevokeSubject(ctx context.Context, sub string, before time.Time) error
+}
+
+// RefreshToken is a stored refresh token. Tokens issued by
+// refreshing each other belong to the same family.
+type RefreshToken struct {
+	// ID is a hash of the opaque refresh token.
+	ID      string       `json:"id"`
+	Family  string       `json:"family"`
+	Payload []byte       `json:"payload,omitempty"`
+	Options TokenOptions `json:"options"`
+	// ClientID is the OAuth 2.0 client the family is bound to, from
+	// the client_id claim of its tokens, empty if there is none.
+	ClientID string `json:"client_id,omitempty"`
+	// Used is set once the token is exchanged for a new pair.
+	Used bool `json:"used"`
+	// AuthTime is when the family was started, kept across refreshes.
+	AuthTime  time.Time `json:"auth_time"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RefreshStore keeps refresh tokens until they expire.
+type RefreshStore interface {
+	Create(ctx context.Context, rt *RefreshToken) error
+	// Token returns the token without using it,
+	// or ErrNotFound if there is no such token.
+	Token(ctx context.Context, id string) (*RefreshToken, error)
+	// Use marks the token used and returns it as it was before,
+	// or ErrNotFound if there is no such token.
+	Use(ctx context.Context, id string) (*RefreshToken, error)
+	// RevokeFamily deletes all tokens of the family.
+	RevokeFamily(ctx context.Context, family string) error
+	// RevokeSubject deletes tokens of the subject of families
+	// started before the time.
+	RevokeSubject(ctx context.Context, sub string, before time.Time) error
+	// Purge removes tokens that expired before now.
+	Purge(ctx context.Context, now time.Time) error
+}
+
+// APIKey is a long-lived key of a machine client, exchanged for
+// short-lived tokens. Only a hash of the secret key is stored.
+type APIKey struct {
+	// ID is the public part of the key.
+	ID   string `json:"id"`
+	Hash string `json:"-"`
+	// Label describes the owner or purpose of the key.
+	Label string `json:"label,omitempty"`
+	// Subject of tokens issued for the key.
+	Subject    string    `json:"subject"`
+	Scopes     []string  `json:"scopes,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// RevokedAt is set once the key is revoked.
+	RevokedAt time.Time `json:"revoked_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKeyStore persists API keys.
+type APIKeyStore interface {
+	// Create stores a new key.
+	Create(ctx context.Context, k *APIKey) error
+	// APIKey returns the key or ErrNotFound.
+	APIKey(ctx context.Context, id string) (*APIKey, error)
+	// APIKeys returns all keys ordered by creation.
+	APIKeys(ctx context.Context) ([]*APIKey, error)
+	// Update replaces the existing key or returns ErrNotFound.
+	Update(ctx context.Context, k *APIKey) error
+}
+
+// NewAPIKey describes a key to create.
+type NewAPIKey struct {
+	Label   string   `json:"label,omitempty"`
+	Subject string   `json:"subject,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"`
+	// TTL is the lifetime of the key in seconds, a server default if zero.
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// APIKeyManager issues, authenticates and revokes API keys.
+type APIKeyManager interface {
+	// CreateAPIKey returns the secret key, which can't be retrieved later.
+	CreateAPIKey(ctx context.Context, nk NewAPIKey) (string, *APIKey, error)
+	APIKeys(ctx context.Context) ([]*APIKey, error)
+	// RevokeAPIKey revokes the key or returns ErrNotFound.
+	RevokeAPIKey(ctx context.Context, id string) error
+	// AuthenticateAPIKey returns the key if it is valid, or
+	// ErrInvalidCredentials if it is unknown, expired or revoked.
+	AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, error)
+}
+
+// RoleResolver finds roles assigned to subjects of tokens.
+type RoleResolver interface {
+	// Roles returns the roles of the subject
+	// or ErrNotFound if there is no such subject.
+	Roles(ctx context.Context, sub string) ([]string, error)
+}
+
+// Decision answers whether a token grants a permission.
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Reason explains the decision.
+	Reason  string `json:"reason"`
+	Subject string `json:"sub,omitempty"`
+}
+
+// Authorizer checks permissions granted by tokens.
+type Authorizer interface {
+	// Authorize validates the token and decides whether it grants
+	// the permission, invalid tokens are denied.
+	Authorize(ctx context.Context, token []byte, permission string) (*Decision, error)
+}
+
+// RequestIDHeader is the HTTP header of request ids,
+// lowercased it is the key of GRPC metadata.
+const RequestIDHeader = "X-Request-ID"
+
+// Key of the request id in contexts.
+type requestIDKey struct{}
+
+// WithRequestID attaches the request id to the context.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request id attached to
+// the context, empty if there is none.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
 
 // TokenResponse is used in HTTP server and
 // HTTP client for responses from server.
 type TokenResponse struct {
-	Token string `json:"token"`
+	Token   string `json:"token"`
+	IDToken string `json:"id_token,omitempty"`
+}
+
+// OAuthError is an RFC 6749 error response.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// Error implements error.
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// Error codes of token requests of the device flow, RFC 8628 section 3.5.
+const (
+	OAuthAuthorizationPending = "authorization_pending"
+	OAuthSlowDown             = "slow_down"
+	OAuthAccessDenied         = "access_denied"
+	OAuthExpiredToken         = "expired_token"
+)
+
+// DeviceAuthorization is the RFC 8628 device authorization response.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	// Interval is the minimum number of seconds between polls.
+	Interval int64 `json:"interval,omitempty"`
+}
+
+// TokenPair is used in HTTP server and
+// HTTP client for responses from server.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	// IssuedTokenType is set by RFC 8693 token exchange.
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
 }
 
 // TokenValidationResponse is used in HTTP server and
@@ -26,3 +379,171 @@ type TokenResponse struct {
 type TokenValidationResponse struct {
 	Valid bool `json:"valid"`
 }
+
+// JWK is a public key in the RFC 7517 JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKSet is used in HTTP server and
+// HTTP client for responses from server.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Introspection is the result of token introspection. It is used
+// in HTTP server and HTTP client for responses from server.
+type Introspection struct {
+	Valid bool `json:"valid"`
+	// Reason is one of the Reason constants if the token is not valid.
+	Reason string `json:"reason,omitempty"`
+	// Claims are all verified claims of a valid token.
+	Claims    map[string]any `json:"claims,omitempty"`
+	ExpiresAt int64          `json:"exp,omitempty"`
+	IssuedAt  int64          `json:"iat,omitempty"`
+	KeyID     string         `json:"kid,omitempty"`
+}
+
+// ProfileStore provides OpenID Connect claims of subjects.
+type ProfileStore interface {
+	// Profile returns the claims of the subject
+	// or ErrNotFound if there is no such subject.
+	Profile(ctx context.Context, sub string) (map[string]any, error)
+}
+
+// User is an account that logs in with a password.
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	// PasswordHash is a PHC encoded argon2id hash.
+	PasswordHash string `json:"-"`
+	// Profile holds OpenID Connect claims of the user.
+	Profile  map[string]any `json:"profile,omitempty"`
+	Disabled bool           `json:"disabled,omitempty"`
+	// TOTP is the second factor of the user, nil if not enrolled.
+	TOTP *TOTP `json:"-"`
+	// Credentials are the registered WebAuthn authenticators of the user.
+	Credentials []WebAuthnCredential `json:"-"`
+	// Roles grant the user permissions embedded in its tokens.
+	Roles     []string  `json:"roles,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MFA reports whether the user must pass a second factor to log in.
+func (u *User) MFA() bool {
+	return u.TOTP != nil && u.TOTP.Confirmed
+}
+
+// TOTP is an RFC 6238 authenticator of a user.
+type TOTP struct {
+	// Secret is base32 encoded.
+	Secret string `json:"secret"`
+	// Confirmed is set once the user proves the enrollment with a code.
+	Confirmed bool `json:"confirmed"`
+	// LastStep is the time step of the last accepted code,
+	// codes of it and earlier steps are rejected as replays.
+	LastStep int64 `json:"last_step"`
+	// RecoveryCodes are hashes of unused recovery codes.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	// PendingSecret is the secret of a replacement authenticator,
+	// the confirmed one stays in use until it is confirmed.
+	PendingSecret string `json:"pending_secret,omitempty"`
+}
+
+// WebAuthnCredential is a public key credential (passkey) of a user.
+type WebAuthnCredential struct {
+	// ID is the credential ID chosen by the authenticator.
+	ID []byte `json:"id"`
+	// PublicKey is COSE encoded.
+	PublicKey []byte `json:"-"`
+	// SignCount is the last signature counter of the authenticator,
+	// zero if it doesn't count.
+	SignCount uint32 `json:"sign_count"`
+	// AAGUID identifies the authenticator model.
+	AAGUID     []byte    `json:"aaguid,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// TOTPEnrollment is the secret of a new authenticator.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth URI of the secret for authenticator apps.
+	URI string `json:"otpauth_uri"`
+	// QRCode is a PNG image of the URI.
+	QRCode []byte `json:"qr_png"`
+}
+
+// LoginResponse is the response of a login step: a token, or an
+// MFA challenge of a user with a second factor.
+type LoginResponse struct {
+	Token    string `json:"token,omitempty"`
+	MFAToken string `json:"mfa_token,omitempty"`
+}
+
+// UserStore persists users.
+type UserStore interface {
+	// Create stores a new user or returns ErrAlreadyExists
+	// if the username is taken.
+	Create(ctx context.Context, u *User) error
+	// User returns the user or ErrNotFound.
+	User(ctx context.Context, id string) (*User, error)
+	// UserByUsername returns the user or ErrNotFound.
+	UserByUsername(ctx context.Context, username string) (*User, error)
+	// Users returns all users ordered by username.
+	Users(ctx context.Context) ([]*User, error)
+	// Update replaces the user or returns ErrNotFound.
+	Update(ctx context.Context, u *User) error
+	// Delete deletes the user or returns ErrNotFound.
+	Delete(ctx context.Context, id string) error
+}
+
+// UserUpdate changes the set fields of a user.
+type UserUpdate struct {
+	Password *string        `json:"password,omitempty"`
+	Profile  map[string]any `json:"profile,omitempty"`
+	Disabled *bool          `json:"disabled,omitempty"`
+	// ResetMFA removes the second factor, e.g. of a lost device.
+	ResetMFA bool `json:"reset_mfa,omitempty"`
+	// Roles replace the roles of the user if set.
+	Roles *[]string `json:"roles,omitempty"`
+}
+
+// UserManager authenticates and manages users.
+type UserManager interface {
+	// Authenticate returns the enabled user with the credentials
+	// or ErrInvalidCredentials.
+	Authenticate(ctx context.Context, username, password string) (*User, error)
+	CreateUser(ctx context.Context, username, password string, profile map[string]any) (*User, error)
+	User(ctx context.Context, id string) (*User, error)
+	UserByUsername(ctx context.Context, username string) (*User, error)
+	Users(ctx context.Context) ([]*User, error)
+	UpdateUser(ctx context.Context, id string, upd UserUpdate) (*User, error)
+	DeleteUser(ctx context.Context, id string) error
+
+	// EnrollTOTP enrolls an unconfirmed authenticator of the user for
+	// the issuer, a confirmed one stays in use until it is replaced.
+	EnrollTOTP(ctx context.Context, id, issuer string) (*TOTPEnrollment, error)
+	// ConfirmTOTP enables the enrolled authenticator if the code is
+	// valid and returns new recovery codes.
+	ConfirmTOTP(ctx context.Context, id, code string) ([]string, error)
+	// VerifyTOTP checks a code of the authenticator or an unused recovery
+	// code of the user and returns ErrInvalidCredentials if neither matches.
+	VerifyTOTP(ctx context.Context, id, code string) error
+
+	// AddCredential registers the WebAuthn credential of the user
+	// and returns ErrAlreadyExists if the user has it.
+	AddCredential(ctx context.Context, id string, cred WebAuthnCredential) error
+	// UseCredential records an assertion of the credential of the user and
+	// returns ErrInvalidCredentials if the signature counter didn't increase.
+	UseCredential(ctx context.Context, id string, credID []byte, signCount uint32) error
+}